@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// Defaults for automatic gain control
+const (
+	defaultAGCTargetDBFS = -20.0
+	defaultAGCGateDBFS   = -50.0
+	defaultAGCMinVolume  = 10
+	defaultAGCMaxVolume  = 100
+	defaultAGCAttack     = 500 * time.Millisecond
+	defaultAGCRelease    = 5 * time.Second
+	agcWindow            = 100 * time.Millisecond
+	agcClipDBFS          = -1.0 // peaks above this count as clipping
+	agcMinChange         = 0.02 // smallest volume change applied to the device
+)
+
+// agcConfig holds the automatic gain control settings of a device; zero
+// values select the defaults
+type agcConfig struct {
+	Enabled    bool    `json:"enabled"`
+	TargetDBFS float64 `json:"target_dbfs,omitempty"` // speech loudness to aim for
+	GateDBFS   float64 `json:"gate_dbfs,omitempty"`   // quieter input (at full volume) is treated as silence
+	MinVolume  int     `json:"min_volume,omitempty"`  // percent
+	MaxVolume  int     `json:"max_volume,omitempty"`  // percent
+	AttackMS   int     `json:"attack_ms,omitempty"`   // time constant for lowering the volume
+	ReleaseMS  int     `json:"release_ms,omitempty"`  // time constant for raising the volume
+}
+
+// withDefaults returns the settings with unset values filled in
+func (c agcConfig) withDefaults() agcConfig {
+	if c.TargetDBFS == 0 {
+		c.TargetDBFS = defaultAGCTargetDBFS
+	}
+	if c.GateDBFS == 0 {
+		c.GateDBFS = defaultAGCGateDBFS
+	}
+	if c.MinVolume == 0 {
+		c.MinVolume = defaultAGCMinVolume
+	}
+	if c.MaxVolume == 0 {
+		c.MaxVolume = defaultAGCMaxVolume
+	}
+	if c.AttackMS == 0 {
+		c.AttackMS = int(defaultAGCAttack / time.Millisecond)
+	}
+	if c.ReleaseMS == 0 {
+		c.ReleaseMS = int(defaultAGCRelease / time.Millisecond)
+	}
+	return c
+}
+
+// validate checks that the settings are usable
+func (c agcConfig) validate() error {
+	switch {
+	case c.TargetDBFS >= 0 || c.TargetDBFS < meterFloorDBFS:
+		return fmt.Errorf("target must be between %.0f and 0 dBFS", meterFloorDBFS)
+	case c.GateDBFS >= c.TargetDBFS:
+		return fmt.Errorf("gate must be below the target")
+	case c.MinVolume < 0 || c.MaxVolume > 100 || c.MinVolume > c.MaxVolume:
+		return fmt.Errorf("volume bounds must satisfy 0 <= min <= max <= 100")
+	case c.AttackMS < 0 || c.ReleaseMS < 0:
+		return fmt.Errorf("attack and release must not be negative")
+	}
+	return nil
+}
+
+// agcController adjusts a device volume so the measured speech loudness
+// approaches the target. The device volume is assumed to scale the signal
+// amplitude linearly; the attack and release time constants smooth out the
+// error of that assumption along with the natural variation of speech.
+type agcController struct {
+	settings agcConfig
+	volume   float64 // smoothed volume, 0.0-1.0
+	applied  float64 // volume last set on the device
+}
+
+// newAGCController creates a controller for a device currently at volume
+func newAGCController(settings agcConfig, volume float64) *agcController {
+	return &agcController{settings: settings.withDefaults(), volume: volume, applied: volume}
+}
+
+// update feeds one reading covering dt and returns the volume to apply and
+// whether it differs enough from the applied volume to be worth setting
+func (c *agcController) update(r levelReading, dt time.Duration) (float64, bool) {
+	s := c.settings
+	minVolume, maxVolume := float64(s.MinVolume)/100, float64(s.MaxVolume)/100
+
+	// Hold the volume through pauses in speech so noise is not amplified.
+	// The gate applies to the level the signal would have at full volume,
+	// so a soft speaker is not mistaken for silence once turned down.
+	inputDBFS := r.RMSDBFS
+	if c.applied > 0 {
+		inputDBFS -= 20 * math.Log10(c.applied)
+	}
+	if inputDBFS < s.GateDBFS && r.PeakDBFS < agcClipDBFS {
+		return c.applied, false
+	}
+
+	errorDB := s.TargetDBFS - r.RMSDBFS
+	if r.PeakDBFS >= agcClipDBFS {
+		errorDB = math.Min(errorDB, -6) // back off quickly from clipping
+	}
+	// The reading reflects the applied volume, not the smoothed one, so
+	// scaling the latter would keep drifting inside the minimum change and
+	// overshoot once it is applied
+	desired := c.applied * math.Pow(10, errorDB/20)
+	desired = math.Max(minVolume, math.Min(maxVolume, desired))
+
+	tau := time.Duration(s.ReleaseMS) * time.Millisecond
+	if desired < c.volume {
+		tau = time.Duration(s.AttackMS) * time.Millisecond
+	}
+	alpha := 1.0
+	if tau > 0 {
+		alpha = 1 - math.Exp(-dt.Seconds()/tau.Seconds())
+	}
+	c.volume += (desired - c.volume) * alpha
+
+	if math.Abs(c.volume-c.applied) < agcMinChange {
+		return c.applied, false
+	}
+	c.applied = c.volume
+	return c.applied, true
+}
+
+// agcManager runs the gain controllers of devices with AGC enabled
+type agcManager struct {
+	mu      sync.Mutex
+	runners map[string]context.CancelFunc
+}
+
+// Global AGC manager instance
+var agc = &agcManager{runners: make(map[string]context.CancelFunc)}
+
+// active reports whether AGC is controlling a device
+func (m *agcManager) active(deviceID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.runners[deviceID]
+	return ok
+}
+
+// start begins metering a device and adjusting its volume, replacing any
+// controller already running for it
+func (m *agcManager) start(deviceID string, settings agcConfig) error {
+	source, err := newCaptureSource(deviceID)
+	if err != nil {
+		return err
+	}
+	level, err := getSystemInputLevel(deviceID)
+	if err != nil {
+		return err
+	}
+
+	m.stop(deviceID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.runners[deviceID] = cancel
+	m.mu.Unlock()
+
+	controller := newAGCController(settings, float64(level)/100)
+	go func() {
+		err := runMeter(ctx, source, agcWindow, func(r levelReading) {
+			state.mu.RLock()
+			paused := state.pausedLocked(deviceID)
+			name := state.deviceNameLocked(deviceID)
+			state.mu.RUnlock()
+			if paused || ptt.silenced() {
+				return
+			}
+
+			previous := controller.applied
+			volume, changed := controller.update(r, agcWindow)
+			if !changed {
+				return
+			}
+			if err := setSystemInputLevel(deviceID, float32(volume)); err != nil {
+				slog.Error("Failed to apply AGC volume", "device", name, "device_id", deviceID, "error", err)
+				return
+			}
+			slog.Debug("AGC adjusted audio level",
+				"device", name, "device_id", deviceID, "old_volume", volumePercent(float32(previous)),
+				"new_volume", volumePercent(float32(volume)), "rms_dbfs", r.RMSDBFS, "source", sourceAGC)
+		})
+		if err != nil {
+			slog.Error("AGC stopped", "device_id", deviceID, "error", err)
+		}
+		m.mu.Lock()
+		if ctx.Err() == nil {
+			delete(m.runners, deviceID)
+		}
+		m.mu.Unlock()
+	}()
+
+	slog.Info("Started automatic gain control", "device_id", deviceID,
+		"target_dbfs", controller.settings.TargetDBFS,
+		"min_volume", controller.settings.MinVolume, "max_volume", controller.settings.MaxVolume)
+	return nil
+}
+
+// stop ends AGC for a device
+func (m *agcManager) stop(deviceID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cancel, ok := m.runners[deviceID]; ok {
+		cancel()
+		delete(m.runners, deviceID)
+	}
+}
+
+// stopAll ends AGC for every device
+func (m *agcManager) stopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, cancel := range m.runners {
+		cancel()
+		delete(m.runners, id)
+	}
+}
+
+// startConfiguredAGC starts AGC on connected devices that have it enabled in
+// the config file
+func startConfiguredAGC() {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+		return
+	}
+
+	for _, device := range knownDevices() {
+		dc, ok := cfg.Devices[device.ID]
+		if !ok || dc.AGC == nil || !dc.AGC.Enabled || agc.active(device.ID) {
+			continue
+		}
+		if err := agc.start(device.ID, *dc.AGC); err != nil {
+			slog.Error("Failed to start automatic gain control",
+				"device", device.Name, "device_id", device.ID, "error", err)
+		}
+	}
+}
+
+// setDeviceAGC saves the AGC settings of a device and starts or stops its controller
+func setDeviceAGC(deviceID string, settings agcConfig) error {
+	if err := settings.withDefaults().validate(); err != nil {
+		return err
+	}
+
+	err := updateConfig(func(cfg *appConfig) {
+		s := settings
+		cfg.device(deviceID).AGC = &s
+	})
+	if err != nil {
+		return err
+	}
+
+	if !settings.Enabled {
+		agc.stop(deviceID)
+		slog.Info("Stopped automatic gain control", "device_id", deviceID, "source", sourceUser)
+		return nil
+	}
+	return agc.start(deviceID, settings)
+}
+
+// agcSimulation summarises a simulated AGC run
+type agcSimulation struct {
+	Duration      time.Duration `json:"duration"`
+	InitialVolume int           `json:"initial_volume"`
+	FinalVolume   int           `json:"final_volume"`
+	Adjustments   int           `json:"adjustments"`
+	ClippedWindow int           `json:"clipped_windows"`
+	SpeechRMSDBFS float64       `json:"speech_rms_dbfs"` // average over windows above the gate
+}
+
+// agcSimStep describes one window of a simulated AGC run
+type agcSimStep struct {
+	Time    time.Duration `json:"time"`
+	Reading levelReading  `json:"reading"`
+	Volume  int           `json:"volume"`
+	Changed bool          `json:"changed"`
+}
+
+// simulateAGC runs a controller against recorded audio, scaling the samples
+// by the simulated device volume as the hardware would, and reports every
+// window to fn
+func simulateAGC(source *wavSource, settings agcConfig, initialVolume float64, fn func(agcSimStep)) (agcSimulation, error) {
+	controller := newAGCController(settings, initialVolume)
+	result := agcSimulation{InitialVolume: volumePercent(float32(initialVolume))}
+
+	var elapsed time.Duration
+	var speechSum float64
+	var speechWindows int
+	sampleRate, channels := source.format()
+	meter := newLevelMeter(agcWindow, sampleRate, channels, func(r levelReading) {
+		elapsed += agcWindow
+		volume, changed := controller.update(r, agcWindow)
+		if changed {
+			result.Adjustments++
+		}
+		if r.PeakDBFS >= agcClipDBFS {
+			result.ClippedWindow++
+		}
+		if r.RMSDBFS >= controller.settings.GateDBFS {
+			speechSum += r.RMSDBFS
+			speechWindows++
+		}
+		fn(agcSimStep{Time: elapsed, Reading: r, Volume: volumePercent(float32(volume)), Changed: changed})
+	})
+
+	scaled := make([]float32, 0, wavChunkFrames*channels)
+	err := source.run(context.Background(), func(samples []float32) {
+		gain := float32(controller.applied)
+		scaled = scaled[:0]
+		for _, s := range samples {
+			v := s * gain
+			scaled = append(scaled, max(-1, min(1, v)))
+		}
+		meter.process(scaled)
+	})
+
+	result.Duration = elapsed
+	result.FinalVolume = volumePercent(float32(controller.applied))
+	if speechWindows > 0 {
+		result.SpeechRMSDBFS = speechSum / float64(speechWindows)
+	}
+	return result, err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// simulateTone runs AGC against a tone whose RMS at full device volume is
+// rmsDBFS and returns the summary and every step
+func simulateTone(t *testing.T, rmsDBFS float64, d time.Duration, settings agcConfig, initialVolume float64) (agcSimulation, []agcSimStep) {
+	t.Helper()
+	amplitude := math.Pow(10, rmsDBFS/20) * math.Sqrt2
+	path := writeTestWAV(t, wavFormat{bits: 32, float: true}, 16000, 1, tone(amplitude, 440, 16000, 1, d))
+	source, err := openWAVSource(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []agcSimStep
+	result, err := simulateAGC(source, settings, initialVolume, func(step agcSimStep) {
+		steps = append(steps, step)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, steps
+}
+
+// reversals counts the times the applied volume changed direction
+func reversals(steps []agcSimStep) int {
+	count, direction, last := 0, 0, -1
+	for _, step := range steps {
+		if !step.Changed {
+			continue
+		}
+		if last >= 0 && step.Volume != last {
+			d := 1
+			if step.Volume < last {
+				d = -1
+			}
+			if direction != 0 && d != direction {
+				count++
+			}
+			direction = d
+		}
+		last = step.Volume
+	}
+	return count
+}
+
+func TestAGCConverges(t *testing.T) {
+	tests := []struct {
+		name          string
+		inputDBFS     float64 // RMS at full volume
+		minVolume     int
+		initialVolume float64
+		wantVolume    int // percent that brings the input to the target
+	}{
+		{"lowers a loud input", -6, 0, 1.0, 20},
+		{"raises a soft input", -6, 0, 0.1, 20},
+		{"raises a quiet input to the maximum", -30, 0, 0.5, 100},
+		{"lowers a loud input to the minimum", -6, 30, 1.0, 30},
+		{"keeps a matched input", -20, 0, 1.0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := agcConfig{Enabled: true, TargetDBFS: -20, MinVolume: tt.minVolume}
+			result, steps := simulateTone(t, tt.inputDBFS, 40*time.Second, settings, tt.initialVolume)
+
+			if diff := result.FinalVolume - tt.wantVolume; diff < -2 || diff > 2 {
+				t.Errorf("final volume = %d%%, want %d%%", result.FinalVolume, tt.wantVolume)
+			}
+			if n := reversals(steps); n > 0 {
+				t.Errorf("volume changed direction %d times, want it to approach the target monotonically", n)
+			}
+
+			// Settled: nothing left to adjust over the last ten seconds
+			for _, step := range steps[len(steps)-100:] {
+				if step.Changed {
+					t.Fatalf("volume still changing to %d%% at %s", step.Volume, step.Time)
+				}
+			}
+			if tt.wantVolume > tt.minVolume && tt.wantVolume < 100 {
+				if last := steps[len(steps)-1].Reading.RMSDBFS; math.Abs(last-settings.TargetDBFS) > 1 {
+					t.Errorf("settled level = %.1f dBFS, want within 1 dB of %.0f", last, settings.TargetDBFS)
+				}
+			}
+		})
+	}
+}
+
+func TestAGCAttackIsFasterThanRelease(t *testing.T) {
+	settings := agcConfig{Enabled: true, TargetDBFS: -20}
+	_, down := simulateTone(t, -6, 10*time.Second, settings, 1.0)
+	_, up := simulateTone(t, -26, 10*time.Second, settings, 0.5)
+
+	// After a second the attack has covered most of the way down while the
+	// release has covered a fraction of the way up
+	if v := down[9].Volume; v > 40 {
+		t.Errorf("volume after lowering for a second = %d%%, want at most 40%%", v)
+	}
+	if v := up[9].Volume; v > 70 {
+		t.Errorf("volume after raising for a second = %d%%, want at most 70%%", v)
+	}
+}
+
+func TestAGCBacksOffClipping(t *testing.T) {
+	settings := agcConfig{Enabled: true, TargetDBFS: -20}
+	// A full-scale tone clips until the volume comes down
+	result, steps := simulateTone(t, -3, 5*time.Second, settings, 1.0)
+	if result.ClippedWindow == 0 {
+		t.Fatal("the tone never clipped")
+	}
+	for _, step := range steps[10:] {
+		if step.Reading.PeakDBFS >= agcClipDBFS {
+			t.Fatalf("still clipping at %s", step.Time)
+		}
+	}
+}
+
+func TestAGCHoldsThroughSilence(t *testing.T) {
+	settings := agcConfig{Enabled: true, TargetDBFS: -20}
+	// Below the -50 dBFS gate the input counts as a pause in speech
+	result, _ := simulateTone(t, -60, 10*time.Second, settings, 0.4)
+	if result.Adjustments != 0 || result.FinalVolume != 40 {
+		t.Errorf("silence made %d adjustments ending at %d%%, want the volume held at 40%%", result.Adjustments, result.FinalVolume)
+	}
+}
+
+func TestAGCConfigValidate(t *testing.T) {
+	for _, c := range []agcConfig{
+		{TargetDBFS: 3},
+		{TargetDBFS: -20, GateDBFS: -10},
+		{TargetDBFS: -20, GateDBFS: -50, MinVolume: 60, MaxVolume: 40},
+		{TargetDBFS: -20, GateDBFS: -50, MinVolume: 10, MaxVolume: 100, AttackMS: -1},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("validate(%+v) succeeded, want an error", c)
+		}
+	}
+	if err := (agcConfig{Enabled: true}).withDefaults().validate(); err != nil {
+		t.Errorf("default settings are invalid: %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Defaults for signal alerts
+const (
+	defaultSilenceDBFS    = -70.0
+	defaultSilenceSeconds = 30
+	defaultClipDBFS       = -0.5
+	defaultClipCount      = 5
+	defaultClipSeconds    = 10
+	alertWindow           = 100 * time.Millisecond
+)
+
+// alertsConfig holds the thresholds of the dead-mic and clipping detectors,
+// which meter every enforced device while enabled; zero values select the defaults
+type alertsConfig struct {
+	Enabled        bool    `json:"enabled"`
+	SilenceDBFS    float64 `json:"silence_dbfs,omitempty"`    // noise floor; quieter input counts as silence
+	SilenceSeconds int     `json:"silence_seconds,omitempty"` // how long silence lasts before alerting
+	ClipDBFS       float64 `json:"clip_dbfs,omitempty"`       // peaks at or above this count as clipping
+	ClipCount      int     `json:"clip_count,omitempty"`      // clipped windows needed to alert ...
+	ClipSeconds    int     `json:"clip_seconds,omitempty"`    // ... within this many seconds
+}
+
+// withDefaults returns the settings with unset values filled in
+func (c alertsConfig) withDefaults() alertsConfig {
+	if c.SilenceDBFS == 0 {
+		c.SilenceDBFS = defaultSilenceDBFS
+	}
+	if c.SilenceSeconds == 0 {
+		c.SilenceSeconds = defaultSilenceSeconds
+	}
+	if c.ClipDBFS == 0 {
+		c.ClipDBFS = defaultClipDBFS
+	}
+	if c.ClipCount == 0 {
+		c.ClipCount = defaultClipCount
+	}
+	if c.ClipSeconds == 0 {
+		c.ClipSeconds = defaultClipSeconds
+	}
+	return c
+}
+
+// silenceDetector reports input that stays below the noise floor, as from a
+// microphone that is unplugged behind an interface still reporting 100%
+type silenceDetector struct {
+	floor     float64
+	limit     time.Duration
+	silentFor time.Duration
+	alerted   bool
+}
+
+// update feeds one reading covering dt and reports whether the silence has
+// just lasted long enough to alert; it alerts again only after the signal returns
+func (d *silenceDetector) update(r levelReading, dt time.Duration) bool {
+	if r.PeakDBFS >= d.floor {
+		d.silentFor = 0
+		d.alerted = false
+		return false
+	}
+
+	d.silentFor += dt
+	if d.silentFor >= d.limit && !d.alerted {
+		d.alerted = true
+		return true
+	}
+	return false
+}
+
+// pause restarts the silence count without forgetting an alert already
+// raised, for while the input is silenced on purpose
+func (d *silenceDetector) pause() {
+	d.silentFor = 0
+}
+
+// retract takes back the alert just reported and restarts the silence count,
+// for silence that turned out to be on purpose
+func (d *silenceDetector) retract() {
+	d.silentFor = 0
+	d.alerted = false
+}
+
+// clipDetector reports repeated clipping near 0 dBFS
+type clipDetector struct {
+	threshold float64
+	count     int
+	window    time.Duration
+	elapsed   time.Duration
+	clips     []time.Duration // times of recent clipped windows
+	quietTill time.Duration   // no new alert before this time
+}
+
+// update feeds one reading covering dt and reports the number of clipped
+// windows when enough occurred within the window, or 0
+func (d *clipDetector) update(r levelReading, dt time.Duration) int {
+	d.elapsed += dt
+	if r.PeakDBFS < d.threshold {
+		return 0
+	}
+
+	d.clips = append(d.clips, d.elapsed)
+	for len(d.clips) > 0 && d.elapsed-d.clips[0] > d.window {
+		d.clips = d.clips[1:]
+	}
+	if len(d.clips) < d.count || d.elapsed < d.quietTill {
+		return 0
+	}
+
+	count := len(d.clips)
+	d.clips = d.clips[:0]
+	d.quietTill = d.elapsed + d.window
+	return count
+}
+
+// alertMonitor meters enforced devices and raises silence and clipping alerts
+type alertMonitor struct {
+	mu         sync.Mutex
+	settings   alertsConfig
+	running    bool
+	runners    map[string]context.CancelFunc
+	stopSync   func()
+	openSource func(deviceID string) (sampleSource, error) // nil to capture from the device
+	readMute   func(deviceID string) (bool, error)         // nil to read the system mute state
+}
+
+// Global alert monitor instance
+var alerts = &alertMonitor{runners: make(map[string]context.CancelFunc)}
+
+// start begins monitoring the enforced devices if alerts are enabled in the
+// config file, following devices as they are checked and unchecked
+func (m *alertMonitor) start() {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+	}
+	if cfg.Alerts == nil || !cfg.Alerts.Enabled {
+		return
+	}
+
+	ch, unsubscribe := events.subscribe(64)
+	m.mu.Lock()
+	m.settings = cfg.Alerts.withDefaults()
+	m.running = true
+	m.stopSync = unsubscribe
+	settings := m.settings
+	m.mu.Unlock()
+
+	m.sync()
+	go func() {
+		for ev := range ch {
+			switch ev.Type {
+			case eventDeviceChecked, eventDeviceAdded, eventDeviceUnchecked, eventDeviceRemoved:
+				m.sync()
+			}
+		}
+	}()
+
+	slog.Info("Signal alerts enabled",
+		"silence_dbfs", settings.SilenceDBFS, "silence_seconds", settings.SilenceSeconds,
+		"clip_dbfs", settings.ClipDBFS, "clip_count", settings.ClipCount, "clip_seconds", settings.ClipSeconds)
+}
+
+// stop ends all monitoring
+func (m *alertMonitor) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.running = false
+	if m.stopSync != nil {
+		m.stopSync()
+		m.stopSync = nil
+	}
+	for id, cancel := range m.runners {
+		cancel()
+		delete(m.runners, id)
+	}
+}
+
+// sync meters the enforced devices that are present and stops metering the
+// others. The state is rescanned rather than following single events, so
+// events dropped during a burst are made up for by any later one.
+func (m *alertMonitor) sync() {
+	state.mu.RLock()
+	present := make(map[string]bool, len(state.audioInputDevices))
+	for _, device := range state.audioInputDevices {
+		present[device.ID.String()] = true
+	}
+	var watched []string
+	for id, enabled := range state.deviceStates {
+		if enabled && present[id] {
+			watched = append(watched, id)
+		}
+	}
+	state.mu.RUnlock()
+
+	m.mu.Lock()
+	for id, cancel := range m.runners {
+		if !slices.Contains(watched, id) {
+			cancel()
+			delete(m.runners, id)
+		}
+	}
+	m.mu.Unlock()
+	for _, id := range watched {
+		m.watch(id)
+	}
+}
+
+// watch starts metering a device unless it is already monitored
+func (m *alertMonitor) watch(deviceID string) {
+	m.mu.Lock()
+	if _, ok := m.runners[deviceID]; ok || !m.running {
+		m.mu.Unlock()
+		return
+	}
+	settings := m.settings
+	openSource := m.openSource
+	readMute := m.readMute
+	m.mu.Unlock()
+
+	if openSource == nil {
+		openSource = func(id string) (sampleSource, error) { return newCaptureSource(id) }
+	}
+	if readMute == nil {
+		readMute = getSystemInputMute
+	}
+	source, err := openSource(deviceID)
+	if err != nil {
+		slog.Warn("Cannot monitor device for alerts", "device_id", deviceID, "error", err)
+		return
+	}
+
+	// Monitoring may have stopped, or the device been watched by another
+	// call, while the source was opened
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	if _, ok := m.runners[deviceID]; ok || !m.running {
+		m.mu.Unlock()
+		cancel()
+		return
+	}
+	m.runners[deviceID] = cancel
+	m.mu.Unlock()
+
+	silence := &silenceDetector{
+		floor: settings.SilenceDBFS,
+		limit: time.Duration(settings.SilenceSeconds) * time.Second,
+	}
+	clipping := &clipDetector{
+		threshold: settings.ClipDBFS,
+		count:     settings.ClipCount,
+		window:    time.Duration(settings.ClipSeconds) * time.Second,
+	}
+
+	go func() {
+		err := runMeter(ctx, source, alertWindow, func(r levelReading) {
+			if silencedOnPurpose(deviceID) {
+				silence.pause()
+			} else if silence.update(r, alertWindow) {
+				// A muted device is silent too; its mute state is read only
+				// once the silence has lasted long enough to alert
+				if muted, err := readMute(deviceID); err == nil && muted {
+					silence.retract()
+					return
+				}
+				m.raise(appEvent{
+					Type:     eventSilenceDetected,
+					DeviceID: deviceID,
+					Level:    &r.PeakDBFS,
+					Count:    settings.SilenceSeconds,
+				})
+			}
+			if count := clipping.update(r, alertWindow); count > 0 {
+				m.raise(appEvent{
+					Type:     eventClippingDetected,
+					DeviceID: deviceID,
+					Level:    &r.PeakDBFS,
+					Count:    count,
+				})
+			}
+		})
+		if err != nil {
+			slog.Error("Signal monitoring stopped", "device_id", deviceID, "error", err)
+		}
+
+		// A meter that ended by itself leaves the device free to be watched
+		// again; one that was cancelled has already been removed
+		m.mu.Lock()
+		if ctx.Err() == nil {
+			delete(m.runners, deviceID)
+		}
+		m.mu.Unlock()
+		cancel()
+	}()
+}
+
+// silencedOnPurpose reports whether MicMaxer expects a device to be silent:
+// kept muted by its mute policy, paused or silenced by push-to-talk. It is
+// checked on every reading, so it leaves the device itself alone.
+func silencedOnPurpose(deviceID string) bool {
+	if ptt.silenced() {
+		return true
+	}
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	return state.mutePolicies[deviceID] == mutePolicyMuted || state.pausedLocked(deviceID)
+}
+
+// raise logs and publishes an alert; the notifier shows it on the desktop
+// if notifications of its type are enabled
+func (m *alertMonitor) raise(ev appEvent) {
+	state.mu.RLock()
+	ev.DeviceName = state.deviceNameLocked(ev.DeviceID)
+	state.mu.RUnlock()
+
+	switch ev.Type {
+	case eventSilenceDetected:
+		slog.Warn("Microphone is silent", "device", ev.DeviceName, "device_id", ev.DeviceID,
+			"seconds", ev.Count, "peak_dbfs", *ev.Level)
+	case eventClippingDetected:
+		slog.Warn("Microphone is clipping", "device", ev.DeviceName, "device_id", ev.DeviceID,
+			"clipped_windows", ev.Count, "peak_dbfs", *ev.Level)
+	}
+	events.publish(ev)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// feedSilence feeds n readings at peak dBFS and returns how many alerted
+func feedSilence(d *silenceDetector, peak float64, n int) int {
+	alerts := 0
+	for i := 0; i < n; i++ {
+		if d.update(levelReading{PeakDBFS: peak}, alertWindow) {
+			alerts++
+		}
+	}
+	return alerts
+}
+
+func TestSilenceDetector(t *testing.T) {
+	d := &silenceDetector{floor: -70, limit: 30 * time.Second}
+	perSecond := int(time.Second / alertWindow)
+
+	if n := feedSilence(d, -90, 30*perSecond-1); n != 0 {
+		t.Fatalf("alerted %d times before the limit", n)
+	}
+	if n := feedSilence(d, -90, 1); n != 1 {
+		t.Fatalf("alerted %d times at the limit, want 1", n)
+	}
+	if n := feedSilence(d, -90, 120*perSecond); n != 0 {
+		t.Fatalf("alerted %d more times during the same silence", n)
+	}
+
+	// The signal coming back re-arms the alert
+	if n := feedSilence(d, -30, 1); n != 0 {
+		t.Fatalf("alerted %d times on signal", n)
+	}
+	if n := feedSilence(d, -90, 30*perSecond); n != 1 {
+		t.Fatalf("alerted %d times on the next silence, want 1", n)
+	}
+}
+
+func TestSilenceDetectorPause(t *testing.T) {
+	d := &silenceDetector{floor: -70, limit: 30 * time.Second}
+	perSecond := int(time.Second / alertWindow)
+
+	// A deliberate silence restarts the count
+	feedSilence(d, -90, 20*perSecond)
+	d.pause()
+	if n := feedSilence(d, -90, 20*perSecond); n != 0 {
+		t.Fatalf("alerted %d times after a pause, want the count restarted", n)
+	}
+
+	// A pause does not re-arm an alert already raised
+	feedSilence(d, -90, 10*perSecond)
+	d.pause()
+	if n := feedSilence(d, -90, 30*perSecond); n != 0 {
+		t.Fatalf("alerted %d times again during the same silence", n)
+	}
+}
+
+func TestClipDetector(t *testing.T) {
+	d := &clipDetector{threshold: -1, count: 5, window: 10 * time.Second}
+	clip := levelReading{PeakDBFS: -0.1}
+	clean := levelReading{PeakDBFS: -12}
+	second := func(r levelReading) int {
+		count := 0
+		for i := 0; i < int(time.Second/alertWindow); i++ {
+			reading := clean
+			if i == 0 {
+				reading = r
+			}
+			count += d.update(reading, alertWindow)
+		}
+		return count
+	}
+
+	// Clips too sparse to reach the count within the window never alert
+	for i := 0; i < 8; i++ {
+		if n := second(clip); n != 0 {
+			t.Fatalf("alerted with %d clips spread over %ds", n, i+1)
+		}
+		for j := 0; j < 2; j++ {
+			second(clean)
+		}
+	}
+
+	// Five clips within the window alert once
+	d = &clipDetector{threshold: -1, count: 5, window: 10 * time.Second}
+	got := 0
+	for i := 0; i < 5; i++ {
+		got += second(clip)
+	}
+	if got != 5 {
+		t.Fatalf("alert counted %d clips, want 5", got)
+	}
+
+	// Further clipping stays quiet until the window has passed
+	for i := 0; i < 5; i++ {
+		if n := second(clip); n != 0 {
+			t.Fatalf("alerted again %ds after the first alert", i+1)
+		}
+	}
+	got = 0
+	for i := 0; i < 5; i++ {
+		got += second(clip)
+	}
+	if got == 0 {
+		t.Error("no alert once the quiet period was over")
+	}
+}
+
+func TestSilencedOnPurpose(t *testing.T) {
+	const id = "alerts-test-device"
+	if silencedOnPurpose(id) {
+		t.Fatal("device without a mute policy reported as silenced on purpose")
+	}
+
+	state.mu.Lock()
+	saved, had := state.mutePolicies[id]
+	state.mutePolicies[id] = mutePolicyMuted
+	state.mu.Unlock()
+	defer func() {
+		state.mu.Lock()
+		if had {
+			state.mutePolicies[id] = saved
+		} else {
+			delete(state.mutePolicies, id)
+		}
+		state.mu.Unlock()
+	}()
+
+	if !silencedOnPurpose(id) {
+		t.Error("device kept muted by its policy not reported as silenced on purpose")
+	}
+}
+
+// failingSource is a sample source whose capture fails at once
+type failingSource struct{}
+
+func (failingSource) format() (int, int) { return meterSampleRate, 1 }
+func (failingSource) live() bool         { return true }
+func (failingSource) run(ctx context.Context, fn func([]float32)) error {
+	return errors.New("device disappeared")
+}
+
+// blockingSource is a sample source delivering nothing until cancelled
+type blockingSource struct{ started chan struct{} }
+
+func (blockingSource) format() (int, int) { return meterSampleRate, 1 }
+func (blockingSource) live() bool         { return true }
+func (s blockingSource) run(ctx context.Context, fn func([]float32)) error {
+	close(s.started)
+	<-ctx.Done()
+	return nil
+}
+
+// silentSource is a recorded source of silence lasting a number of seconds
+type silentSource struct{ seconds int }
+
+func (silentSource) format() (int, int) { return meterSampleRate, 1 }
+func (silentSource) live() bool         { return false }
+func (s silentSource) run(ctx context.Context, fn func([]float32)) error {
+	second := make([]float32, meterSampleRate)
+	for i := 0; i < s.seconds && ctx.Err() == nil; i++ {
+		fn(second)
+	}
+	return nil
+}
+
+// waitUnwatched waits for the meter of a device to end
+func waitUnwatched(t *testing.T, m *alertMonitor, deviceID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.watching(deviceID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("meter of %s still running", deviceID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// watching reports whether a device has a running meter
+func (m *alertMonitor) watching(deviceID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.runners[deviceID]
+	return ok
+}
+
+func TestAlertMonitorWatchesAgainAfterFailure(t *testing.T) {
+	opened := make(chan struct{}, 4)
+	m := &alertMonitor{
+		running: true,
+		runners: make(map[string]context.CancelFunc),
+		openSource: func(string) (sampleSource, error) {
+			opened <- struct{}{}
+			return failingSource{}, nil
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		m.watch("mic")
+		<-opened
+		deadline := time.Now().Add(2 * time.Second)
+		for m.watching("mic") {
+			if time.Now().After(deadline) {
+				t.Fatalf("watch %d: failed meter still registered", i+1)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestAlertMonitorStopWhileOpening(t *testing.T) {
+	started := make(chan struct{})
+	m := &alertMonitor{running: true, runners: make(map[string]context.CancelFunc)}
+	m.openSource = func(string) (sampleSource, error) {
+		// Monitoring stops while the source is being opened
+		m.stop()
+		return blockingSource{started: started}, nil
+	}
+
+	m.watch("mic")
+	if m.watching("mic") {
+		t.Fatal("meter registered after monitoring stopped")
+	}
+	select {
+	case <-started:
+		t.Fatal("meter started after monitoring stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAlertMonitorStopCancelsMeters(t *testing.T) {
+	started := make(chan struct{})
+	m := &alertMonitor{
+		running: true,
+		runners: make(map[string]context.CancelFunc),
+		openSource: func(string) (sampleSource, error) {
+			return blockingSource{started: started}, nil
+		},
+	}
+
+	m.watch("mic")
+	<-started
+	m.watch("mic") // already monitored
+	m.stop()
+	if m.watching("mic") {
+		t.Error("meter still registered after stop")
+	}
+}
+
+func TestAlertMonitorReadsMuteBeforeAlerting(t *testing.T) {
+	useTestState(t)
+	tests := []struct {
+		muted      bool
+		reads      int
+		wantAlerts int
+	}{
+		// 35 seconds of silence reach the 10 second limit three times
+		{muted: true, reads: 3, wantAlerts: 0},
+		// Once alerted, silence is not alerted or checked again
+		{muted: false, reads: 1, wantAlerts: 1},
+	}
+	for _, tt := range tests {
+		ch, unsubscribe := events.subscribe(16)
+		reads := 0
+		m := &alertMonitor{
+			settings: alertsConfig{SilenceSeconds: 10}.withDefaults(),
+			running:  true,
+			runners:  make(map[string]context.CancelFunc),
+			openSource: func(string) (sampleSource, error) {
+				return silentSource{seconds: 35}, nil
+			},
+			readMute: func(string) (bool, error) {
+				reads++
+				return tt.muted, nil
+			},
+		}
+
+		m.watch("mic")
+		waitUnwatched(t, m, "mic")
+		unsubscribe()
+		alerts := 0
+		for ev := range ch {
+			if ev.Type == eventSilenceDetected {
+				alerts++
+			}
+		}
+		if reads != tt.reads || alerts != tt.wantAlerts {
+			t.Errorf("muted %v: %d mute reads and %d alerts, want %d and %d", tt.muted, reads, alerts, tt.reads, tt.wantAlerts)
+		}
+	}
+}
+
+func TestAlertMonitorSync(t *testing.T) {
+	st := useTestState(t)
+	var a, b malgo.DeviceInfo
+	a.ID[0], b.ID[0] = 0x0a, 0x0b
+	st.audioInputDevices = []malgo.DeviceInfo{a, b}
+	st.deviceStates[a.ID.String()] = true
+	st.deviceStates[b.ID.String()] = true
+	st.deviceStates["gone"] = true
+
+	m := &alertMonitor{
+		running: true,
+		runners: make(map[string]context.CancelFunc),
+		openSource: func(string) (sampleSource, error) {
+			return blockingSource{started: make(chan struct{})}, nil
+		},
+	}
+	defer m.stop()
+
+	// Enforced devices that are present are metered
+	m.sync()
+	if !m.watching(a.ID.String()) || !m.watching(b.ID.String()) || m.watching("gone") {
+		t.Fatalf("after sync, watching a %v, b %v, gone %v; want a and b", m.watching(a.ID.String()), m.watching(b.ID.String()), m.watching("gone"))
+	}
+
+	// A device unchecked without its event reaching the monitor stops being
+	// metered on the next sync
+	st.deviceStates[b.ID.String()] = false
+	m.sync()
+	if !m.watching(a.ID.String()) || m.watching(b.ID.String()) {
+		t.Errorf("after unchecking b, watching a %v, b %v; want only a", m.watching(a.ID.String()), m.watching(b.ID.String()))
+	}
+}
@@ -8,6 +8,9 @@ package main
 #include <CoreAudio/CoreAudio.h>
 #include <CoreFoundation/CoreFoundation.h>
 #include <stdio.h>
+#include <stdlib.h>
+#include <unistd.h>
+#include <libproc.h>
 #include <pthread.h>
 
 // Forward declaration of Go callback
@@ -560,6 +563,171 @@ static int setDefaultInputDeviceVolume(float volume) {
     return 0; // Success
 }
 
+// Set the mute state for an input device
+static int setDeviceMute(AudioDeviceID deviceID, int muted) {
+    // Set up the property address for mute
+    AudioObjectPropertyAddress propertyAddress = {
+        kAudioDevicePropertyMute,
+        kAudioDevicePropertyScopeInput,
+        kAudioObjectPropertyElementMain
+    };
+
+    // Check if the device has mute control
+    Boolean hasProperty = AudioObjectHasProperty(deviceID, &propertyAddress);
+    if (!hasProperty) {
+        return -2; // Device doesn't support mute control
+    }
+
+    UInt32 muteValue = muted ? 1 : 0;
+    OSStatus status = AudioObjectSetPropertyData(
+        deviceID,
+        &propertyAddress,
+        0,
+        NULL,
+        sizeof(UInt32),
+        &muteValue
+    );
+
+    if (status != noErr) {
+        return -3; // Error setting mute state
+    }
+
+    return 0; // Success
+}
+
+// Set the mute state for a specific input device by UID
+static int setInputDeviceMute(const char* deviceUID, int muted) {
+    AudioDeviceID deviceID = getAudioDeviceIDFromUID(deviceUID);
+    if (deviceID == kAudioDeviceUnknown) {
+        return -1; // Error getting device
+    }
+
+    return setDeviceMute(deviceID, muted);
+}
+
+// Set the mute state for the default input device
+static int setDefaultInputDeviceMute(int muted) {
+    AudioDeviceID deviceID;
+    UInt32 size = sizeof(AudioDeviceID);
+
+    // Get the default input device
+    AudioObjectPropertyAddress propertyAddress = {
+        kAudioHardwarePropertyDefaultInputDevice,
+        kAudioObjectPropertyScopeGlobal,
+        kAudioObjectPropertyElementMain
+    };
+
+    OSStatus status = AudioObjectGetPropertyData(
+        kAudioObjectSystemObject,
+        &propertyAddress,
+        0,
+        NULL,
+        &size,
+        &deviceID
+    );
+
+    if (status != noErr || deviceID == kAudioDeviceUnknown) {
+        return -1; // Error getting device
+    }
+
+    return setDeviceMute(deviceID, muted);
+}
+
+// Make a device the default input device by UID
+static int setDefaultInputDeviceByUID(const char* deviceUID) {
+    AudioDeviceID deviceID = getAudioDeviceIDFromUID(deviceUID);
+    if (deviceID == kAudioDeviceUnknown) {
+        return -1; // Error getting device
+    }
+
+    AudioObjectPropertyAddress propertyAddress = {
+        kAudioHardwarePropertyDefaultInputDevice,
+        kAudioObjectPropertyScopeGlobal,
+        kAudioObjectPropertyElementMain
+    };
+
+    OSStatus status = AudioObjectSetPropertyData(
+        kAudioObjectSystemObject,
+        &propertyAddress,
+        0,
+        NULL,
+        sizeof(AudioDeviceID),
+        &deviceID
+    );
+
+    if (status != noErr) {
+        return -2; // Error setting default device
+    }
+
+    return 0; // Success
+}
+
+// List the processes other than this one that are currently capturing audio input
+// Returns the number of PIDs written, or 0 where CoreAudio process objects are unavailable (before macOS 14)
+static int inputCapturingProcesses(pid_t* pids, int maxCount) {
+#if defined(MAC_OS_VERSION_14_0) && __MAC_OS_X_VERSION_MAX_ALLOWED >= MAC_OS_VERSION_14_0
+    if (__builtin_available(macOS 14.0, *)) {
+        AudioObjectPropertyAddress listAddress = {
+            kAudioHardwarePropertyProcessObjectList,
+            kAudioObjectPropertyScopeGlobal,
+            kAudioObjectPropertyElementMain
+        };
+
+        UInt32 size = 0;
+        OSStatus status = AudioObjectGetPropertyDataSize(kAudioObjectSystemObject, &listAddress, 0, NULL, &size);
+        if (status != noErr || size == 0) {
+            return 0;
+        }
+
+        AudioObjectID* processes = (AudioObjectID*)malloc(size);
+        status = AudioObjectGetPropertyData(kAudioObjectSystemObject, &listAddress, 0, NULL, &size, processes);
+        if (status != noErr) {
+            free(processes);
+            return 0;
+        }
+
+        AudioObjectPropertyAddress runningAddress = {
+            kAudioProcessPropertyIsRunningInput,
+            kAudioObjectPropertyScopeGlobal,
+            kAudioObjectPropertyElementMain
+        };
+        AudioObjectPropertyAddress pidAddress = {
+            kAudioProcessPropertyPID,
+            kAudioObjectPropertyScopeGlobal,
+            kAudioObjectPropertyElementMain
+        };
+
+        int count = 0;
+        pid_t self = getpid();
+        UInt32 processCount = size / sizeof(AudioObjectID);
+        for (UInt32 i = 0; i < processCount && count < maxCount; i++) {
+            UInt32 running = 0;
+            UInt32 runningSize = sizeof(running);
+            if (AudioObjectGetPropertyData(processes[i], &runningAddress, 0, NULL, &runningSize, &running) != noErr || !running) {
+                continue;
+            }
+
+            pid_t pid = -1;
+            UInt32 pidSize = sizeof(pid);
+            if (AudioObjectGetPropertyData(processes[i], &pidAddress, 0, NULL, &pidSize, &pid) != noErr || pid == self) {
+                continue;
+            }
+            pids[count++] = pid;
+        }
+
+        free(processes);
+        return count;
+    }
+#endif
+    return 0;
+}
+
+// Copy the executable name of a process into buffer
+// Returns the length of the name, or 0 on error
+static int processName(pid_t pid, char* buffer, int size) {
+    return proc_name(pid, buffer, (uint32_t)size);
+}
+
 // Save checked device IDs to user preferences
 static void saveCheckedDevices(const char** deviceIDs, int count) {
     // Create the app ID for preferences
@@ -648,50 +816,111 @@ static int loadCheckedDevices(char*** deviceIDs) {
 import "C"
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
 	"unsafe"
 )
 
+// volumeChange is a volume or mute change reported by the listener
+type volumeChange struct {
+	volume float32
+	muted  bool
+}
+
+// volumeChanges queues listener reports for handleVolumeChanges, so the
+// CoreAudio callback returns without waiting on locks or the process scan
+var (
+	volumeChanges      = make(chan volumeChange, 64)
+	volumeChangesStart sync.Once
+)
+
 // goVolumeChangeCallback is called from C when volume or mute state changes
 //
 //export goVolumeChangeCallback
 func goVolumeChangeCallback(volume C.float, muted C.int) {
-	// Convert C types to Go types
-	volumeFloat := float32(volume)
-	isMuted := muted != 0
+	metrics.recordListenerEvent()
+
+	select {
+	case volumeChanges <- volumeChange{volume: float32(volume), muted: muted != 0}:
+	default:
+		// The periodic enforcer corrects whatever is dropped
+		slog.Debug("Dropped volume change event, handler is behind")
+	}
+}
 
+// handleVolumeChanges handles queued listener reports in order
+func handleVolumeChanges() {
+	for change := range volumeChanges {
+		handleVolumeChange(change.volume, change.muted)
+	}
+}
+
+// handleVolumeChange publishes a volume or mute change of the default input
+// device and restores its target volume and mute policy if it is enforced
+func handleVolumeChange(volumeFloat float32, isMuted bool) {
 	// Convert volume from 0.0-1.0 to 0-100 scale
-	volumePercent := int(volumeFloat * 100)
+	levelPercent := int(volumeFloat * 100)
+
+	// Notify subscribers such as the CLI watch command
+	deviceID, deviceName := defaultInputDevice()
+	app := volumeChanger()
+	events.publish(appEvent{
+		Type:       eventVolumeChanged,
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Volume:     levelPercent,
+		Muted:      isMuted,
+		Source:     sourceListener,
+		App:        app,
+	})
 
 	// Log the change
-	if isMuted {
-		log.Printf("[Volume Change Event] Input device is MUTED (volume setting: %d%%)", volumePercent)
-	} else {
-		log.Printf("[Volume Change Event] Input level changed to: %d%%", volumePercent)
-	}
+	slog.Debug("Volume change event",
+		"device", deviceName, "device_id", deviceID, "new_volume", levelPercent, "muted", isMuted, "app", app)
 
-	// Check if any device is selected in the menu
-	if hasSelectedDevice() && volumeFloat < 1.0 && !isMuted {
-		log.Printf("[Volume Change Event] Detected change on monitored device - resetting to 100%%")
+	// Check if any device is selected in the menu and enforcement is not paused
+	target, enforced := listenerTarget()
+	if enforced && !isMuted && volumeDiffers(volumeFloat, target) {
+		targetPercent := volumePercent(target)
 
 		// Schedule volume reset in a non-blocking goroutine
 		go func() {
-			// Set the volume back to 100% on the default device
-			if err := setSystemInputLevel("", 1.0); err != nil {
-				log.Printf("[Volume Change Event] Error resetting volume to 100%%: %v", err)
-			} else {
-				log.Printf("[Volume Change Event] Successfully reset volume to 100%%")
+			// Set the volume back to the target on the default device
+			if err := setSystemInputLevel("", target); err != nil {
+				slog.Error("Failed to reset audio level",
+					"device", deviceName, "device_id", deviceID, "old_volume", levelPercent, "new_volume", targetPercent,
+					"source", sourceListener, "error", err)
+				return
 			}
+			slog.Info("Corrected audio level",
+				"device", deviceName, "device_id", deviceID, "old_volume", levelPercent, "new_volume", targetPercent,
+				"source", sourceListener, "app", app)
+
+			publishCorrection(appEvent{
+				DeviceID:   deviceID,
+				DeviceName: deviceName,
+				Volume:     levelPercent,
+				Target:     targetPercent,
+				Source:     sourceListener,
+				App:        app,
+			})
 		}()
 	}
+
+	// Restore the mute state the device's policy asks for
+	if deviceID != "" {
+		go enforceMutePolicy(deviceID, sourceListener)
+	}
 }
 
 // startVolumeChangeListener registers a listener for volume change events
 func startVolumeChangeListener() error {
+	volumeChangesStart.Do(func() { go handleVolumeChanges() })
+
 	result := C.registerVolumeChangeListener()
 	switch result {
 	case 0:
-		log.Println("Successfully registered volume change listener")
+		slog.Debug("Registered volume change listener")
 		return nil
 	case -1:
 		return fmt.Errorf("failed to get default input device")
@@ -710,7 +939,7 @@ func stopVolumeChangeListener() error {
 	if result != 0 {
 		return fmt.Errorf("failed to unregister volume change listener")
 	}
-	log.Println("Successfully unregistered volume change listener")
+	slog.Debug("Unregistered volume change listener")
 	return nil
 }
 
@@ -780,17 +1009,119 @@ func setSystemInputLevel(deviceID string, volume float32) error {
 		result = C.setInputDeviceVolume(cDeviceID, C.float(volume))
 	}
 
+	var err error
+	switch result {
+	case 0:
+		return nil // Success
+	case -1:
+		err = errDeviceNotFound
+	case -2:
+		err = errVolumeUnsupported
+	case -3:
+		err = errVolumeSetFailed
+	default:
+		err = fmt.Errorf("unknown error setting volume: %d", result)
+	}
+
+	metrics.recordBackendError(err)
+	return err
+}
+
+// inputCapturingApps returns the names of other applications currently
+// capturing audio input. They are the most likely authors of a volume change
+// (e.g. conferencing apps with automatic gain control); macOS does not
+// report who changed a volume, so this is a best-effort heuristic that finds
+// nothing before macOS 14.
+func inputCapturingApps() []string {
+	var pids [32]C.pid_t
+	count := int(C.inputCapturingProcesses(&pids[0], C.int(len(pids))))
+
+	var apps []string
+	seen := make(map[string]bool)
+	for _, pid := range pids[:count] {
+		var name [256]C.char
+		if C.processName(pid, &name[0], C.int(len(name))) <= 0 {
+			continue
+		}
+		app := C.GoString(&name[0])
+		if !seen[app] {
+			seen[app] = true
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
+
+// getSystemInputMute reports whether an input device is muted
+// The deviceID parameter specifies which device to query (empty string for default device)
+func getSystemInputMute(deviceID string) (bool, error) {
+	var muteState C.int
+
+	// If deviceID is empty, use the default device
+	if deviceID == "" {
+		muteState = C.getDefaultInputDeviceMute()
+	} else {
+		cDeviceID := C.CString(deviceID)
+		defer C.free(unsafe.Pointer(cDeviceID))
+
+		muteState = C.getInputDeviceMute(cDeviceID)
+	}
+
+	if muteState < 0 {
+		return false, fmt.Errorf("failed to get input device mute state (device may not support mute control)")
+	}
+
+	return muteState == 1, nil
+}
+
+// setSystemInputMute mutes or unmutes an input device
+// The deviceID parameter specifies which device to control (empty string for default device)
+func setSystemInputMute(deviceID string, muted bool) error {
+	var cMuted C.int
+	if muted {
+		cMuted = 1
+	}
+
+	var result C.int
+
+	// If deviceID is empty, use the default device
+	if deviceID == "" {
+		result = C.setDefaultInputDeviceMute(cMuted)
+	} else {
+		cDeviceID := C.CString(deviceID)
+		defer C.free(unsafe.Pointer(cDeviceID))
+
+		result = C.setInputDeviceMute(cDeviceID, cMuted)
+	}
+
 	switch result {
 	case 0:
 		return nil // Success
 	case -1:
 		return fmt.Errorf("failed to get device")
 	case -2:
-		return fmt.Errorf("device doesn't support volume control")
+		return fmt.Errorf("device doesn't support mute control")
 	case -3:
-		return fmt.Errorf("failed to set volume")
+		return fmt.Errorf("failed to set mute state")
+	default:
+		return fmt.Errorf("unknown error setting mute state: %d", result)
+	}
+}
+
+// setSystemDefaultInput makes an input device the system default
+func setSystemDefaultInput(deviceID string) error {
+	cDeviceID := C.CString(deviceID)
+	defer C.free(unsafe.Pointer(cDeviceID))
+
+	switch result := C.setDefaultInputDeviceByUID(cDeviceID); result {
+	case 0:
+		return nil // Success
+	case -1:
+		return errDeviceNotFound
+	case -2:
+		return fmt.Errorf("failed to set the default input device")
 	default:
-		return fmt.Errorf("unknown error setting volume: %d", result)
+		return fmt.Errorf("unknown error setting the default input device: %d", result)
 	}
 }
 
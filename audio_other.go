@@ -25,6 +25,27 @@ func setSystemInputLevel(deviceID string, volume float32) error {
 	return fmt.Errorf("setting input device volume is only supported on macOS")
 }
 
+// getSystemInputMute is not implemented for non-Darwin systems
+func getSystemInputMute(deviceID string) (bool, error) {
+	return false, fmt.Errorf("reading input device mute state is only supported on macOS")
+}
+
+// setSystemInputMute is not implemented for non-Darwin systems
+func setSystemInputMute(deviceID string, muted bool) error {
+	return fmt.Errorf("setting input device mute state is only supported on macOS")
+}
+
+// setSystemDefaultInput is not implemented for non-Darwin systems
+func setSystemDefaultInput(deviceID string) error {
+	return fmt.Errorf("changing the default input device is only supported on macOS")
+}
+
+// inputCapturingApps is not implemented for non-Darwin systems, which have
+// no audio backend to attribute volume changes with
+func inputCapturingApps() []string {
+	return nil
+}
+
 // saveCheckedDevices is not implemented for non-Darwin systems
 func saveCheckedDevices(deviceIDs []string) {
 	// No-op on non-Darwin systems
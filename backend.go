@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// errNotRunning is returned for commands that need a running instance
+var errNotRunning = errors.New("MicMaxer is not running")
+
+// controlBackend is what the CLI talks to: a running instance over the
+// control socket or, when none is running, the audio backend directly
+type controlBackend interface {
+	listDevices() ([]deviceStatus, error)
+	getDevice(selector string) (deviceStatus, error)
+	setVolume(selector string, level float32) (deviceStatus, error)
+	setMute(selector string, muted bool) (deviceStatus, error)
+	watch(ctx context.Context, fn func(appEvent)) error
+	Close() error
+}
+
+// openBackend opens the backend CLI commands talk to; tests replace it with
+// a fake
+var openBackend = connectBackend
+
+// connectBackend connects to the running instance, falling back to direct
+// access to the audio backend
+func connectBackend() (controlBackend, error) {
+	if client, err := dialControl(); err == nil {
+		slog.Debug("Connected to running MicMaxer instance")
+		return &rpcBackend{client}, nil
+	}
+	return newDirectBackend()
+}
+
+// openInstance connects to the running instance for commands that change its
+// enforcement state
+func openInstance() (*rpcClient, error) {
+	client, err := dialControl()
+	if err != nil {
+		return nil, errNotRunning
+	}
+	return client, nil
+}
+
+// directBackend reads and changes device settings in-process
+type directBackend struct{}
+
+// newDirectBackend scans devices and loads saved preferences so statuses
+// report the same enforcement settings the app would use
+func newDirectBackend() (*directBackend, error) {
+	infos, err := listAudioInputDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	state.mu.Lock()
+	state.audioInputDevices = infos
+	state.mu.Unlock()
+
+	loadDeviceTargets()
+	if ids, err := loadCheckedDevices(); err == nil {
+		state.mu.Lock()
+		for _, id := range ids {
+			state.deviceStates[id] = true
+		}
+		state.mu.Unlock()
+	}
+
+	return &directBackend{}, nil
+}
+
+func (b *directBackend) listDevices() ([]deviceStatus, error) {
+	devices := knownDevices()
+	statuses := make([]deviceStatus, 0, len(devices))
+	for _, d := range devices {
+		statuses = append(statuses, queryDeviceStatus(d))
+	}
+	return statuses, nil
+}
+
+func (b *directBackend) getDevice(selector string) (deviceStatus, error) {
+	device, err := resolveDevice(knownDevices(), selector)
+	if err != nil {
+		return deviceStatus{}, err
+	}
+	return queryDeviceStatus(device), nil
+}
+
+func (b *directBackend) setVolume(selector string, level float32) (deviceStatus, error) {
+	device, err := resolveDevice(knownDevices(), selector)
+	if err != nil {
+		return deviceStatus{}, err
+	}
+	if err := setSystemInputLevel(device.ID, level); err != nil {
+		return deviceStatus{}, err
+	}
+	return queryDeviceStatus(device), nil
+}
+
+func (b *directBackend) setMute(selector string, muted bool) (deviceStatus, error) {
+	device, err := resolveDevice(knownDevices(), selector)
+	if err != nil {
+		return deviceStatus{}, err
+	}
+	if err := setSystemInputMute(device.ID, muted); err != nil {
+		return deviceStatus{}, err
+	}
+	return queryDeviceStatus(device), nil
+}
+
+func (b *directBackend) watch(ctx context.Context, fn func(appEvent)) error {
+	ch, unsubscribe := events.subscribe(64)
+	defer unsubscribe()
+
+	// The listener restores the volume and mute state of enforced devices;
+	// watching without a running instance only reports changes
+	state.mu.Lock()
+	clear(state.deviceStates)
+	state.mu.Unlock()
+
+	if err := startVolumeChangeListener(); err != nil {
+		return err
+	}
+	defer stopVolumeChangeListener()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-ch:
+			fn(ev)
+		}
+	}
+}
+
+func (b *directBackend) Close() error {
+	return nil
+}
+
+// rpcBackend forwards CLI requests to the running instance
+type rpcBackend struct {
+	client *rpcClient
+}
+
+func (b *rpcBackend) listDevices() ([]deviceStatus, error) {
+	var statuses []deviceStatus
+	err := b.client.call("devices.list", nil, &statuses)
+	return statuses, err
+}
+
+func (b *rpcBackend) getDevice(selector string) (deviceStatus, error) {
+	var status deviceStatus
+	err := b.client.call("devices.get", rpcDeviceParams{Device: selector}, &status)
+	return status, err
+}
+
+func (b *rpcBackend) setVolume(selector string, level float32) (deviceStatus, error) {
+	var status deviceStatus
+	err := b.client.call("devices.setVolume", rpcLevelParams{Device: selector, Level: float64(level) * 100}, &status)
+	return status, err
+}
+
+func (b *rpcBackend) setMute(selector string, muted bool) (deviceStatus, error) {
+	var status deviceStatus
+	err := b.client.call("devices.setMute", rpcMuteParams{Device: selector, Muted: muted}, &status)
+	return status, err
+}
+
+func (b *rpcBackend) watch(ctx context.Context, fn func(appEvent)) error {
+	// Closing the connection unblocks the subscription when ctx is done
+	stop := context.AfterFunc(ctx, func() { b.client.Close() })
+	defer stop()
+
+	err := b.client.subscribe(fn)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (b *rpcBackend) Close() error {
+	return b.client.Close()
+}
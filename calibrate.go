@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// Settings for noise floor and gain calibration
+const (
+	defaultCalibrateQuiet   = 5 * time.Second
+	defaultCalibrateSpeech  = 8 * time.Second
+	calibrateWindow         = 100 * time.Millisecond
+	calibrateSpeechMarginDB = 10.0 // speech windows are this much louder than the noise floor
+	calibrateMinSpeech      = 0.2  // fraction of speech windows needed to trust the result
+	calibrateHeadroomDB     = -3.0 // loudest peak allowed at the recommended volume
+	calibrateMinSNR         = 20.0 // warn below this speech-to-noise ratio
+)
+
+// errNoSpeech is returned when the speech recording is too quiet to measure
+var errNoSpeech = errors.New("no speech detected; speak normally during the speech recording")
+
+// calibrationResult holds the measurements of a calibration run and the
+// volume recommended from them
+type calibrationResult struct {
+	Time              time.Time `json:"time"`
+	Volume            int       `json:"volume"`           // device volume while recording, percent
+	NoiseFloorDBFS    float64   `json:"noise_floor_dbfs"` // RMS of room silence
+	SpeechDBFS        float64   `json:"speech_dbfs"`      // RMS of active speech
+	SpeechPeakDBFS    float64   `json:"speech_peak_dbfs"`
+	TargetDBFS        float64   `json:"target_dbfs"`
+	RecommendedVolume int       `json:"recommended_volume"`
+	Warnings          []string  `json:"warnings,omitempty"`
+}
+
+// recordLevels meters a source for the given duration, or until a
+// non-live source such as a WAV file ends, failing if ctx is cancelled first
+func recordLevels(ctx context.Context, source sampleSource, duration time.Duration) ([]levelReading, error) {
+	recordCtx := ctx
+	if source.live() {
+		var cancel context.CancelFunc
+		recordCtx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	var readings []levelReading
+	err := runMeter(recordCtx, source, calibrateWindow, func(r levelReading) {
+		readings = append(readings, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, errors.New("calibration interrupted")
+	}
+	return readings, nil
+}
+
+// powerMean averages RMS readings by their power and returns the result in dBFS
+func powerMean(readings []levelReading) float64 {
+	if len(readings) == 0 {
+		return meterFloorDBFS
+	}
+	var sum float64
+	for _, r := range readings {
+		sum += r.RMS * r.RMS
+	}
+	return toDBFS(math.Sqrt(sum / float64(len(readings))))
+}
+
+// analyzeCalibration computes the noise floor and speech level of recordings
+// made at volume (0.0-1.0) and recommends the volume that brings speech to
+// targetDBFS without peaks exceeding the headroom
+func analyzeCalibration(quiet, speech []levelReading, volume, targetDBFS float64) (calibrationResult, error) {
+	result := calibrationResult{
+		Time:       time.Now(),
+		Volume:     volumePercent(float32(volume)),
+		TargetDBFS: targetDBFS,
+	}
+	if len(quiet) == 0 || len(speech) == 0 {
+		return result, errors.New("recording produced no audio")
+	}
+	if volume <= 0 {
+		return result, errors.New("the device is at 0% volume; raise it before calibrating")
+	}
+
+	// Use the quieter half of the silence recording so a cough or door
+	// does not raise the noise floor
+	sorted := append([]levelReading(nil), quiet...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].RMS < sorted[j].RMS })
+	result.NoiseFloorDBFS = powerMean(sorted[:(len(sorted)+1)/2])
+
+	threshold := math.Max(result.NoiseFloorDBFS+calibrateSpeechMarginDB, meterFloorDBFS+calibrateSpeechMarginDB)
+	var active []levelReading
+	peak := meterFloorDBFS
+	for _, r := range speech {
+		if r.RMSDBFS >= threshold {
+			active = append(active, r)
+			peak = math.Max(peak, r.PeakDBFS)
+		}
+	}
+	if float64(len(active)) < calibrateMinSpeech*float64(len(speech)) {
+		return result, errNoSpeech
+	}
+	result.SpeechDBFS = powerMean(active)
+	result.SpeechPeakDBFS = peak
+
+	// Levels scale with the volume, so the gain needed to reach the target
+	// is applied to the volume used while recording
+	gainDB := targetDBFS - result.SpeechDBFS
+	if limit := calibrateHeadroomDB - peak; limit < gainDB {
+		gainDB = limit
+		result.Warnings = append(result.Warnings,
+			"speech peaks limit the volume; the average speech level will stay below the target")
+	}
+	recommended := volume * math.Pow(10, gainDB/20)
+	if recommended > 1 {
+		recommended = 1
+		result.Warnings = append(result.Warnings,
+			"speech stays below the target even at 100%; move closer to the microphone or raise its hardware gain")
+	}
+	result.RecommendedVolume = max(1, volumePercent(float32(recommended)))
+
+	if snr := result.SpeechDBFS - result.NoiseFloorDBFS; snr < calibrateMinSNR {
+		result.Warnings = append(result.Warnings,
+			"background noise is close to the speech level; a quieter room or a closer microphone will help")
+	}
+	return result, nil
+}
+
+// saveCalibration stores a calibration result with the device settings and
+// makes its recommended volume the device's target
+func saveCalibration(deviceID string, result calibrationResult) error {
+	return updateConfig(func(cfg *appConfig) {
+		dc := cfg.device(deviceID)
+		r := result
+		dc.Calibration = &r
+		target := result.RecommendedVolume
+		dc.Target = &target
+	})
+}
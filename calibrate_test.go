@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// calibrationRate is the sample rate of the generated calibration recordings
+const calibrationRate = 48000
+
+// recordWAV writes mono samples to a WAV file and records its levels the
+// way the calibrate command does with --quiet-wav and --speech-wav
+func recordWAV(t *testing.T, samples []float64) []levelReading {
+	t.Helper()
+	path := writeTestWAV(t, wavFormat{bits: 16}, calibrationRate, 1, samples)
+	source, err := openWAVSource(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err := recordLevels(context.Background(), source, defaultCalibrateSpeech)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readings
+}
+
+// amplitude returns the linear peak amplitude of a level in dBFS
+func amplitude(dbfs float64) float64 {
+	return math.Pow(10, dbfs/20)
+}
+
+// withSpikes adds a short spike of the given peak to every calibration
+// window, raising the peaks of a signal but hardly its RMS
+func withSpikes(samples []float64, peakDBFS float64) []float64 {
+	perWindow := int(calibrateWindow.Seconds() * calibrationRate)
+	for i := perWindow / 2; i < len(samples); i += perWindow {
+		samples[i] = amplitude(peakDBFS)
+	}
+	return samples
+}
+
+// hasWarning reports whether a result warns about the given subject
+func hasWarning(result calibrationResult, subject string) bool {
+	for _, w := range result.Warnings {
+		if strings.Contains(w, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCalibrationNoiseFloor(t *testing.T) {
+	// The room hums at -50 dBFS peak (-53 dBFS RMS), and a door slams for
+	// the last second of the quiet recording
+	quiet := append(tone(amplitude(-50), 440, calibrationRate, 1, 4*time.Second),
+		tone(amplitude(-10), 440, calibrationRate, 1, time.Second)...)
+	speech := tone(amplitude(-20), 440, calibrationRate, 1, 3*time.Second)
+
+	result, err := analyzeCalibration(recordWAV(t, quiet), recordWAV(t, speech), 0.5, -20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(result.NoiseFloorDBFS-(-53)) > 0.5 {
+		t.Errorf("noise floor = %.1f dBFS, want -53", result.NoiseFloorDBFS)
+	}
+	if math.Abs(result.SpeechDBFS-(-23)) > 0.5 {
+		t.Errorf("speech level = %.1f dBFS, want -23", result.SpeechDBFS)
+	}
+
+	// 3 dB more gain from 50% reaches the target
+	if result.Volume != 50 || result.RecommendedVolume != 71 {
+		t.Errorf("recommended %d%% from %d%%, want 71%% from 50%%", result.RecommendedVolume, result.Volume)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %q", result.Warnings)
+	}
+}
+
+func TestCalibrationHeadroom(t *testing.T) {
+	quiet := tone(amplitude(-70), 440, calibrationRate, 1, 2*time.Second)
+
+	// Speech at -33 dBFS RMS would need 13 dB, but peaks at -6 dBFS leave
+	// only 3 dB before the headroom
+	speech := withSpikes(tone(amplitude(-30), 440, calibrationRate, 1, 3*time.Second), -6)
+
+	result, err := analyzeCalibration(recordWAV(t, quiet), recordWAV(t, speech), 0.4, -20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(result.SpeechPeakDBFS-(-6)) > 0.5 {
+		t.Errorf("speech peak = %.1f dBFS, want -6", result.SpeechPeakDBFS)
+	}
+	want := volumePercent(float32(0.4 * amplitude(calibrateHeadroomDB-result.SpeechPeakDBFS)))
+	if result.RecommendedVolume != want {
+		t.Errorf("recommended %d%%, want %d%%", result.RecommendedVolume, want)
+	}
+	if !hasWarning(result, "peaks limit the volume") {
+		t.Errorf("no headroom warning in %q", result.Warnings)
+	}
+}
+
+func TestCalibrationFullVolume(t *testing.T) {
+	quiet := tone(amplitude(-70), 440, calibrationRate, 1, 2*time.Second)
+	speech := tone(amplitude(-40), 440, calibrationRate, 1, 3*time.Second)
+
+	// Reaching -20 dBFS from -43 dBFS RMS at 80% would take over 1000%
+	result, err := analyzeCalibration(recordWAV(t, quiet), recordWAV(t, speech), 0.8, -20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RecommendedVolume != 100 {
+		t.Errorf("recommended %d%%, want 100%%", result.RecommendedVolume)
+	}
+	if !hasWarning(result, "even at 100%") {
+		t.Errorf("no warning about reaching 100%% in %q", result.Warnings)
+	}
+}
+
+func TestCalibrationNoSpeech(t *testing.T) {
+	// Speaking no louder than the room is not speech
+	quiet := tone(amplitude(-40), 440, calibrationRate, 1, 2*time.Second)
+	speech := tone(amplitude(-38), 440, calibrationRate, 1, 3*time.Second)
+
+	_, err := analyzeCalibration(recordWAV(t, quiet), recordWAV(t, speech), 0.5, -20)
+	if !errors.Is(err, errNoSpeech) {
+		t.Errorf("analyzeCalibration() error = %v, want %v", err, errNoSpeech)
+	}
+
+	// Neither is a recording of digital silence
+	silence := make([]float64, 3*calibrationRate)
+	_, err = analyzeCalibration(recordWAV(t, silence), recordWAV(t, silence), 0.5, -20)
+	if !errors.Is(err, errNoSpeech) {
+		t.Errorf("analyzeCalibration(silence) error = %v, want %v", err, errNoSpeech)
+	}
+}
+
+func TestRecordLevelsInterrupted(t *testing.T) {
+	path := writeTestWAV(t, wavFormat{bits: 16}, calibrationRate, 1, tone(0.5, 440, calibrationRate, 1, time.Second))
+	source, err := openWAVSource(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	if _, err := recordLevels(ctx, source, time.Minute); err == nil {
+		t.Error("recordLevels succeeded after being interrupted")
+	}
+}
@@ -0,0 +1,1347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// errUsage is returned by commands that were invoked with invalid arguments
+var errUsage = errors.New("invalid usage")
+
+// Output streams used by the command-line interface
+var (
+	cliOut io.Writer = os.Stdout
+	cliErr io.Writer = os.Stderr
+)
+
+// cliCommand describes a command-line subcommand
+type cliCommand struct {
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+// cliCommands maps subcommand names to their implementations
+var cliCommands map[string]cliCommand
+
+// commandFlags holds the flags of the running subcommand, listed in the
+// usage of commands documented with [flags]
+var commandFlags *flag.FlagSet
+
+func init() {
+	cliCommands = map[string]cliCommand{
+		"devices":   {"devices [--json]", "List audio input devices with their volume", cmdDevices},
+		"get":       {"get [--json] <device>", "Show the volume and mute state of a device", cmdGet},
+		"set":       {"set <device> <level>", "Set the input volume of a device (e.g. 80%)", cmdSet},
+		"mute":      {"mute <device>", "Mute a device", cmdMute},
+		"unmute":    {"unmute <device>", "Unmute a device", cmdUnmute},
+		"alias":     {"alias <name> <device> | alias --delete <name>", "Assign or remove a device alias", cmdAlias},
+		"watch":     {"watch [--json]", "Print volume and mute changes until interrupted", cmdWatch},
+		"status":    {"status [--json]", "Show the enforcement state of the running instance", cmdStatus},
+		"menu":      {"menu", "Print the menu bar menu of the running instance", cmdMenu},
+		"check":     {"check <device>", "Enforce the target volume on a device", cmdCheck},
+		"uncheck":   {"uncheck <device>", "Stop enforcing a device", cmdUncheck},
+		"target":    {"target <device> <level>", "Change the enforced volume of a device", cmdTarget},
+		"pause":     {"pause [--for d] [device]", "Pause enforcement of all devices or one device", cmdPause},
+		"resume":    {"resume [device]", "Resume enforcement of all devices or one device", cmdResume},
+		"talk":      {"talk [--for d]", "Hold push-to-talk until interrupted or for a while", cmdTalk},
+		"schedule":  {"schedule [--json] [--at time]", "Show which scheduled windows are open now or at another time", cmdSchedule},
+		"history":   {"history [--json] [--since t] [--until t] [--device d] [-n count]", "Show recorded volume changes and corrections", cmdHistory},
+		"diagnose":  {"diagnose [-o file] [-n lines]", "Write a redacted diagnostics archive for bug reports", cmdDiagnose},
+		"agc":       {"agc [flags] <device> on|off", "Control a device's volume from its measured loudness", cmdAGC},
+		"agc-sim":   {"agc-sim [--json] [flags] --wav file", "Simulate automatic gain control on a recording", cmdAGCSim},
+		"calibrate": {"calibrate [flags] <device>", "Measure room noise and speech to choose a device's volume", cmdCalibrate},
+		"meter":     {"meter [--json] [--window d] [--wav file [--realtime]] [--null] [device]", "Show the live input signal level of a device", cmdMeter},
+		"logs":      {"logs [-n lines] [--follow]", "Print the end of the log file, optionally following it", cmdLogs},
+		"help":      {"help", "Show this help", cmdHelp},
+	}
+}
+
+// isCLICommand reports whether name is a known subcommand
+func isCLICommand(name string) bool {
+	_, ok := cliCommands[name]
+	return ok
+}
+
+// runCLI runs a subcommand and returns the process exit code
+func runCLI(args []string) int {
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(cliErr, "micmaxer: unknown command %q\n", args[0])
+		return 2
+	}
+
+	// Keep diagnostic logging out of command output unless --verbose is given
+	_ = setupLogging(io.Discard, "error", defaultLogFormat)
+
+	if err := cmd.run(args[1:]); err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(cliErr, "usage: micmaxer %s\n", cmd.usage)
+			if strings.Contains(cmd.usage, "[flags]") && commandFlags != nil {
+				fmt.Fprintln(cliErr, "\nflags:")
+				commandFlags.SetOutput(cliErr)
+				commandFlags.PrintDefaults()
+			}
+			return 2
+		}
+		fmt.Fprintf(cliErr, "micmaxer %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// cliOptions holds the flags shared by all subcommands
+type cliOptions struct {
+	json    bool
+	verbose bool
+}
+
+// newCommandFlags creates a flag set for a subcommand with the shared flags registered
+func newCommandFlags(name string) (*flag.FlagSet, *cliOptions) {
+	opts := &cliOptions{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&opts.json, "json", false, "print machine-readable JSON")
+	fs.BoolVar(&opts.verbose, "verbose", false, "print diagnostic logging to stderr")
+	commandFlags = fs
+	return fs, opts
+}
+
+// parseCommandFlags parses args and applies the shared flags. Flags may
+// follow the positional arguments, as in "calibrate mic --dry-run"; after
+// "--" everything is positional.
+func parseCommandFlags(fs *flag.FlagSet, opts *cliOptions, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	// Leave the positional arguments in fs.Args()
+	if err := fs.Parse(append([]string{"--"}, positional...)); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if opts.verbose {
+		_ = setupLogging(cliErr, "debug", defaultLogFormat)
+	}
+	return nil
+}
+
+// parseVolumeLevel parses a percentage such as "80%" or "80" into a 0.0-1.0 scalar
+func parseVolumeLevel(s string) (float32, error) {
+	value, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid level %q: expected a percentage such as 80%%", s)
+	}
+	if value < 0 || value > 100 {
+		return 0, fmt.Errorf("invalid level %q: must be between 0%% and 100%%", s)
+	}
+	return float32(value / 100), nil
+}
+
+// writeJSON prints v as indented JSON
+func writeJSON(v any) error {
+	enc := json.NewEncoder(cliOut)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printDeviceStatuses prints device statuses as a table
+func printDeviceStatuses(statuses []deviceStatus) {
+	tw := tabwriter.NewWriter(cliOut, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEFAULT\tNAME\tALIAS\tVOLUME\tID")
+	for _, s := range statuses {
+		def := ""
+		if s.Default {
+			def = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", def, s.Name, s.Alias, formatVolume(s), s.ID)
+	}
+	tw.Flush()
+}
+
+// formatVolume renders the volume column of a device status
+func formatVolume(s deviceStatus) string {
+	switch {
+	case s.Volume == nil:
+		return "n/a"
+	case s.Muted != nil && *s.Muted:
+		return "muted"
+	default:
+		return fmt.Sprintf("%d%%", *s.Volume)
+	}
+}
+
+// cmdDevices lists all audio input devices
+func cmdDevices(args []string) error {
+	fs, opts := newCommandFlags("devices")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	backend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	statuses, err := backend.listDevices()
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return writeJSON(statuses)
+	}
+	printDeviceStatuses(statuses)
+	return nil
+}
+
+// cmdGet shows the state of a single device
+func cmdGet(args []string) error {
+	fs, opts := newCommandFlags("get")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	backend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	status, err := backend.getDevice(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return writeJSON(status)
+	}
+	if status.Error != "" {
+		return errors.New(status.Error)
+	}
+	fmt.Fprintf(cliOut, "%s: %s\n", status.Name, formatVolume(status))
+	return nil
+}
+
+// cmdSet changes the input volume of a device
+func cmdSet(args []string) error {
+	fs, opts := newCommandFlags("set")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+
+	level, err := parseVolumeLevel(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	backend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	status, err := backend.setVolume(fs.Arg(0), level)
+	if err != nil {
+		return fmt.Errorf("failed to set volume: %w", err)
+	}
+
+	if opts.json {
+		return writeJSON(status)
+	}
+	fmt.Fprintf(cliOut, "%s: set to %d%%\n", status.Name, volumePercent(level))
+	return nil
+}
+
+// cmdMute mutes a device
+func cmdMute(args []string) error {
+	return setMuteCommand("mute", args, true)
+}
+
+// cmdUnmute unmutes a device
+func cmdUnmute(args []string) error {
+	return setMuteCommand("unmute", args, false)
+}
+
+// setMuteCommand implements the mute and unmute subcommands
+func setMuteCommand(name string, args []string, muted bool) error {
+	fs, opts := newCommandFlags(name)
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	backend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	status, err := backend.setMute(fs.Arg(0), muted)
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", name, err)
+	}
+
+	if opts.json {
+		return writeJSON(status)
+	}
+	fmt.Fprintf(cliOut, "%s: %sd\n", status.Name, name)
+	return nil
+}
+
+// cmdAlias assigns or removes a device alias in the config file
+func cmdAlias(args []string) error {
+	fs, opts := newCommandFlags("alias")
+	remove := fs.Bool("delete", false, "remove the alias")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+
+	if *remove {
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		found := false
+		err := updateConfig(func(cfg *appConfig) {
+			for id, dc := range cfg.Devices {
+				if strings.EqualFold(dc.Alias, fs.Arg(0)) {
+					cfg.setDeviceAlias(id, "")
+					found = true
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no device has alias %q", fs.Arg(0))
+		}
+		return nil
+	}
+
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+
+	backend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	status, err := backend.getDevice(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	err = updateConfig(func(cfg *appConfig) {
+		cfg.setDeviceAlias(status.ID, fs.Arg(0))
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cliOut, "%s is now known as %q\n", status.Name, fs.Arg(0))
+	return nil
+}
+
+// cmdWatch prints volume change events until interrupted
+func cmdWatch(args []string) error {
+	fs, opts := newCommandFlags("watch")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	backend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	enc := json.NewEncoder(cliOut)
+	return backend.watch(ctx, func(ev appEvent) {
+		if opts.json {
+			_ = enc.Encode(ev)
+			return
+		}
+		fmt.Fprintf(cliOut, "%s  %s\n", ev.Time.Format(time.TimeOnly), describeEvent(ev))
+	})
+}
+
+// describeEvent renders an event as a short human-readable line
+func describeEvent(ev appEvent) string {
+	name := ev.DeviceName
+	if name == "" {
+		name = "default input"
+	}
+
+	switch ev.Type {
+	case eventVolumeChanged:
+		if ev.Muted {
+			return fmt.Sprintf("%s: muted (volume setting %d%%)", name, ev.Volume)
+		}
+		if ev.App != "" && ev.Previous != nil && *ev.Previous != ev.Volume {
+			verb := "raised"
+			if ev.Volume < *ev.Previous {
+				verb = "lowered"
+			}
+			return fmt.Sprintf("%s: %s %s it to %d%%", name, ev.App, verb, ev.Volume)
+		}
+		if ev.Previous != nil {
+			return fmt.Sprintf("%s: %d%% -> %d%%", name, *ev.Previous, ev.Volume)
+		}
+		return fmt.Sprintf("%s: %d%%", name, ev.Volume)
+	case eventVolumeCorrected:
+		if ev.App != "" {
+			return fmt.Sprintf("%s: restored from %d%% to %d%% by %s after a change by %s", name, ev.Volume, ev.Target, ev.Source, ev.App)
+		}
+		return fmt.Sprintf("%s: restored from %d%% to %d%% by %s", name, ev.Volume, ev.Target, ev.Source)
+	case eventDeviceChecked:
+		return fmt.Sprintf("%s: enforcing %d%%", name, ev.Target)
+	case eventDeviceUnchecked:
+		return fmt.Sprintf("%s: no longer enforced", name)
+	case eventTargetChanged:
+		if ev.Previous != nil {
+			return fmt.Sprintf("%s: target changed from %d%% to %d%%", name, *ev.Previous, ev.Target)
+		}
+		return fmt.Sprintf("%s: target set to %d%%", name, ev.Target)
+	case eventDeviceAdded:
+		return fmt.Sprintf("%s: connected", name)
+	case eventDeviceRemoved:
+		return fmt.Sprintf("%s: disconnected", name)
+	case eventSilenceDetected:
+		return fmt.Sprintf("%s: silent for %d seconds - check that it is connected", name, ev.Count)
+	case eventClippingDetected:
+		return fmt.Sprintf("%s: clipped %d times - lower its gain", name, ev.Count)
+	case eventEnforcementFailed:
+		return fmt.Sprintf("%s: cannot enforce %d%% - %s", name, ev.Target, ev.Error)
+	case eventConflictDetected:
+		if ev.App != "" {
+			return fmt.Sprintf("%s: corrected %d times - %s keeps changing it", name, ev.Count, ev.App)
+		}
+		return fmt.Sprintf("%s: corrected %d times - another application may be changing it", name, ev.Count)
+	case eventPushToTalkHeld, eventPushToTalkReleased:
+		verb := "released"
+		if ev.Type == eventPushToTalkHeld {
+			verb = "held"
+		}
+		if ev.Muted {
+			return "push-to-talk " + verb + ", microphones silenced"
+		}
+		return "push-to-talk " + verb + ", microphones live"
+	case eventScheduleStarted:
+		return fmt.Sprintf("%s: scheduled enforcement at %d%% %s", name, ev.Target, scheduleUntilLabel(ev.Until, ev.Time))
+	case eventScheduleEnded:
+		return fmt.Sprintf("%s: released by its schedule %s", name, scheduleUntilLabel(ev.Until, ev.Time))
+	case eventEnforcementPaused:
+		if ev.DeviceID != "" {
+			return fmt.Sprintf("%s: enforcement paused %s", name, pauseUntilLabel(ev.Until))
+		}
+		return "enforcement paused " + pauseUntilLabel(ev.Until)
+	case eventEnforcementResumed:
+		suffix := ""
+		if ev.Source == sourceTimer {
+			suffix = " after the pause ended"
+		}
+		if ev.DeviceID != "" {
+			return fmt.Sprintf("%s: enforcement resumed%s", name, suffix)
+		}
+		return "enforcement resumed" + suffix
+	default:
+		return fmt.Sprintf("%s: %s", name, ev.Type)
+	}
+}
+
+// cmdHistory prints the event history of the running instance
+func cmdHistory(args []string) error {
+	fs, opts := newCommandFlags("history")
+	since := fs.String("since", "", "only events after this time (e.g. 2h, 14:00)")
+	until := fs.String("until", "", "only events before this time")
+	device := fs.String("device", "", "only events for this device")
+	limit := fs.Int("n", 0, "only the most recent events")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *limit < 0 {
+		return errUsage
+	}
+
+	q := historyQuery{Device: *device, Limit: *limit}
+	now := time.Now()
+	for _, f := range []struct {
+		value string
+		dst   **time.Time
+	}{{*since, &q.Since}, {*until, &q.Until}} {
+		if f.value == "" {
+			continue
+		}
+		t, err := parseHistoryTime(f.value, now)
+		if err != nil {
+			return err
+		}
+		*f.dst = &t
+	}
+
+	client, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var entries []appEvent
+	if err := client.call("history.query", q, &entries); err != nil {
+		return err
+	}
+
+	if opts.json {
+		return writeJSON(entries)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(cliOut, "No matching events")
+		return nil
+	}
+	for _, ev := range entries {
+		line := describeEvent(ev)
+		if ev.Source != "" && ev.Type != eventVolumeCorrected {
+			line += " (" + ev.Source + ")"
+		}
+		fmt.Fprintf(cliOut, "%s  %s\n", ev.Time.Local().Format(time.DateTime), line)
+	}
+	return nil
+}
+
+// cmdStatus shows the state of the running instance
+func cmdStatus(args []string) error {
+	fs, opts := newCommandFlags("status")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	client, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var status appStatus
+	if err := client.call("status", nil, &status); err != nil {
+		return err
+	}
+
+	if opts.json {
+		return writeJSON(status)
+	}
+	if status.Paused {
+		fmt.Fprintln(cliOut, "Enforcement: paused", pauseUntilLabel(status.PausedUntil))
+	} else {
+		fmt.Fprintln(cliOut, "Enforcement: active")
+	}
+	for _, d := range status.Devices {
+		if d.Paused {
+			fmt.Fprintf(cliOut, "Paused: %s %s\n", d.Name, pauseUntilLabel(d.PausedUntil))
+		}
+	}
+	for _, d := range status.Devices {
+		if d.Schedule != nil {
+			fmt.Fprintf(cliOut, "Schedule: %s %s\n", d.Name, describeSchedule(d.Schedule.Active, d.Schedule.Target, d.Schedule.Until, time.Now()))
+		}
+	}
+	if p := status.PushToTalk; p != nil {
+		fmt.Fprintln(cliOut, "Push-to-talk:", describePushToTalk(p))
+	}
+	fmt.Fprintln(cliOut)
+	printDeviceStatuses(status.Devices)
+	return nil
+}
+
+// cmdMenu prints the menu the running instance shows in the menu bar, built
+// from its status with the text renderer
+func cmdMenu(args []string) error {
+	fs, opts := newCommandFlags("menu")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	client, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var status appStatus
+	if err := client.call("status", nil, &status); err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	snapshot := menuSnapshot{Status: status, InputLevel: cfg.Tray != nil && cfg.Tray.VUMeter}
+	if cfg.Notifications != nil {
+		snapshot.Notifications = *cfg.Notifications
+	}
+
+	// The menu is only printed, so no actions are needed
+	var menu textMenu
+	newMenuView(&menu, nil, func() menuSnapshot { return snapshot }).refresh()
+	fmt.Fprint(cliOut, menu.String())
+	return nil
+}
+
+// cmdCheck enables enforcement for a device in the running instance
+func cmdCheck(args []string) error {
+	return instanceDeviceCommand("check", "devices.check", args)
+}
+
+// cmdUncheck disables enforcement for a device in the running instance
+func cmdUncheck(args []string) error {
+	return instanceDeviceCommand("uncheck", "devices.uncheck", args)
+}
+
+// instanceDeviceCommand runs a single-device method against the running instance
+func instanceDeviceCommand(name, method string, args []string) error {
+	fs, opts := newCommandFlags(name)
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	client, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var status deviceStatus
+	if err := client.call(method, rpcDeviceParams{Device: fs.Arg(0)}, &status); err != nil {
+		return err
+	}
+
+	if opts.json {
+		return writeJSON(status)
+	}
+	if status.Enforced {
+		fmt.Fprintf(cliOut, "%s: enforcing %d%%\n", status.Name, status.Target)
+	} else {
+		fmt.Fprintf(cliOut, "%s: not enforced\n", status.Name)
+	}
+	return nil
+}
+
+// cmdTarget changes the enforced volume for a device in the running instance
+func cmdTarget(args []string) error {
+	fs, opts := newCommandFlags("target")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+
+	level, err := parseVolumeLevel(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	client, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var status deviceStatus
+	params := rpcLevelParams{Device: fs.Arg(0), Level: float64(level) * 100}
+	if err := client.call("devices.setTarget", params, &status); err != nil {
+		return err
+	}
+
+	if opts.json {
+		return writeJSON(status)
+	}
+	fmt.Fprintf(cliOut, "%s: target set to %d%%\n", status.Name, status.Target)
+	return nil
+}
+
+// cmdPause suspends enforcement in the running instance
+func cmdPause(args []string) error {
+	return pauseCommand("pause", "enforcement.pause", args)
+}
+
+// cmdResume resumes enforcement in the running instance
+func cmdResume(args []string) error {
+	return pauseCommand("resume", "enforcement.resume", args)
+}
+
+// talkRenewInterval is how often the talk command renews its hold, well
+// within the hold limit it asks for
+const (
+	talkRenewInterval = 5 * time.Second
+	talkHoldLimit     = 15 * time.Second
+)
+
+// cmdTalk holds push-to-talk in the running instance until interrupted or
+// for the given duration. The hold is renewed while the command runs, so
+// the instance releases it by itself if the command is killed.
+func cmdTalk(args []string) error {
+	fs, opts := newCommandFlags("talk")
+	duration := fs.Duration("for", 0, "release automatically after this long, e.g. 10s")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *duration < 0 {
+		return errUsage
+	}
+
+	client, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	params := rpcTalkParams{Duration: talkHoldLimit.String()}
+	var status appStatus
+	if err := client.call("talk.start", params, &status); err != nil {
+		return err
+	}
+	if !opts.json {
+		fmt.Fprintln(cliOut, "Push-to-talk:", describePushToTalk(status.PushToTalk))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(talkRenewInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			if err := client.call("talk.start", params, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := client.call("talk.stop", nil, &status); err != nil {
+		return err
+	}
+	if opts.json {
+		return writeJSON(status)
+	}
+	fmt.Fprintln(cliOut, "Push-to-talk released")
+	return nil
+}
+
+// describePushToTalk summarises the push-to-talk state
+func describePushToTalk(p *pushToTalkStatus) string {
+	if p == nil {
+		return "off"
+	}
+	mode := "push to talk"
+	if p.Mode == pttModeMute {
+		mode = "push to mute"
+	}
+	if p.Silenced {
+		return mode + ", microphones silenced"
+	}
+	return mode + ", microphones live"
+}
+
+// scheduleEntry is where a configured schedule stands at a time
+type scheduleEntry struct {
+	DeviceID string     `json:"device_id,omitempty"` // empty for the default schedule
+	Alias    string     `json:"alias,omitempty"`
+	Active   bool       `json:"active"`
+	Target   *int       `json:"target,omitempty"` // target of the open window, if it sets one
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// cmdSchedule evaluates the schedules in the config file at a time, by
+// default now, so they can be checked without waiting for them
+func cmdSchedule(args []string) error {
+	fs, opts := newCommandFlags("schedule")
+	at := fs.String("at", "", "evaluate at this time (e.g. 14:00, \"sat 10:00\") instead of now")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	now := time.Now()
+	if *at != "" {
+		t, err := parseScheduleTime(*at, now)
+		if err != nil {
+			return err
+		}
+		now = t
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// Report invalid schedules, which the running instance skips
+	for deviceID, dc := range cfg.Devices {
+		if _, err := parseSchedule(dc.Schedule); err != nil {
+			return fmt.Errorf("schedule of device %s: %w", deviceID, err)
+		}
+	}
+	if _, err := parseSchedule(cfg.Schedule); err != nil {
+		return fmt.Errorf("default schedule: %w", err)
+	}
+	devices, fallback := loadSchedules(cfg)
+	s := &scheduler{clock: fixedClock(now), devices: devices, fallback: fallback}
+
+	ids := make([]string, 0, len(devices))
+	for deviceID := range devices {
+		ids = append(ids, deviceID)
+	}
+	sort.Strings(ids)
+	if len(fallback) > 0 {
+		ids = append(ids, "")
+	}
+
+	entries := make([]scheduleEntry, 0, len(ids))
+	for _, deviceID := range ids {
+		current, _ := s.at(deviceID) // the default schedule for ""
+		entry := scheduleEntry{DeviceID: deviceID, Alias: cfg.deviceAlias(deviceID), Active: !current.released}
+		if current.target != nil {
+			target := volumePercent(*current.target)
+			entry.Target = &target
+		}
+		if !current.until.IsZero() {
+			entry.Until = &current.until
+		}
+		entries = append(entries, entry)
+	}
+
+	if opts.json {
+		return writeJSON(entries)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(cliOut, "No schedules configured")
+		return nil
+	}
+	for _, e := range entries {
+		name := e.Alias
+		switch {
+		case e.DeviceID == "":
+			name = "Other enforced devices"
+		case name == "":
+			name = e.DeviceID
+		}
+		fmt.Fprintf(cliOut, "%s: %s\n", name, describeSchedule(e.Active, e.Target, e.Until, now))
+	}
+	return nil
+}
+
+// pauseCommand implements the pause and resume subcommands
+func pauseCommand(name, method string, args []string) error {
+	fs, opts := newCommandFlags(name)
+	var duration *time.Duration
+	if name == "pause" {
+		duration = fs.Duration("for", 0, "resume automatically after this long, e.g. 5m")
+	}
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 || (duration != nil && *duration < 0) {
+		return errUsage
+	}
+
+	params := rpcPauseParams{Device: fs.Arg(0)}
+	if duration != nil && *duration > 0 {
+		params.Duration = duration.String()
+	}
+
+	client, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var status appStatus
+	if err := client.call(method, params, &status); err != nil {
+		return err
+	}
+
+	if opts.json {
+		return writeJSON(status)
+	}
+	if params.Device == "" {
+		if status.Paused {
+			fmt.Fprintln(cliOut, "Enforcement paused", pauseUntilLabel(status.PausedUntil))
+		} else {
+			fmt.Fprintln(cliOut, "Enforcement resumed")
+		}
+		return nil
+	}
+
+	device, err := resolveDevice(statusDeviceRefs(status.Devices), params.Device)
+	if err != nil {
+		return err
+	}
+	for _, d := range status.Devices {
+		switch {
+		case d.ID != device.ID:
+		case d.Paused:
+			fmt.Fprintf(cliOut, "%s: enforcement paused %s\n", d.Name, pauseUntilLabel(d.PausedUntil))
+		case status.Paused:
+			fmt.Fprintf(cliOut, "%s: resumed, but enforcement of all devices is still paused\n", d.Name)
+		default:
+			fmt.Fprintf(cliOut, "%s: enforcement resumed\n", d.Name)
+		}
+	}
+	return nil
+}
+
+// statusDeviceRefs returns device references for resolving selectors
+// against statuses reported by the running instance
+func statusDeviceRefs(statuses []deviceStatus) []deviceRef {
+	refs := make([]deviceRef, 0, len(statuses))
+	for _, s := range statuses {
+		refs = append(refs, deviceRef{ID: s.ID, Name: s.Name, Alias: s.Alias, Default: s.Default})
+	}
+	return refs
+}
+
+// cmdLogs prints the end of the log file and optionally follows it
+func cmdLogs(args []string) error {
+	fs, opts := newCommandFlags("logs")
+	lines := fs.Int("n", 20, "number of lines to print")
+	follow := fs.Bool("follow", false, "keep printing lines as they are written")
+	fs.BoolVar(follow, "f", false, "shorthand for --follow")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *lines < 0 {
+		return errUsage
+	}
+
+	path, err := logFilePath()
+	if err != nil {
+		return err
+	}
+
+	tail, offset, err := tailLines(path, *lines)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no log file at %s", path)
+		}
+		return err
+	}
+	for _, line := range tail {
+		fmt.Fprintln(cliOut, line)
+	}
+	if !*follow {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return followLogFile(ctx, path, offset, cliOut)
+}
+
+// cmdDiagnose writes a diagnostics archive for attaching to bug reports
+func cmdDiagnose(args []string) error {
+	fs, opts := newCommandFlags("diagnose")
+	output := fs.String("o", "", "archive to write (default micmaxer-diagnostics-<time>.zip)")
+	lines := fs.Int("n", 1000, "number of log lines to include")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *lines < 0 {
+		return errUsage
+	}
+
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("micmaxer-diagnostics-%s.zip", time.Now().Format("20060102-150405"))
+	}
+
+	bundle, err := collectDiagnostics(*lines)
+	if err != nil {
+		return err
+	}
+	if err := bundle.writeZip(path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	if opts.json {
+		return writeJSON(map[string]string{"path": path})
+	}
+	fmt.Fprintf(cliOut, "Wrote %s\n", path)
+	fmt.Fprintln(cliOut, "Please review it before attaching it to a bug report.")
+	return nil
+}
+
+// cmdMeter prints input signal levels until interrupted
+func cmdMeter(args []string) error {
+	fs, opts := newCommandFlags("meter")
+	window := fs.Duration("window", defaultMeterWindow, "length of each reading")
+	wavPath := fs.String("wav", "", "meter a WAV file instead of a device")
+	realtime := fs.Bool("realtime", false, "play the WAV file at its real speed")
+	null := fs.Bool("null", false, "capture from the silent null backend, for testing")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 || *window <= 0 || (*wavPath != "" && (*null || fs.NArg() != 0)) {
+		return errUsage
+	}
+
+	var source sampleSource
+	switch {
+	case *wavPath != "":
+		wav, err := openWAVSource(*wavPath, *realtime)
+		if err != nil {
+			return err
+		}
+		source = wav
+	case *null:
+		source = newNullCaptureSource()
+	default:
+		if _, err := newDirectBackend(); err != nil {
+			return err
+		}
+		var id string
+		if fs.NArg() == 1 {
+			device, err := resolveDevice(knownDevices(), fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			id = device.ID
+		}
+		capture, err := newCaptureSource(id)
+		if err != nil {
+			return err
+		}
+		source = capture
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	enc := json.NewEncoder(cliOut)
+	return runMeter(ctx, source, *window, func(r levelReading) {
+		if opts.json {
+			_ = enc.Encode(r)
+			return
+		}
+		fmt.Fprintf(cliOut, "peak %6.1f dBFS  rms %6.1f dBFS  %s\n", r.PeakDBFS, r.RMSDBFS, levelBar(r.PeakDBFS, 40))
+	})
+}
+
+// levelBar renders a dBFS level between -60 and 0 as a bar of the given width
+func levelBar(dbfs float64, width int) string {
+	filled := int((dbfs + 60) / 60 * float64(width))
+	filled = max(0, min(width, filled))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// agcFlags registers the AGC tuning flags on fs
+func agcFlags(fs *flag.FlagSet) func() agcConfig {
+	target := fs.Float64("target", defaultAGCTargetDBFS, "speech loudness to aim for in dBFS")
+	gate := fs.Float64("gate", defaultAGCGateDBFS, "treat quieter input as silence (dBFS at full volume)")
+	minVolume := fs.Int("min", defaultAGCMinVolume, "lowest volume in percent")
+	maxVolume := fs.Int("max", defaultAGCMaxVolume, "highest volume in percent")
+	attack := fs.Duration("attack", defaultAGCAttack, "time constant for lowering the volume")
+	release := fs.Duration("release", defaultAGCRelease, "time constant for raising the volume")
+	return func() agcConfig {
+		return agcConfig{
+			Enabled:    true,
+			TargetDBFS: *target,
+			GateDBFS:   *gate,
+			MinVolume:  *minVolume,
+			MaxVolume:  *maxVolume,
+			AttackMS:   int(*attack / time.Millisecond),
+			ReleaseMS:  int(*release / time.Millisecond),
+		}
+	}
+}
+
+// cmdAGC enables or disables automatic gain control in the running instance
+func cmdAGC(args []string) error {
+	fs, opts := newCommandFlags("agc")
+	settings := agcFlags(fs)
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 || (fs.Arg(1) != "on" && fs.Arg(1) != "off") {
+		return errUsage
+	}
+
+	params := rpcAGCParams{Device: fs.Arg(0), agcConfig: settings()}
+	params.Enabled = fs.Arg(1) == "on"
+	if err := params.withDefaults().validate(); err != nil {
+		return err
+	}
+
+	client, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var status deviceStatus
+	if err := client.call("devices.setAGC", params, &status); err != nil {
+		return err
+	}
+
+	if opts.json {
+		return writeJSON(status)
+	}
+	if status.AGC {
+		fmt.Fprintf(cliOut, "%s: automatic gain control on, aiming for %.0f dBFS\n", status.Name, params.TargetDBFS)
+	} else {
+		fmt.Fprintf(cliOut, "%s: automatic gain control off\n", status.Name)
+	}
+	return nil
+}
+
+// cmdAGCSim runs the AGC controller against a WAV recording made at 100%
+// volume and prints how it would have adjusted the device
+func cmdAGCSim(args []string) error {
+	fs, opts := newCommandFlags("agc-sim")
+	settings := agcFlags(fs)
+	wavPath := fs.String("wav", "", "recording to feed through the simulated device")
+	initial := fs.Int("initial", 100, "simulated device volume at the start, in percent")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *wavPath == "" || *initial < 0 || *initial > 100 {
+		return errUsage
+	}
+	config := settings().withDefaults()
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	source, err := openWAVSource(*wavPath, false)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(cliOut)
+	result, err := simulateAGC(source, config, float64(*initial)/100, func(step agcSimStep) {
+		switch {
+		case opts.json:
+			_ = enc.Encode(step)
+		case step.Changed:
+			fmt.Fprintf(cliOut, "%8.1fs  rms %6.1f dBFS  peak %6.1f dBFS  volume -> %d%%\n",
+				step.Time.Seconds(), step.Reading.RMSDBFS, step.Reading.PeakDBFS, step.Volume)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return enc.Encode(result)
+	}
+	fmt.Fprintf(cliOut, "\nSimulated %.1fs: volume %d%% -> %d%% in %d adjustments, speech averaged %.1f dBFS (target %.1f), %d clipped windows\n",
+		result.Duration.Seconds(), result.InitialVolume, result.FinalVolume, result.Adjustments,
+		result.SpeechRMSDBFS, config.TargetDBFS, result.ClippedWindow)
+	return nil
+}
+
+// cmdCalibrate records room silence and speech from a device, recommends the
+// volume that brings speech to the target loudness and saves it as the
+// device's enforced target
+func cmdCalibrate(args []string) error {
+	fs, opts := newCommandFlags("calibrate")
+	quietFor := fs.Duration("quiet", defaultCalibrateQuiet, "length of the room silence recording")
+	speechFor := fs.Duration("speech", defaultCalibrateSpeech, "length of the speech recording")
+	target := fs.Float64("target", defaultAGCTargetDBFS, "speech loudness to aim for in dBFS")
+	dryRun := fs.Bool("dry-run", false, "print the recommendation without saving it")
+	quietWAV := fs.String("quiet-wav", "", "use a recording of room silence made at 100% volume")
+	speechWAV := fs.String("speech-wav", "", "use a recording of speech made at 100% volume")
+	if err := parseCommandFlags(fs, opts, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *quietFor <= 0 || *speechFor <= 0 || (*quietWAV == "") != (*speechWAV == "") {
+		return errUsage
+	}
+	if *target >= 0 {
+		return fmt.Errorf("target must be below 0 dBFS")
+	}
+
+	backend, err := openBackend()
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	status, err := backend.getDevice(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var quiet, speech sampleSource
+	volume := 1.0
+	if *quietWAV != "" {
+		if quiet, err = openWAVSource(*quietWAV, false); err != nil {
+			return err
+		}
+		if speech, err = openWAVSource(*speechWAV, false); err != nil {
+			return err
+		}
+	} else {
+		switch {
+		case status.AGC:
+			return fmt.Errorf("automatic gain control is adjusting %s; turn it off with \"micmaxer agc %s off\" first", status.Name, fs.Arg(0))
+		case status.Volume == nil:
+			return fmt.Errorf("cannot read the volume of %s: %s", status.Name, status.Error)
+		case status.Muted != nil && *status.Muted:
+			return fmt.Errorf("%s is muted; unmute it before calibrating", status.Name)
+		}
+		volume = float64(*status.Volume) / 100
+
+		// Capture is local even when an instance is running, so the
+		// device list this process captures from is scanned here
+		if _, err := newDirectBackend(); err != nil {
+			return err
+		}
+		capture, err := newCaptureSource(status.ID)
+		if err != nil {
+			return err
+		}
+		quiet, speech = capture, capture
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if quiet.live() {
+		fmt.Fprintf(cliErr, "Calibrating %s at %d%% volume.\n", status.Name, volumePercent(float32(volume)))
+		fmt.Fprintf(cliErr, "Stay quiet for %s while the room noise is measured...\n", *quietFor)
+		time.Sleep(time.Second) // let the noise of pressing Enter die away
+	}
+	quietReadings, err := recordLevels(ctx, quiet, *quietFor)
+	if err != nil {
+		return err
+	}
+	if speech.live() {
+		fmt.Fprintf(cliErr, "Now speak normally for %s, as you would in a call...\n", *speechFor)
+	}
+	speechReadings, err := recordLevels(ctx, speech, *speechFor)
+	if err != nil {
+		return err
+	}
+
+	result, err := analyzeCalibration(quietReadings, speechReadings, volume, *target)
+	if err != nil {
+		return err
+	}
+
+	if !*dryRun {
+		if err := saveCalibration(status.ID, result); err != nil {
+			return err
+		}
+		// A running instance keeps targets in memory, so it is told too
+		if client, err := openInstance(); err == nil {
+			params := rpcLevelParams{Device: status.ID, Level: float64(result.RecommendedVolume)}
+			err = client.call("devices.setTarget", params, &status)
+			client.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.json {
+		return writeJSON(result)
+	}
+	fmt.Fprintf(cliOut, "Noise floor:   %6.1f dBFS\n", result.NoiseFloorDBFS)
+	fmt.Fprintf(cliOut, "Speech level:  %6.1f dBFS, peaks %.1f dBFS, at %d%% volume\n",
+		result.SpeechDBFS, result.SpeechPeakDBFS, result.Volume)
+	fmt.Fprintf(cliOut, "Recommended:   %d%% volume for speech near %.0f dBFS\n", result.RecommendedVolume, result.TargetDBFS)
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(cliOut, "Note: %s\n", warning)
+	}
+	switch {
+	case *dryRun:
+	case status.Enforced:
+		fmt.Fprintf(cliOut, "Saved %d%% as the target of %s.\n", result.RecommendedVolume, status.Name)
+	default:
+		fmt.Fprintf(cliOut, "Saved %d%% as the target of %s; enforce it with \"micmaxer check %s\".\n",
+			result.RecommendedVolume, status.Name, fs.Arg(0))
+	}
+	return nil
+}
+
+// cmdHelp prints the list of subcommands
+func cmdHelp(args []string) error {
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(cliOut, "usage: micmaxer [--headless] [--http addr] [--metrics addr] [--log-level level] [--log-format text|json]")
+	fmt.Fprintln(cliOut, "       micmaxer <command> [arguments]")
+	fmt.Fprintln(cliOut)
+	fmt.Fprintln(cliOut, "Devices can be selected by ID, alias, \"default\" or part of their name.")
+	fmt.Fprintln(cliOut, "Command flags may come before or after the arguments; use -- to end them.")
+	fmt.Fprintln(cliOut, "When MicMaxer is running, commands are sent to it over its control socket.")
+	fmt.Fprintln(cliOut)
+	fmt.Fprintln(cliOut, "Commands:")
+	tw := tabwriter.NewWriter(cliOut, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", cliCommands[name].usage, cliCommands[name].summary)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeBackend is a controlBackend over a fixed list of devices
+type fakeBackend struct {
+	devices []deviceStatus
+}
+
+// useFakeBackend makes CLI commands talk to a fake backend and captures
+// their output
+func useFakeBackend(t *testing.T, devices ...deviceStatus) (*fakeBackend, *bytes.Buffer) {
+	t.Helper()
+	b := &fakeBackend{devices: devices}
+	var stdout bytes.Buffer
+	savedOpen, savedOut := openBackend, cliOut
+	openBackend = func() (controlBackend, error) { return b, nil }
+	cliOut = &stdout
+	t.Cleanup(func() { openBackend, cliOut = savedOpen, savedOut })
+	return b, &stdout
+}
+
+func (b *fakeBackend) find(selector string) (*deviceStatus, error) {
+	refs := make([]deviceRef, len(b.devices))
+	for i, d := range b.devices {
+		refs[i] = deviceRef{ID: d.ID, Name: d.Name, Alias: d.Alias, Default: d.Default}
+	}
+	device, err := resolveDevice(refs, selector)
+	if err != nil {
+		return nil, err
+	}
+	return &b.devices[slices.IndexFunc(b.devices, func(d deviceStatus) bool { return d.ID == device.ID })], nil
+}
+
+func (b *fakeBackend) listDevices() ([]deviceStatus, error) { return b.devices, nil }
+
+func (b *fakeBackend) getDevice(selector string) (deviceStatus, error) {
+	d, err := b.find(selector)
+	if err != nil {
+		return deviceStatus{}, err
+	}
+	return *d, nil
+}
+
+func (b *fakeBackend) setVolume(selector string, level float32) (deviceStatus, error) {
+	d, err := b.find(selector)
+	if err != nil {
+		return deviceStatus{}, err
+	}
+	volume := volumePercent(level)
+	d.Volume = &volume
+	return *d, nil
+}
+
+func (b *fakeBackend) setMute(selector string, muted bool) (deviceStatus, error) {
+	d, err := b.find(selector)
+	if err != nil {
+		return deviceStatus{}, err
+	}
+	d.Muted = &muted
+	return *d, nil
+}
+
+func (b *fakeBackend) watch(ctx context.Context, fn func(appEvent)) error { return nil }
+
+func (b *fakeBackend) Close() error { return nil }
+
+func TestCalibrateUsageListsFlags(t *testing.T) {
+	var stderr bytes.Buffer
+	defer func(w io.Writer) { cliErr = w }(cliErr)
+	cliErr = &stderr
+
+	if code := runCLI([]string{"calibrate"}); code != 2 {
+		t.Fatalf("exit code %d, want 2", code)
+	}
+	out := stderr.String()
+	if !strings.HasPrefix(out, "usage: micmaxer calibrate [flags] <device>\n") {
+		t.Errorf("usage starts with %q", strings.SplitN(out, "\n", 2)[0])
+	}
+	for _, name := range []string{"-dry-run", "-quiet", "-quiet-wav", "-speech", "-speech-wav", "-target"} {
+		if !strings.Contains(out, "  "+name+" ") && !strings.Contains(out, "  "+name+"\n") {
+			t.Errorf("usage does not list %s:\n%s", name, out)
+		}
+	}
+}
+
+func TestHelpListsRunFlags(t *testing.T) {
+	_, stdout := useFakeBackend(t)
+	if code := runCLI([]string{"help"}); code != 0 {
+		t.Fatalf("exit code %d, want 0", code)
+	}
+	usage := strings.SplitN(stdout.String(), "\n", 2)[0]
+	for _, name := range []string{"--headless", "--http", "--metrics", "--log-level", "--log-format"} {
+		if !strings.Contains(usage, name) {
+			t.Errorf("usage line %q does not list %s", usage, name)
+		}
+	}
+}
+
+func TestResolveDevice(t *testing.T) {
+	devices := []deviceRef{
+		{ID: "mic-1", Name: "USB Microphone", Alias: "podcast"},
+		{ID: "mic-2", Name: "MacBook Pro Microphone", Default: true},
+		{ID: "podcast", Name: "Podcast Interface"},
+		{ID: "line", Name: "Default Line In"},
+	}
+
+	tests := []struct {
+		selector string
+		want     string // device ID, or the start of the error
+	}{
+		{"mic-1", "mic-1"},
+		{"podcast", "podcast"}, // an ID wins over an alias
+		{"PODCAST", "mic-1"},   // aliases ignore case, IDs do not
+		{"default", "mic-2"},   // the keyword wins over a name
+		{"Default", "mic-2"},
+		{"usb", "mic-1"},
+		{"macbook pro", "mic-2"},
+		{"line in", "line"},
+		{"microphone", `"microphone" matches several devices: USB Microphone, MacBook Pro Microphone`},
+		{"speaker", `no device matches "speaker"`},
+	}
+	for _, tt := range tests {
+		device, err := resolveDevice(devices, tt.selector)
+		got := device.ID
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("resolveDevice(%q) = %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+
+	if _, err := resolveDevice(devices[:1], "default"); err == nil || err.Error() != "no default input device" {
+		t.Errorf("resolveDevice(default) without a default device = %v, want no default input device", err)
+	}
+}
+
+func TestParseVolumeLevel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float32
+		ok   bool
+	}{
+		{"80", 0.8, true},
+		{"80%", 0.8, true},
+		{" 55.5% ", 0.555, true},
+		{"0", 0, true},
+		{"100%", 1, true},
+		{"-1", 0, false},
+		{"101", 0, false},
+		{"100.5%", 0, false},
+		{"loud", 0, false},
+		{"80%%", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseVolumeLevel(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseVolumeLevel(%q) = %v, %v, want %v (ok %v)", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseCommandFlagsAfterArguments(t *testing.T) {
+	tests := []struct {
+		args []string
+		json bool
+		rest []string
+	}{
+		{[]string{"--json", "mic", "80%"}, true, []string{"mic", "80%"}},
+		{[]string{"mic", "80%", "--json"}, true, []string{"mic", "80%"}},
+		{[]string{"mic", "--json", "80%"}, true, []string{"mic", "80%"}},
+		{[]string{"mic", "--", "--json"}, false, []string{"mic", "--json"}},
+		{[]string{"--", "-5"}, false, []string{"-5"}},
+		{nil, false, []string{}},
+	}
+	for _, tt := range tests {
+		fs, opts := newCommandFlags("test")
+		if err := parseCommandFlags(fs, opts, tt.args); err != nil {
+			t.Errorf("parseCommandFlags(%q): %v", tt.args, err)
+			continue
+		}
+		if opts.json != tt.json || !slices.Equal(fs.Args(), tt.rest) {
+			t.Errorf("parseCommandFlags(%q) = json %v args %q, want json %v args %q", tt.args, opts.json, fs.Args(), tt.json, tt.rest)
+		}
+	}
+
+	fs, opts := newCommandFlags("test")
+	if err := parseCommandFlags(fs, opts, []string{"mic", "--bogus"}); err == nil {
+		t.Error("unknown flag after the arguments accepted")
+	}
+}
+
+func TestDeviceCommandsJSON(t *testing.T) {
+	volume, muted := 43, false
+	b, stdout := useFakeBackend(t,
+		deviceStatus{ID: "mic-1", Name: "USB Microphone", Alias: "podcast", Enforced: true, MutePolicy: mutePolicyUnmuted, Target: 90, Volume: &volume, Muted: &muted},
+		deviceStatus{ID: "mic-2", Name: "MacBook Pro Microphone", Default: true, Target: 100, Error: "device unavailable"},
+	)
+
+	// run runs a command and decodes its output
+	run := func(v any, args ...string) {
+		t.Helper()
+		stdout.Reset()
+		if code := runCLI(args); code != 0 {
+			t.Fatalf("%q: exit code %d", args, code)
+		}
+		if err := json.Unmarshal(stdout.Bytes(), v); err != nil {
+			t.Fatalf("%q printed invalid JSON %q: %v", args, stdout, err)
+		}
+	}
+
+	var list []map[string]any
+	run(&list, "devices", "--json")
+	if len(list) != 2 {
+		t.Fatalf("devices --json listed %d devices, want 2", len(list))
+	}
+	wantKeys := [][]string{
+		{"alias", "default", "enforced", "id", "mute_policy", "muted", "name", "target", "volume"},
+		{"default", "enforced", "error", "id", "name", "target"},
+	}
+	for i, want := range wantKeys {
+		if got := slices.Sorted(maps.Keys(list[i])); !slices.Equal(got, want) {
+			t.Errorf("devices --json device %d has keys %q, want %q", i, got, want)
+		}
+	}
+
+	var got deviceStatus
+	run(&got, "get", "podcast", "--json")
+	if !reflect.DeepEqual(got, b.devices[0]) {
+		t.Errorf("get --json = %+v, want %+v", got, b.devices[0])
+	}
+
+	for _, args := range [][]string{{"set", "--json", "usb", "80%"}, {"set", "usb", "80", "--json"}} {
+		got = deviceStatus{}
+		run(&got, args...)
+		if got.ID != "mic-1" || got.Volume == nil || *got.Volume != 80 {
+			t.Errorf("%q printed %+v, want mic-1 at 80%%", args, got)
+		}
+	}
+
+	run(&got, "mute", "default", "--json")
+	if got.ID != "mic-2" || got.Muted == nil || !*got.Muted {
+		t.Errorf("mute --json printed %+v, want mic-2 muted", got)
+	}
+
+	// Without --json the result is a line of text
+	stdout.Reset()
+	if code := runCLI([]string{"get", "mic-1"}); code != 0 || stdout.String() != "USB Microphone: 80%\n" {
+		t.Errorf("get = %d %q, want USB Microphone: 80%%", code, stdout)
+	}
+}
+
+func TestOpenBackendWithoutInstance(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	useTestState(t)
+
+	// Without a running instance commands use the audio backend directly
+	backend, err := connectBackend()
+	if err != nil {
+		t.Skipf("no audio backend: %v", err)
+	}
+	backend.Close()
+	if _, ok := backend.(*directBackend); !ok {
+		t.Errorf("backend without a running instance is %T, want *directBackend", backend)
+	}
+
+	if err := startControlServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer stopControlServer()
+	backend, err = connectBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Close()
+	if _, ok := backend.(*rpcBackend); !ok {
+		t.Errorf("backend with a running instance is %T, want *rpcBackend", backend)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "time"
+
+// clock tells the time. The scheduler reads it from here, so schedules can
+// be evaluated at any time without waiting for it.
+type clock interface {
+	Now() time.Time
+}
+
+// systemClock is the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) stopper { return time.AfterFunc(d, f) }
+
+// timerClock is a clock that also runs functions after a delay. Timed
+// pauses and push-to-talk holds use it, so tests can advance a fake clock
+// instead of sleeping.
+type timerClock interface {
+	clock
+	AfterFunc(d time.Duration, f func()) stopper
+}
+
+// stopper cancels a function started by a timerClock
+type stopper interface {
+	Stop() bool
+}
+
+// fixedClock always tells the same time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// appConfig holds user settings that are shared between the app and the CLI
+type appConfig struct {
+	Devices       map[string]*deviceConfig `json:"devices,omitempty"` // keyed by device ID
+	HTTP          *httpConfig              `json:"http,omitempty"`
+	Logging       *loggingConfig           `json:"logging,omitempty"`
+	Metrics       *metricsConfig           `json:"metrics,omitempty"`
+	History       *historyConfig           `json:"history,omitempty"`
+	Alerts        *alertsConfig            `json:"alerts,omitempty"`
+	Tray          *trayConfig              `json:"tray,omitempty"`
+	Notifications *notificationsConfig     `json:"notifications,omitempty"`
+	Hotkeys       map[string]string        `json:"hotkeys,omitempty"` // action to key combination, e.g. "ctrl+alt+m"
+	PushToTalk    *pushToTalkConfig        `json:"push_to_talk,omitempty"`
+	Schedule      []scheduleWindow         `json:"schedule,omitempty"` // enforcement windows of devices without their own
+}
+
+// httpConfig holds the settings for the optional loopback HTTP API
+type httpConfig struct {
+	Listen string `json:"listen,omitempty"` // e.g. 127.0.0.1:8765; empty disables the API
+	Token  string `json:"token,omitempty"`
+}
+
+// metricsConfig holds the settings for the optional Prometheus metrics endpoint
+type metricsConfig struct {
+	Listen string `json:"listen,omitempty"` // e.g. 127.0.0.1:9465 or 0.0.0.0:9465; empty disables the endpoint
+	Token  string `json:"token,omitempty"`  // bearer token scrapes must send; none required if empty
+}
+
+// deviceConfig holds the settings for a single audio input device
+type deviceConfig struct {
+	Alias       string             `json:"alias,omitempty"`
+	Target      *int               `json:"target,omitempty"` // percent, defaults to 100
+	AGC         *agcConfig         `json:"agc,omitempty"`
+	Calibration *calibrationResult `json:"calibration,omitempty"` // last calibrate run
+	MutePolicy  string             `json:"mute_policy,omitempty"` // "unmuted" or "muted" to enforce a mute state
+	Schedule    []scheduleWindow   `json:"schedule,omitempty"`    // enforcement windows; enforced at all times if empty
+}
+
+// configMu serialises read-modify-write cycles on the config file
+var configMu sync.Mutex
+
+// configPath returns the location of the per-user configuration file
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user config directory: %w", err)
+	}
+	return filepath.Join(dir, "MicMaxer2", "config.json"), nil
+}
+
+// loadConfig reads the configuration file, returning an empty configuration
+// if it does not exist yet
+func loadConfig() (*appConfig, error) {
+	cfg := &appConfig{Devices: make(map[string]*deviceConfig)}
+
+	path, err := configPath()
+	if err != nil {
+		return cfg, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if cfg.Devices == nil {
+		cfg.Devices = make(map[string]*deviceConfig)
+	}
+
+	return cfg, nil
+}
+
+// saveConfig writes the configuration file atomically
+func saveConfig(cfg *appConfig) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated config.
+	// The file holds the HTTP API token, so keep it private to the user.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace config: %w", err)
+	}
+
+	return nil
+}
+
+// updateConfig loads the config file, applies fn and saves the result
+func updateConfig(fn func(cfg *appConfig)) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	fn(cfg)
+	return saveConfig(cfg)
+}
+
+// device returns the settings for a device, creating them if needed
+func (c *appConfig) device(deviceID string) *deviceConfig {
+	dc, ok := c.Devices[deviceID]
+	if !ok {
+		dc = &deviceConfig{}
+		c.Devices[deviceID] = dc
+	}
+	return dc
+}
+
+// deviceAlias returns the alias configured for a device, if any
+func (c *appConfig) deviceAlias(deviceID string) string {
+	if dc, ok := c.Devices[deviceID]; ok {
+		return dc.Alias
+	}
+	return ""
+}
+
+// setDeviceAlias assigns an alias to a device, removing it from any other
+// device first. An empty alias clears the device's alias.
+func (c *appConfig) setDeviceAlias(deviceID, alias string) {
+	for _, dc := range c.Devices {
+		if alias != "" && strings.EqualFold(dc.Alias, alias) {
+			dc.Alias = ""
+		}
+	}
+
+	c.device(deviceID).Alias = alias
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// volumeTolerance is how far the device volume may drift from its target
+// before it is corrected, absorbing rounding in the Core Audio scalar
+const volumeTolerance = 0.01
+
+// Mute policies choose how enforcement treats a device's mute state
+const (
+	mutePolicyAllow   = "allow"   // leave muting to the user
+	mutePolicyUnmuted = "unmuted" // unmute the device whenever it is muted
+	mutePolicyMuted   = "muted"   // keep the device muted
+)
+
+// Another application repeatedly lowering the volume shows up as frequent
+// corrections; this many within the window is reported as a conflict
+const (
+	conflictWindow    = 30 * time.Second
+	conflictThreshold = 3
+)
+
+// conflictTracker records recent corrections per device
+type conflictTracker struct {
+	mu          sync.Mutex
+	corrections map[string][]time.Time
+}
+
+// Global conflict tracker instance
+var conflicts = &conflictTracker{
+	corrections: make(map[string][]time.Time),
+}
+
+// record notes a correction and returns how many happened within the
+// conflict window. The history is cleared once the threshold is reached so
+// each conflict is reported once per window.
+func (c *conflictTracker) record(deviceID string, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	recent := c.corrections[deviceID][:0]
+	for _, t := range c.corrections[deviceID] {
+		if now.Sub(t) < conflictWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) >= conflictThreshold {
+		delete(c.corrections, deviceID)
+	} else {
+		c.corrections[deviceID] = recent
+	}
+	return len(recent)
+}
+
+// publishCorrection publishes a volume correction and reports a conflict if
+// the device keeps being changed back
+func publishCorrection(ev appEvent) {
+	ev.Type = eventVolumeCorrected
+	events.publish(ev)
+	metrics.recordCorrection(ev)
+
+	if count := conflicts.record(ev.DeviceID, time.Now()); count >= conflictThreshold {
+		slog.Warn("Conflict detected - another application may be changing the volume",
+			"device", ev.DeviceName, "device_id", ev.DeviceID, "corrections", count, "window", conflictWindow.String(),
+			"app", ev.App)
+		events.publish(appEvent{
+			Type:       eventConflictDetected,
+			DeviceID:   ev.DeviceID,
+			DeviceName: ev.DeviceName,
+			Volume:     ev.Volume,
+			Target:     ev.Target,
+			Count:      count,
+			App:        ev.App,
+		})
+	}
+}
+
+// failureTracker remembers the devices whose volume could not be set, so a
+// failing device is reported once rather than on every enforcer pass
+type failureTracker struct {
+	mu      sync.Mutex
+	failing map[string]bool
+}
+
+// Global enforcement failure tracker instance
+var failures = &failureTracker{
+	failing: make(map[string]bool),
+}
+
+// reportEnforcementResult records whether a device's target volume could be
+// applied, publishing an event when the device starts failing. Failures are
+// reported by the enforcer whatever applied the volume, so they are notified
+// even when checking a device fails.
+func reportEnforcementResult(deviceID, deviceName string, target float32, err error) {
+	icon.setDeviceError(deviceID, err)
+
+	failures.mu.Lock()
+	wasFailing := failures.failing[deviceID]
+	if err != nil {
+		failures.failing[deviceID] = true
+	} else {
+		delete(failures.failing, deviceID)
+	}
+	failures.mu.Unlock()
+
+	if err != nil && !wasFailing {
+		events.publish(appEvent{
+			Type:       eventEnforcementFailed,
+			DeviceID:   deviceID,
+			DeviceName: deviceName,
+			Target:     volumePercent(target),
+			Source:     sourceEnforcer,
+			Error:      err.Error(),
+		})
+	}
+}
+
+// volumeChanger guesses which application changed an input volume from the
+// applications capturing input at the time, or returns "" if none are
+func volumeChanger() string {
+	return strings.Join(inputCapturingApps(), " or ")
+}
+
+// appStatus summarises the running instance for the control APIs
+type appStatus struct {
+	Paused      bool              `json:"paused"`
+	PausedUntil *time.Time        `json:"paused_until,omitempty"`
+	PushToTalk  *pushToTalkStatus `json:"push_to_talk,omitempty"`
+	Devices     []deviceStatus    `json:"devices"`
+}
+
+// targetLocked returns the target volume for a device, taking it from the
+// device's open schedule window if that sets one; the caller must hold s.mu
+func (s *audioState) targetLocked(deviceID string) float32 {
+	if target := s.scheduled[deviceID].target; target != nil {
+		return *target
+	}
+	return s.ownTargetLocked(deviceID)
+}
+
+// ownTargetLocked returns the target volume set for a device itself; the
+// caller must hold s.mu
+func (s *audioState) ownTargetLocked(deviceID string) float32 {
+	if target, ok := s.deviceTargets[deviceID]; ok {
+		return target
+	}
+	return targetVolumeLevel
+}
+
+// deviceNameLocked returns the name of a scanned device; the caller must hold s.mu
+func (s *audioState) deviceNameLocked(deviceID string) string {
+	for _, device := range s.audioInputDevices {
+		if device.ID.String() == deviceID {
+			return device.Name()
+		}
+	}
+	return "Unknown"
+}
+
+// volumeDiffers reports whether a volume is outside the tolerance of its target
+func volumeDiffers(volume, target float32) bool {
+	return math.Abs(float64(volume-target)) > volumeTolerance
+}
+
+// loadDeviceTargets restores per-device target volumes and mute policies from
+// the config file
+func loadDeviceTargets() {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	for deviceID, dc := range cfg.Devices {
+		if dc.Target != nil {
+			state.deviceTargets[deviceID] = float32(*dc.Target) / 100
+		}
+		if dc.MutePolicy != "" {
+			state.mutePolicies[deviceID] = dc.MutePolicy
+		}
+	}
+}
+
+// setDeviceMutePolicy changes how enforcement treats the mute state of a
+// device, persists it and applies it immediately if the device is enforced
+func setDeviceMutePolicy(deviceID, policy string) error {
+	switch policy {
+	case mutePolicyAllow, mutePolicyUnmuted, mutePolicyMuted:
+	default:
+		return fmt.Errorf("invalid mute policy %q: expected %s, %s or %s",
+			policy, mutePolicyAllow, mutePolicyUnmuted, mutePolicyMuted)
+	}
+
+	state.mu.Lock()
+	if policy == mutePolicyAllow {
+		delete(state.mutePolicies, deviceID)
+	} else {
+		state.mutePolicies[deviceID] = policy
+	}
+	name := state.deviceNameLocked(deviceID)
+	state.mu.Unlock()
+
+	slog.Info("Device mute policy changed", "device", name, "device_id", deviceID, "mute_policy", policy, "source", sourceUser)
+	err := updateConfig(func(cfg *appConfig) {
+		if policy == mutePolicyAllow {
+			policy = ""
+		}
+		cfg.device(deviceID).MutePolicy = policy
+	})
+	if err != nil {
+		return err
+	}
+
+	enforceMutePolicy(deviceID, sourceUser)
+	return nil
+}
+
+// mutePolicyLocked returns the mute policy enforced on a device: the one
+// push-to-talk imposes, pttPolicy, unless the device's own policy keeps it
+// muted; the caller must hold s.mu
+func (s *audioState) mutePolicyLocked(deviceID, pttPolicy string) string {
+	policy := s.mutePolicies[deviceID]
+	if pttPolicy != "" && policy != mutePolicyMuted {
+		return pttPolicy
+	}
+	return policy
+}
+
+// enforceMutePolicy mutes or unmutes an enforced, unpaused device whose mute
+// state differs from its policy, or from the state push-to-talk imposes
+func enforceMutePolicy(deviceID, source string) {
+	pttPolicy := ptt.mutePolicy()
+	state.mu.RLock()
+	policy := state.mutePolicyLocked(deviceID, pttPolicy)
+	active := state.deviceStates[deviceID] && !state.pausedLocked(deviceID)
+	name := state.deviceNameLocked(deviceID)
+	state.mu.RUnlock()
+
+	if !active || (policy != mutePolicyUnmuted && policy != mutePolicyMuted) {
+		return
+	}
+	want := policy == mutePolicyMuted
+	muted, err := getSystemInputMute(deviceID)
+	if err != nil || muted == want {
+		return
+	}
+
+	if err := setSystemInputMute(deviceID, want); err != nil {
+		slog.Error("Failed to apply mute policy",
+			"device", name, "device_id", deviceID, "mute_policy", policy, "source", source, "error", err)
+		return
+	}
+	slog.Info("Corrected mute state",
+		"device", name, "device_id", deviceID, "muted", want, "mute_policy", policy, "source", source)
+}
+
+// makeDefaultInputDevice makes a device the system default input and moves
+// the volume change listener over to it
+func makeDefaultInputDevice(deviceID string) error {
+	// The listener stays on the device it was registered on, so it is
+	// unregistered while the old device is still the default
+	listening := stopVolumeChangeListener() == nil
+	err := setSystemDefaultInput(deviceID)
+	if listening {
+		if err := startVolumeChangeListener(); err != nil {
+			slog.Error("Failed to restart volume change listener", "error", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	refreshAudioInputDevices()
+	state.mu.RLock()
+	name := state.deviceNameLocked(deviceID)
+	state.mu.RUnlock()
+	slog.Info("Default input device changed", "device", name, "device_id", deviceID, "source", sourceUser)
+	return nil
+}
+
+// renameDevice sets the alias a device is shown and selected by; an empty
+// name restores the device's own name
+func renameDevice(deviceID, alias string) error {
+	return updateConfig(func(cfg *appConfig) {
+		cfg.setDeviceAlias(deviceID, strings.TrimSpace(alias))
+	})
+}
+
+// setDeviceChecked enables or disables enforcement for a device, saves the
+// preference and, when enabling, applies the device's target volume unless
+// its schedule releases it
+func setDeviceChecked(deviceID string, checked bool) {
+	current, scheduled := schedules.at(deviceID)
+
+	state.mu.Lock()
+	wasChecked := state.deviceStates[deviceID]
+	state.deviceStates[deviceID] = checked
+	if checked && scheduled {
+		state.scheduled[deviceID] = current
+	} else {
+		delete(state.scheduled, deviceID)
+	}
+	name := state.deviceNameLocked(deviceID)
+	target := state.targetLocked(deviceID)
+	state.mu.Unlock()
+	volume := ptt.volume(target)
+
+	if wasChecked == checked {
+		return
+	}
+
+	// Log the state change
+	slog.Info("Device enforcement toggled", "device", name, "device_id", deviceID, "enforced", checked, "source", sourceUser)
+
+	// Save preferences
+	saveDeviceStates()
+
+	evType := eventDeviceUnchecked
+	if checked {
+		evType = eventDeviceChecked
+	}
+	events.publish(appEvent{Type: evType, DeviceID: deviceID, DeviceName: name, Target: volumePercent(target), Source: sourceUser})
+
+	// A device no longer enforced is no longer failing
+	if !checked {
+		reportEnforcementResult(deviceID, name, target, nil)
+	}
+
+	if checked && current.released {
+		slog.Info("Device released by its schedule", "device", name, "device_id", deviceID, "source", sourceUser)
+		return
+	}
+
+	// If going from unchecked to checked, query and log the audio level, then set it to the target
+	if checked {
+		level, err := getAudioInputLevel(deviceID)
+		if err != nil {
+			slog.Warn("Failed to get audio level", "device", name, "device_id", deviceID, "error", err)
+		}
+
+		err = setSystemInputLevel(deviceID, volume)
+		reportEnforcementResult(deviceID, name, volume, err)
+		if err != nil {
+			slog.Error("Failed to set audio level",
+				"device", name, "device_id", deviceID, "new_volume", volumePercent(volume), "source", sourceUser, "error", err)
+		} else {
+			slog.Info("Set audio level",
+				"device", name, "device_id", deviceID, "old_volume", level, "new_volume", volumePercent(volume), "source", sourceUser)
+		}
+		enforceMutePolicy(deviceID, sourceUser)
+	}
+}
+
+// toggleDevice flips the enforcement state of a device and returns the new state
+func toggleDevice(deviceID string) bool {
+	state.mu.RLock()
+	checked := !state.deviceStates[deviceID]
+	state.mu.RUnlock()
+
+	setDeviceChecked(deviceID, checked)
+	return checked
+}
+
+// setDeviceTarget changes the volume enforced for a device, persists it and
+// applies it immediately if the device is enforced
+func setDeviceTarget(deviceID string, target float32) error {
+	state.mu.Lock()
+	previous := volumePercent(state.ownTargetLocked(deviceID))
+	state.deviceTargets[deviceID] = target
+	checked := state.deviceStates[deviceID] && !state.releasedLocked(deviceID)
+	effective := state.targetLocked(deviceID) // an open schedule window may set another
+	name := state.deviceNameLocked(deviceID)
+	state.mu.Unlock()
+
+	percent := volumePercent(target)
+	slog.Info("Device target changed", "device", name, "device_id", deviceID, "target", percent, "source", sourceUser)
+
+	err := updateConfig(func(cfg *appConfig) {
+		cfg.device(deviceID).Target = &percent
+	})
+	if err != nil {
+		return err
+	}
+
+	events.publish(appEvent{
+		Type:       eventTargetChanged,
+		DeviceID:   deviceID,
+		DeviceName: name,
+		Target:     percent,
+		Previous:   &previous,
+		Source:     sourceUser,
+	})
+
+	// Devices under automatic gain control are not pinned to a target
+	if checked && !agc.active(deviceID) {
+		if err := setSystemInputLevel(deviceID, ptt.volume(effective)); err != nil {
+			slog.Error("Failed to set audio level",
+				"device", name, "device_id", deviceID, "new_volume", percent, "source", sourceUser, "error", err)
+		}
+	}
+	return nil
+}
+
+// listenerTarget returns the volume the change listener should restore on the
+// default input device, and whether it should enforce at all
+func listenerTarget() (float32, bool) {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	if state.paused {
+		return 0, false
+	}
+
+	for _, device := range state.audioInputDevices {
+		if device.IsDefault != 0 && state.pausedLocked(device.ID.String()) {
+			return 0, false
+		}
+		if device.IsDefault != 0 && agc.active(device.ID.String()) {
+			return 0, false // volume changes are AGC's own adjustments
+		}
+		if device.IsDefault != 0 && state.deviceStates[device.ID.String()] {
+			return ptt.volume(state.targetLocked(device.ID.String())), true
+		}
+	}
+
+	// The listener only observes the default device, so keep enforcing the
+	// default target whenever any device is selected
+	for _, enabled := range state.deviceStates {
+		if enabled {
+			return ptt.volume(targetVolumeLevel), true
+		}
+	}
+	return 0, false
+}
+
+// currentStatus collects the enforcement state and current levels of all devices
+func currentStatus() appStatus {
+	state.mu.RLock()
+	status := appStatus{Paused: state.paused}
+	if until := state.pausedUntil; state.paused && !until.IsZero() {
+		status.PausedUntil = &until
+	}
+	state.mu.RUnlock()
+	status.PushToTalk = ptt.status()
+
+	devices := knownDevices()
+	status.Devices = make([]deviceStatus, 0, len(devices))
+	for _, d := range devices {
+		status.Devices = append(status.Devices, queryDeviceStatus(d))
+	}
+	return status
+}
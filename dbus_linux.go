@@ -0,0 +1,310 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// D-Bus names used by the service
+const (
+	dbusName      = "com.alberts.MicMaxer2"
+	dbusPath      = dbus.ObjectPath("/com/alberts/MicMaxer2")
+	dbusInterface = "com.alberts.MicMaxer2"
+
+	dbusErrorNoSuchDevice = dbusInterface + ".Error.NoSuchDevice"
+	dbusErrorFailed       = dbusInterface + ".Error.Failed"
+)
+
+// dbusDevice is the D-Bus representation of a device, signature (sssbbiib).
+// Volume is -1 when it cannot be read.
+type dbusDevice struct {
+	ID       string
+	Name     string
+	Alias    string
+	Default  bool
+	Enforced bool
+	Target   int32
+	Volume   int32
+	Muted    bool
+}
+
+// dbusMethods holds the exported D-Bus methods; every exported method on
+// this type becomes part of the interface
+type dbusMethods struct{}
+
+// dbusService owns the bus name and mirrors enforcement state as properties and signals
+type dbusService struct {
+	conn        *dbus.Conn
+	props       *prop.Properties
+	unsubscribe func()
+}
+
+// Running D-Bus service, if any
+var dbusSvc *dbusService
+
+// startDBusService claims the well-known name on the session bus and exports
+// the MicMaxer interface. DBUS_SESSION_BUS_ADDRESS selects the bus, so the
+// service can be exercised against a private dbus-daemon --session instance.
+func startDBusService() error {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to session bus: %w", err)
+	}
+
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to request name %s: %w", dbusName, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return fmt.Errorf("name %s is already owned by another process", dbusName)
+	}
+
+	methods := dbusMethods{}
+	if err := conn.Export(methods, dbusPath, dbusInterface); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to export methods: %w", err)
+	}
+
+	state.mu.RLock()
+	paused := state.paused
+	state.mu.RUnlock()
+
+	props, err := prop.Export(conn, dbusPath, prop.Map{
+		dbusInterface: {
+			"Paused":          {Value: paused, Emit: prop.EmitTrue},
+			"EnforcedDevices": {Value: enforcedDeviceIDs(), Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to export properties: %w", err)
+	}
+
+	node := &introspect.Node{
+		Name: string(dbusPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{
+				Name:       dbusInterface,
+				Methods:    introspect.Methods(methods),
+				Properties: props.Introspection(dbusInterface),
+				Signals: []introspect.Signal{
+					{Name: "VolumeCorrected", Args: []introspect.Arg{
+						{Name: "device_id", Type: "s"},
+						{Name: "device_name", Type: "s"},
+						{Name: "old_volume", Type: "i"},
+						{Name: "new_volume", Type: "i"},
+						{Name: "source", Type: "s"},
+					}},
+					{Name: "DeviceAdded", Args: []introspect.Arg{
+						{Name: "device_id", Type: "s"},
+						{Name: "device_name", Type: "s"},
+					}},
+					{Name: "DeviceRemoved", Args: []introspect.Arg{
+						{Name: "device_id", Type: "s"},
+						{Name: "device_name", Type: "s"},
+					}},
+					{Name: "ConflictDetected", Args: []introspect.Arg{
+						{Name: "device_id", Type: "s"},
+						{Name: "device_name", Type: "s"},
+						{Name: "corrections", Type: "u"},
+					}},
+				},
+			},
+		},
+	}
+	if err := conn.Export(introspect.NewIntrospectable(node), dbusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to export introspection data: %w", err)
+	}
+
+	ch, unsubscribe := events.subscribe(64)
+	dbusSvc = &dbusService{conn: conn, props: props, unsubscribe: unsubscribe}
+	go dbusSvc.forwardEvents(ch)
+
+	slog.Info("D-Bus service registered", "name", dbusName)
+	return nil
+}
+
+// stopDBusService releases the bus name and closes the connection
+func stopDBusService() {
+	if dbusSvc == nil {
+		return
+	}
+
+	dbusSvc.unsubscribe()
+	_, _ = dbusSvc.conn.ReleaseName(dbusName)
+	dbusSvc.conn.Close()
+	dbusSvc = nil
+}
+
+// forwardEvents turns application events into D-Bus signals and property updates
+func (s *dbusService) forwardEvents(ch <-chan appEvent) {
+	for ev := range ch {
+		var err error
+		switch ev.Type {
+		case eventVolumeCorrected:
+			err = s.conn.Emit(dbusPath, dbusInterface+".VolumeCorrected",
+				ev.DeviceID, ev.DeviceName, int32(ev.Volume), int32(ev.Target), ev.Source)
+		case eventDeviceAdded:
+			err = s.conn.Emit(dbusPath, dbusInterface+".DeviceAdded", ev.DeviceID, ev.DeviceName)
+		case eventDeviceRemoved:
+			err = s.conn.Emit(dbusPath, dbusInterface+".DeviceRemoved", ev.DeviceID, ev.DeviceName)
+		case eventConflictDetected:
+			err = s.conn.Emit(dbusPath, dbusInterface+".ConflictDetected", ev.DeviceID, ev.DeviceName, uint32(ev.Count))
+		case eventEnforcementPaused, eventEnforcementResumed:
+			if ev.DeviceID == "" {
+				s.props.SetMust(dbusInterface, "Paused", ev.Type == eventEnforcementPaused)
+			}
+		case eventDeviceChecked, eventDeviceUnchecked:
+			s.props.SetMust(dbusInterface, "EnforcedDevices", enforcedDeviceIDs())
+		}
+		if err != nil {
+			slog.Warn("Failed to emit D-Bus signal", "event", ev.Type, "error", err)
+		}
+	}
+}
+
+// enforcedDeviceIDs returns the sorted IDs of all enforced devices
+func enforcedDeviceIDs() []string {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	ids := []string{}
+	for id, enabled := range state.deviceStates {
+		if enabled {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// dbusResolveDevice resolves a device selector, mapping failures to a D-Bus error
+func dbusResolveDevice(selector string) (deviceRef, *dbus.Error) {
+	device, err := resolveDevice(knownDevices(), selector)
+	if err != nil {
+		return deviceRef{}, dbus.NewError(dbusErrorNoSuchDevice, []any{err.Error()})
+	}
+	return device, nil
+}
+
+// ListDevices returns all audio input devices with their enforcement settings
+func (dbusMethods) ListDevices() ([]dbusDevice, *dbus.Error) {
+	statuses := currentStatus().Devices
+	devices := make([]dbusDevice, 0, len(statuses))
+	for _, s := range statuses {
+		d := dbusDevice{
+			ID:       s.ID,
+			Name:     s.Name,
+			Alias:    s.Alias,
+			Default:  s.Default,
+			Enforced: s.Enforced,
+			Target:   int32(s.Target),
+			Volume:   -1,
+		}
+		if s.Volume != nil {
+			d.Volume = int32(*s.Volume)
+		}
+		if s.Muted != nil {
+			d.Muted = *s.Muted
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// SetTarget changes the volume enforced for a device, in percent
+func (dbusMethods) SetTarget(device string, percent uint32) *dbus.Error {
+	if percent > 100 {
+		return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []any{"percent must be between 0 and 100"})
+	}
+	d, dbusErr := dbusResolveDevice(device)
+	if dbusErr != nil {
+		return dbusErr
+	}
+	if err := setDeviceTarget(d.ID, float32(percent)/100); err != nil {
+		return dbus.NewError(dbusErrorFailed, []any{err.Error()})
+	}
+	return nil
+}
+
+// SetEnforced enables or disables enforcement for a device
+func (dbusMethods) SetEnforced(device string, enforced bool) *dbus.Error {
+	d, dbusErr := dbusResolveDevice(device)
+	if dbusErr != nil {
+		return dbusErr
+	}
+	setDeviceChecked(d.ID, enforced)
+	return nil
+}
+
+// Pause suspends enforcement for all devices until resumed
+func (dbusMethods) Pause() *dbus.Error {
+	pauseEnforcement("", 0)
+	return nil
+}
+
+// PauseFor suspends enforcement for all devices for the given number of seconds
+func (dbusMethods) PauseFor(seconds uint32) *dbus.Error {
+	pauseEnforcement("", time.Duration(seconds)*time.Second)
+	return nil
+}
+
+// Resume resumes enforcement for all devices
+func (dbusMethods) Resume() *dbus.Error {
+	resumeEnforcement("")
+	return nil
+}
+
+// StartTalking holds push-to-talk for up to the given number of seconds, or
+// 30 seconds if seconds is 0; calling it again renews the hold
+func (dbusMethods) StartTalking(seconds uint32) *dbus.Error {
+	d := defaultPTTHoldLimit
+	if seconds > 0 {
+		d = time.Duration(seconds) * time.Second
+	}
+	if err := ptt.hold(pttHolderAPI, d); err != nil {
+		return dbus.NewError(dbusErrorFailed, []any{err.Error()})
+	}
+	return nil
+}
+
+// StopTalking releases push-to-talk
+func (dbusMethods) StopTalking() *dbus.Error {
+	ptt.unhold(pttHolderAPI)
+	return nil
+}
+
+// PauseDevice suspends enforcement for one device for the given number of
+// seconds, or until resumed if seconds is 0
+func (dbusMethods) PauseDevice(device string, seconds uint32) *dbus.Error {
+	d, dbusErr := dbusResolveDevice(device)
+	if dbusErr != nil {
+		return dbusErr
+	}
+	pauseEnforcement(d.ID, time.Duration(seconds)*time.Second)
+	return nil
+}
+
+// ResumeDevice resumes enforcement for one device
+func (dbusMethods) ResumeDevice(device string) *dbus.Error {
+	d, dbusErr := dbusResolveDevice(device)
+	if dbusErr != nil {
+		return dbusErr
+	}
+	resumeEnforcement(d.ID)
+	return nil
+}
@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// startTestBus starts a private session bus for the test and points
+// DBUS_SESSION_BUS_ADDRESS at it
+func startTestBus(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read the bus address: %v", err)
+	}
+	address = strings.TrimSpace(address)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+	return address
+}
+
+// waitForProperty polls a property of the service until it has want
+func waitForProperty(t *testing.T, obj dbus.BusObject, name string, want any) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v, err := obj.GetProperty(dbusInterface + "." + name)
+		if err == nil && v.Value() == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("property %s = %v (%v), want %v", name, v.Value(), err, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDBusService(t *testing.T) {
+	address := startTestBus(t)
+
+	if err := startDBusService(); err != nil {
+		t.Fatalf("startDBusService: %v", err)
+	}
+	defer stopDBusService()
+	defer resumeEnforcement("")
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	obj := conn.Object(dbusName, dbusPath)
+
+	t.Run("name is owned", func(t *testing.T) {
+		var owner string
+		if err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, dbusName).Store(&owner); err != nil || owner == "" {
+			t.Fatalf("GetNameOwner(%s) = %q, %v", dbusName, owner, err)
+		}
+		if err := startDBusService(); err == nil {
+			t.Error("a second service claimed the name")
+		}
+	})
+
+	t.Run("introspection", func(t *testing.T) {
+		var xml string
+		if err := obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"SetTarget", "PauseFor", "StartTalking", "VolumeCorrected", "EnforcedDevices"} {
+			if !strings.Contains(xml, `name="`+name+`"`) {
+				t.Errorf("introspection data lacks %s", name)
+			}
+		}
+	})
+
+	t.Run("pause and resume", func(t *testing.T) {
+		if err := obj.Call(dbusInterface+".Pause", 0).Err; err != nil {
+			t.Fatal(err)
+		}
+		waitForProperty(t, obj, "Paused", true)
+		if err := obj.Call(dbusInterface+".Resume", 0).Err; err != nil {
+			t.Fatal(err)
+		}
+		waitForProperty(t, obj, "Paused", false)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		err := obj.Call(dbusInterface+".StartTalking", 0, uint32(5)).Err
+		if dbusErr, ok := err.(dbus.Error); !ok || dbusErr.Name != dbusErrorFailed {
+			t.Errorf("StartTalking with push-to-talk off = %v, want %s", err, dbusErrorFailed)
+		}
+	})
+
+	t.Run("signals", func(t *testing.T) {
+		if err := conn.AddMatchSignal(dbus.WithMatchObjectPath(dbusPath), dbus.WithMatchInterface(dbusInterface)); err != nil {
+			t.Fatal(err)
+		}
+		signals := make(chan *dbus.Signal, 8)
+		conn.Signal(signals)
+		defer conn.RemoveSignal(signals)
+
+		events.publish(appEvent{Type: eventVolumeCorrected, DeviceID: "test-mic", DeviceName: "Test Mic", Volume: 40, Target: 90, Source: sourceEnforcer})
+
+		select {
+		case sig := <-signals:
+			if sig.Name != dbusInterface+".VolumeCorrected" {
+				t.Fatalf("received signal %s, want VolumeCorrected", sig.Name)
+			}
+			want := []any{"test-mic", "Test Mic", int32(40), int32(90), sourceEnforcer}
+			if len(sig.Body) != len(want) {
+				t.Fatalf("signal body = %v, want %v", sig.Body, want)
+			}
+			for i := range want {
+				if sig.Body[i] != want[i] {
+					t.Errorf("signal argument %d = %v, want %v", i, sig.Body[i], want[i])
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no VolumeCorrected signal")
+		}
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// startDBusService is a no-op on non-Linux systems, which have no session bus
+func startDBusService() error {
+	return nil
+}
+
+// stopDBusService is a no-op on non-Linux systems
+func stopDBusService() {
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// Errors reported by the audio backend when setting a device volume
+var (
+	errDeviceNotFound    = errors.New("failed to get device")
+	errVolumeUnsupported = errors.New("device doesn't support volume control")
+	errVolumeSetFailed   = errors.New("failed to set volume")
+)
+
+// deviceRef identifies an audio input device together with its user-facing names
+type deviceRef struct {
+	ID      string
+	Name    string
+	Alias   string
+	Default bool
+}
+
+// deviceStatus is the JSON representation of a device and its current settings
+type deviceStatus struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Alias       string          `json:"alias,omitempty"`
+	Default     bool            `json:"default"`
+	Enforced    bool            `json:"enforced"`
+	AGC         bool            `json:"agc,omitempty"`    // volume controlled by automatic gain control
+	Paused      bool            `json:"paused,omitempty"` // enforcement paused for this device alone
+	PausedUntil *time.Time      `json:"paused_until,omitempty"`
+	Schedule    *scheduleStatus `json:"schedule,omitempty"`    // present for enforced devices with a schedule
+	MutePolicy  string          `json:"mute_policy,omitempty"` // "unmuted" or "muted" when enforced
+	Target      int             `json:"target"`                // the open schedule window's target if it sets one
+	Volume      *int            `json:"volume,omitempty"`
+	Muted       *bool           `json:"muted,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// knownDevices returns the devices from the last scan annotated with their
+// configured aliases
+func knownDevices() []deviceRef {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+	}
+
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	devices := make([]deviceRef, 0, len(state.audioInputDevices))
+	for _, info := range state.audioInputDevices {
+		id := info.ID.String()
+		devices = append(devices, deviceRef{
+			ID:      id,
+			Name:    info.Name(),
+			Alias:   cfg.deviceAlias(id),
+			Default: info.IsDefault != 0,
+		})
+	}
+	return devices
+}
+
+// resolveDevice selects a device by exact ID, alias, the keyword "default" or
+// a case-insensitive name substring, in that order of precedence
+func resolveDevice(devices []deviceRef, selector string) (deviceRef, error) {
+	for _, d := range devices {
+		if d.ID == selector {
+			return d, nil
+		}
+	}
+
+	for _, d := range devices {
+		if d.Alias != "" && strings.EqualFold(d.Alias, selector) {
+			return d, nil
+		}
+	}
+
+	if strings.EqualFold(selector, "default") {
+		for _, d := range devices {
+			if d.Default {
+				return d, nil
+			}
+		}
+		return deviceRef{}, fmt.Errorf("no default input device")
+	}
+
+	var matches []deviceRef
+	needle := strings.ToLower(selector)
+	for _, d := range devices {
+		if strings.Contains(strings.ToLower(d.Name), needle) {
+			matches = append(matches, d)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return deviceRef{}, fmt.Errorf("no device matches %q", selector)
+	case 1:
+		return matches[0], nil
+	default:
+		names := make([]string, len(matches))
+		for i, d := range matches {
+			names[i] = d.Name
+		}
+		return deviceRef{}, fmt.Errorf("%q matches several devices: %s", selector, strings.Join(names, ", "))
+	}
+}
+
+// queryDeviceStatus reads the current volume and mute state of a device along
+// with its enforcement settings
+func queryDeviceStatus(d deviceRef) deviceStatus {
+	state.mu.RLock()
+	status := deviceStatus{
+		ID:       d.ID,
+		Name:     d.Name,
+		Alias:    d.Alias,
+		Default:  d.Default,
+		Enforced: state.deviceStates[d.ID],
+		Target:   volumePercent(state.targetLocked(d.ID)),
+	}
+	status.MutePolicy = state.mutePolicies[d.ID]
+	status.Schedule = state.scheduleStatusLocked(d.ID)
+	if until, ok := state.devicePauses[d.ID]; ok {
+		status.Paused = true
+		if !until.IsZero() {
+			status.PausedUntil = &until
+		}
+	}
+	state.mu.RUnlock()
+	status.AGC = agc.active(d.ID)
+
+	level, err := getSystemInputLevel(d.ID)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Volume = &level
+
+	if muted, err := getSystemInputMute(d.ID); err == nil {
+		status.Muted = &muted
+	}
+	return status
+}
+
+// volumePercent converts a 0.0-1.0 volume scalar to a rounded percentage
+func volumePercent(volume float32) int {
+	return int(volume*100 + 0.5)
+}
+
+// refreshAudioInputDevices rescans input devices, publishing events for
+// devices connected or disconnected since the last scan and restoring
+// enforcement on saved devices that reappear
+func refreshAudioInputDevices() {
+	infos, err := listAudioInputDevices()
+	if err != nil {
+		slog.Error("Failed to rescan audio input devices", "error", err)
+		return
+	}
+	updateAudioInputDevices(infos)
+}
+
+// updateAudioInputDevices replaces the scanned devices with infos and acts
+// on the devices that came and went and on a change of the default device
+func updateAudioInputDevices(infos []malgo.DeviceInfo) {
+	state.mu.Lock()
+	var previousDefault, currentDefault string
+	previous := make(map[string]bool, len(state.audioInputDevices))
+	for _, device := range state.audioInputDevices {
+		previous[device.ID.String()] = true
+		if device.IsDefault != 0 {
+			previousDefault = device.ID.String()
+		}
+	}
+	current := make(map[string]string, len(infos))
+	for _, info := range infos {
+		current[info.ID.String()] = info.Name()
+		if info.IsDefault != 0 {
+			currentDefault = info.ID.String()
+		}
+	}
+	removed := make(map[string]string)
+	for _, device := range state.audioInputDevices {
+		if _, ok := current[device.ID.String()]; !ok {
+			removed[device.ID.String()] = device.Name()
+		}
+	}
+	state.audioInputDevices = infos
+	state.mu.Unlock()
+
+	if currentDefault != previousDefault {
+		slog.Info("Default input device changed", "device", current[currentDefault], "device_id", currentDefault)
+		vu.restart()
+	}
+
+	for id, name := range removed {
+		slog.Info("Audio input device disconnected", "device", name, "device_id", id)
+		agc.stop(id)
+		events.publish(appEvent{Type: eventDeviceRemoved, DeviceID: id, DeviceName: name, Source: sourceScan})
+	}
+
+	var added []string
+	for id, name := range current {
+		if !previous[id] {
+			slog.Info("Audio input device connected", "device", name, "device_id", id)
+			events.publish(appEvent{Type: eventDeviceAdded, DeviceID: id, DeviceName: name, Source: sourceScan})
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	saved, err := loadCheckedDevices()
+	if err != nil {
+		slog.Error("Failed to load saved device preferences", "error", err)
+	}
+	savedSet := make(map[string]bool, len(saved))
+	for _, id := range saved {
+		savedSet[id] = true
+	}
+
+	for _, id := range added {
+		state.mu.RLock()
+		checked := state.deviceStates[id]
+		released := state.releasedLocked(id)
+		target := state.targetLocked(id)
+		state.mu.RUnlock()
+
+		switch {
+		case checked && released:
+			// Left alone outside its scheduled windows
+		case checked:
+			// Still enforced from before it was unplugged
+			volume := ptt.volume(target)
+			if err := setSystemInputLevel(id, volume); err != nil {
+				slog.Error("Failed to set audio level",
+					"device", current[id], "device_id", id, "new_volume", volumePercent(volume), "error", err)
+			}
+			enforceMutePolicy(id, sourceScan)
+		case savedSet[id]:
+			setDeviceChecked(id, true)
+		}
+	}
+
+	startConfiguredAGC()
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Number of recent history events included in a diagnostics bundle
+const diagnoseHistoryLimit = 500
+
+// diagnoseDevice describes a scanned device and what the backend can do with it
+type diagnoseDevice struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Default     bool   `json:"default"`
+	Volume      *int   `json:"volume,omitempty"`
+	VolumeError string `json:"volume_error,omitempty"`
+	Muted       *bool  `json:"muted,omitempty"`
+	MuteError   string `json:"mute_error,omitempty"`
+}
+
+// diagnoseInfo describes the build and platform
+type diagnoseInfo struct {
+	Time       time.Time         `json:"time"`
+	GoVersion  string            `json:"go_version"`
+	OS         string            `json:"os"`
+	Arch       string            `json:"arch"`
+	Module     string            `json:"module,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	Settings   map[string]string `json:"build_settings,omitempty"`
+	Running    bool              `json:"instance_running"`
+	Attributed []string          `json:"capturing_apps,omitempty"`
+}
+
+// diagnoseBundle collects the files of a diagnostics archive
+type diagnoseBundle struct {
+	files    map[string][]byte
+	redactor *diagnoseRedactor
+}
+
+// addJSON adds v to the bundle as indented JSON
+func (b *diagnoseBundle) addJSON(name string, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		data = []byte(fmt.Sprintf("error: %v\n", err))
+	}
+	b.add(name, append(data, '\n'))
+}
+
+// add adds a redacted file to the bundle
+func (b *diagnoseBundle) add(name string, data []byte) {
+	b.files[name] = []byte(b.redactor.Replace(string(data)))
+}
+
+// writeZip writes the bundle as a zip archive to path
+func (b *diagnoseBundle) writeZip(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(f)
+	now := time.Now()
+	for _, name := range []string{"info.json", "devices.json", "preferences.json", "status.json", "history.json", "log.txt"} {
+		data, ok := b.files[name]
+		if !ok {
+			continue
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     "micmaxer-diagnostics/" + name,
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err != nil {
+			zw.Close()
+			f.Close()
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// redaction replaces whole occurrences of a secret or personal string
+type redaction struct {
+	value       string
+	replacement string
+	word        bool // only replace whole words, not parts of longer words
+}
+
+// diagnoseRedactor removes the API tokens, home directory and user names
+// from collected data without touching device names that contain them
+type diagnoseRedactor struct {
+	redactions []redaction
+	kept       []string // device names, aliases and IDs left whole, longest first
+}
+
+// newDiagnoseRedactor creates a redactor for the configured tokens and the
+// current user that keeps the configured device aliases
+func newDiagnoseRedactor(cfg *appConfig) *diagnoseRedactor {
+	var home string
+	var tokens, names []string
+	if cfg.HTTP != nil {
+		tokens = append(tokens, cfg.HTTP.Token)
+	}
+	if cfg.Metrics != nil {
+		tokens = append(tokens, cfg.Metrics.Token)
+	}
+	if dir, err := os.UserHomeDir(); err == nil && dir != "/" {
+		home = dir
+	}
+	if u, err := user.Current(); err == nil {
+		names = append(names, u.Name, u.Username)
+	}
+	r := buildDiagnoseRedactor(tokens, home, names)
+	for deviceID, dc := range cfg.Devices {
+		r.keep(deviceID, dc.Alias)
+	}
+	return r
+}
+
+// keep leaves device names, aliases or IDs whole wherever they appear, such
+// as "USB mic" for a user named "mic". A value that is itself redacted, like
+// an alias equal to the user name, is still redacted.
+func (r *diagnoseRedactor) keep(values ...string) {
+	for _, value := range values {
+		if value == "" || slices.Contains(r.kept, value) ||
+			slices.ContainsFunc(r.redactions, func(red redaction) bool { return red.value == value }) {
+			continue
+		}
+		r.kept = append(r.kept, value)
+	}
+	slices.SortStableFunc(r.kept, func(a, b string) int { return len(b) - len(a) })
+}
+
+// buildDiagnoseRedactor creates a redactor; the home directory is replaced
+// as a whole path and user names as whole words
+func buildDiagnoseRedactor(tokens []string, home string, names []string) *diagnoseRedactor {
+	r := &diagnoseRedactor{}
+	for _, token := range tokens {
+		if token != "" {
+			r.redactions = append(r.redactions, redaction{value: token, replacement: "<redacted-token>"})
+		}
+	}
+	if home != "" {
+		r.redactions = append(r.redactions, redaction{value: home, replacement: "~", word: true})
+	}
+	for _, name := range names {
+		if name != "" {
+			r.redactions = append(r.redactions, redaction{value: name, replacement: "<user>", word: true})
+		}
+	}
+	return r
+}
+
+// Replace returns s with every redaction applied in turn. Kept values are
+// swapped for placeholders first and restored afterwards.
+func (r *diagnoseRedactor) Replace(s string) string {
+	placeholders := make([]string, 0, 2*len(r.kept))
+	restore := make([]string, 0, 2*len(r.kept))
+	for i, value := range r.kept {
+		placeholder := fmt.Sprintf("\x00%d\x00", i)
+		placeholders = append(placeholders, value, placeholder)
+		restore = append(restore, placeholder, value)
+	}
+	if len(r.kept) > 0 {
+		s = strings.NewReplacer(placeholders...).Replace(s)
+	}
+
+	for _, red := range r.redactions {
+		s = red.apply(s)
+	}
+
+	if len(r.kept) > 0 {
+		s = strings.NewReplacer(restore...).Replace(s)
+	}
+	return s
+}
+
+// apply replaces the occurrences of the redacted value in s
+func (red redaction) apply(s string) string {
+	if !red.word {
+		return strings.ReplaceAll(s, red.value, red.replacement)
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(s, red.value)
+		if i < 0 {
+			break
+		}
+		end := i + len(red.value)
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if (i > 0 && isWordRune(before)) || (end < len(s) && isWordRune(after)) {
+			// Part of a longer word or path component, such as "mic" in "Microphone"
+			b.WriteString(s[:i+1])
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(s[:i])
+		b.WriteString(red.replacement)
+		s = s[end:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// isWordRune reports whether r continues a word or a path component
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
+
+// collectDiagnostics gathers device, preference, history, build and log
+// information into a bundle
+func collectDiagnostics(logLines int) (*diagnoseBundle, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	bundle := &diagnoseBundle{files: make(map[string][]byte), redactor: newDiagnoseRedactor(cfg)}
+
+	// Device enumeration and backend capabilities
+	var devices []diagnoseDevice
+	if err := scanAudioInputDevices(); err != nil {
+		bundle.add("devices.json", []byte(fmt.Sprintf("error: %v\n", err)))
+	} else {
+		state.mu.RLock()
+		infos := state.audioInputDevices
+		state.mu.RUnlock()
+
+		for _, info := range infos {
+			d := diagnoseDevice{ID: info.ID.String(), Name: info.Name(), Default: info.IsDefault != 0}
+			bundle.redactor.keep(d.ID, d.Name)
+			if level, err := getSystemInputLevel(d.ID); err != nil {
+				d.VolumeError = err.Error()
+			} else {
+				d.Volume = &level
+			}
+			if muted, err := getSystemInputMute(d.ID); err != nil {
+				d.MuteError = err.Error()
+			} else {
+				d.Muted = &muted
+			}
+			devices = append(devices, d)
+		}
+		bundle.addJSON("devices.json", devices)
+	}
+
+	// Preferences: the config file with secrets redacted and the checked devices
+	checked, err := loadCheckedDevices()
+	prefs := map[string]any{"config": cfg, "checked_devices": checked}
+	if err != nil {
+		prefs["checked_devices_error"] = err.Error()
+	}
+	bundle.addJSON("preferences.json", prefs)
+
+	// State of the running instance, if any
+	info := diagnoseInfo{
+		Time:       time.Now(),
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Attributed: inputCapturingApps(),
+	}
+	if client, err := dialControl(); err == nil {
+		info.Running = true
+
+		var status appStatus
+		if err := client.call("status", nil, &status); err == nil {
+			bundle.addJSON("status.json", status)
+		}
+		var entries []appEvent
+		if err := client.call("history.query", historyQuery{Limit: diagnoseHistoryLimit}, &entries); err == nil {
+			bundle.addJSON("history.json", entries)
+		}
+		client.Close()
+	} else if path, err := historyFilePath(); err == nil {
+		// Not running: read the persisted history, if any
+		offline := newEventHistory(diagnoseHistoryLimit)
+		offline.mu.Lock()
+		_, err := offline.restoreLocked(path)
+		offline.mu.Unlock()
+		if err == nil {
+			bundle.addJSON("history.json", offline.query(historyQuery{}))
+		}
+	}
+
+	// Version and build information
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Module = bi.Main.Path
+		info.Version = bi.Main.Version
+		info.Settings = make(map[string]string)
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified", "CGO_ENABLED", "GOARCH", "GOOS", "-tags":
+				info.Settings[s.Key] = s.Value
+			}
+		}
+	}
+	bundle.addJSON("info.json", info)
+
+	// Recent log lines
+	if path, err := logFilePath(); err == nil {
+		if lines, _, err := tailLines(path, logLines); err == nil {
+			bundle.add("log.txt", []byte(strings.Join(lines, "\n")+"\n"))
+		} else {
+			bundle.add("log.txt", []byte(fmt.Sprintf("error: %v\n", err)))
+		}
+	}
+
+	return bundle, nil
+}
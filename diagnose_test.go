@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestDiagnoseRedactor(t *testing.T) {
+	r := buildDiagnoseRedactor([]string{"s3cr3t-token", "scrape-token"}, "/home/mic", []string{"Mic User", "mic"})
+	r.keep("USB mic", "BuiltInMicrophoneDevice", "mic")
+
+	tests := []struct {
+		in, want string
+	}{
+		{"Built-in Microphone", "Built-in Microphone"},
+		{`"name": "USB mic"`, `"name": "USB mic"`},
+		{`Set audio level device="USB mic" user=mic`, `Set audio level device="USB mic" user=<user>`},
+		{"opened /home/mic/.config/MicMaxer2/config.json", "opened ~/.config/MicMaxer2/config.json"},
+		{"/home/mic", "~"},
+		{"/home/mic2/notes", "/home/mic2/notes"},
+		{"/home/michael/notes", "/home/michael/notes"},
+		{"logged in as mic.", "logged in as <user>."},
+		{"full name: Mic User", "full name: <user>"},
+		{"mic-array and micmic", "mic-array and micmic"},
+		{"Authorization: Bearer s3cr3t-token", "Authorization: Bearer <redacted-token>"},
+		{`"token": "scrape-token"`, `"token": "<redacted-token>"`},
+	}
+	for _, tt := range tests {
+		if got := r.Replace(tt.in); got != tt.want {
+			t.Errorf("Replace(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// eventType identifies the kind of change described by an appEvent
+type eventType string
+
+const (
+	eventVolumeChanged      eventType = "volume_changed"
+	eventVolumeCorrected    eventType = "volume_corrected"
+	eventDeviceChecked      eventType = "device_checked"
+	eventDeviceUnchecked    eventType = "device_unchecked"
+	eventTargetChanged      eventType = "target_changed"
+	eventEnforcementPaused  eventType = "enforcement_paused"
+	eventEnforcementResumed eventType = "enforcement_resumed"
+	eventDeviceAdded        eventType = "device_added"
+	eventDeviceRemoved      eventType = "device_removed"
+	eventConflictDetected   eventType = "conflict_detected"
+	eventInstanceActivated  eventType = "instance_activated"
+	eventSilenceDetected    eventType = "silence_detected"
+	eventClippingDetected   eventType = "clipping_detected"
+	eventEnforcementFailed  eventType = "enforcement_failed"
+	eventPushToTalkHeld     eventType = "push_to_talk_held"
+	eventPushToTalkReleased eventType = "push_to_talk_released"
+	eventScheduleStarted    eventType = "schedule_started"
+	eventScheduleEnded      eventType = "schedule_ended"
+)
+
+// Origins of an event: what observed or caused the change
+const (
+	sourceListener = "listener"
+	sourceEnforcer = "enforcer"
+	sourceUser     = "user"
+	sourceScan     = "scan"
+	sourceAGC      = "agc"
+	sourceTimer    = "timer"
+	sourceSchedule = "schedule"
+)
+
+// appEvent describes a change observed or made by MicMaxer
+type appEvent struct {
+	Time       time.Time  `json:"time"`
+	Type       eventType  `json:"type"`
+	DeviceID   string     `json:"device_id,omitempty"`
+	DeviceName string     `json:"device_name,omitempty"`
+	Volume     int        `json:"volume"`
+	Previous   *int       `json:"previous,omitempty"` // volume or target before the change
+	Muted      bool       `json:"muted"`              // for push-to-talk, whether enforced devices are now silenced
+	Target     int        `json:"target,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	App        string     `json:"app,omitempty"` // application that likely changed the volume
+	Count      int        `json:"count,omitempty"`
+	Level      *float64   `json:"level_dbfs,omitempty"` // signal peak for silence and clipping alerts
+	Until      *time.Time `json:"until,omitempty"`      // end of a timed pause, or the next schedule change
+	Error      string     `json:"error,omitempty"`      // why enforcement failed
+}
+
+// eventBus fans out events to any number of subscribers
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan appEvent]struct{}
+}
+
+// Global event bus instance
+var events = &eventBus{
+	subs: make(map[chan appEvent]struct{}),
+}
+
+// subscribe returns a channel receiving published events and a function that
+// unsubscribes and closes it. Events are dropped for subscribers whose buffer
+// is full so a slow consumer never blocks the audio callback.
+func (b *eventBus) subscribe(buffer int) (<-chan appEvent, func()) {
+	ch := make(chan appEvent, buffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish records an event in the history and delivers it to all
+// subscribers without blocking
+func (b *eventBus) publish(ev appEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ev = history.record(ev)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -5,6 +5,7 @@ go 1.24.3
 require (
 	github.com/gen2brain/malgo v0.11.23
 	github.com/getlantern/systray v1.2.2
+	github.com/godbus/dbus/v5 v5.1.0
 )
 
 require (
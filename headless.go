@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runHeadless runs the scanner, volume change listener and periodic enforcer
+// without the menu bar icon, for use on servers, in containers or over SSH.
+// It blocks until SIGINT or SIGTERM is received and returns the exit code.
+func runHeadless(opts runOptions) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Starting MicMaxer in headless mode")
+	startCore(ctx, opts)
+
+	<-ctx.Done()
+
+	// Restore default signal handling so a second signal terminates immediately
+	stop()
+	slog.Info("Received shutdown signal - stopping MicMaxer")
+
+	stopCore()
+	releaseInstanceLock()
+
+	slog.Info("MicMaxer exited")
+	closeEventHistory()
+	closeLogFile()
+	return 0
+}
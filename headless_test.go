@@ -0,0 +1,102 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// coreRunning reports which parts of the core are running
+func coreRunning() (enforcer, controlAPI, httpAPI, metricsAPI bool) {
+	state.mu.RLock()
+	enforcer = state.enforcerCancel != nil
+	state.mu.RUnlock()
+	controlMu.Lock()
+	controlAPI = control != nil
+	controlMu.Unlock()
+	restAPIMu.Lock()
+	httpAPI = restAPI != nil
+	restAPIMu.Unlock()
+	metricsServerMu.Lock()
+	metricsAPI = metricsServer != nil
+	metricsServerMu.Unlock()
+	return
+}
+
+func TestRunHeadlessStopsOnSignal(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	useTestState(t)
+	savedHistory := history
+	history = newEventHistory(defaultHistorySize)
+	t.Cleanup(func() { history = savedHistory })
+
+	// The listener is faked, as it needs Core Audio
+	listening := make(chan bool, 2)
+	savedStart, savedStop := startListener, stopListener
+	startListener = func() error { listening <- true; return nil }
+	stopListener = func() error { listening <- false; return nil }
+	t.Cleanup(func() { startListener, stopListener = savedStart, savedStop })
+	before := subscribers()
+
+	done := make(chan int, 1)
+	go func() {
+		done <- runHeadless(runOptions{headless: true, httpAddr: "127.0.0.1:0", metricsAddr: "127.0.0.1:0"})
+	}()
+
+	// The enforcer starts last
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if enforcer, _, _, _ := coreRunning(); enforcer {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the periodic enforcer did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if enforcer, controlAPI, httpAPI, metricsAPI := coreRunning(); !enforcer || !controlAPI || !httpAPI || !metricsAPI {
+		t.Errorf("running: enforcer %v, control API %v, HTTP API %v, metrics %v; want all", enforcer, controlAPI, httpAPI, metricsAPI)
+	}
+	if on := <-listening; !on {
+		t.Error("the listener was stopped before it was started")
+	}
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-done:
+		if code != 0 {
+			t.Errorf("runHeadless() = %d, want 0", code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("runHeadless did not return after SIGINT")
+	}
+
+	if enforcer, controlAPI, httpAPI, metricsAPI := coreRunning(); enforcer || controlAPI || httpAPI || metricsAPI {
+		t.Errorf("still running: enforcer %v, control API %v, HTTP API %v, metrics %v", enforcer, controlAPI, httpAPI, metricsAPI)
+	}
+	select {
+	case on := <-listening:
+		if on {
+			t.Error("the listener was started again")
+		}
+	default:
+		t.Error("the listener was not stopped")
+	}
+	if _, err := dialControl(); err == nil {
+		t.Error("the control socket still accepts connections")
+	}
+	if got := subscribers(); got != before {
+		t.Errorf("%d event subscribers after shutdown, want %d", got, before)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// week is the Monday of a week without daylight saving changes
+var week = time.Date(2026, time.January, 5, 0, 0, 0, 0, time.UTC)
+
+// testToken is the API token used by HTTP tests
+const testToken = "0123456789abcdef0123456789abcdef"
+
+// useTestState replaces the global audio state with an empty one
+func useTestState(t *testing.T) *audioState {
+	t.Helper()
+	saved := state
+	state = &audioState{
+		deviceStates:  make(map[string]bool),
+		deviceTargets: make(map[string]float32),
+		devicePauses:  make(map[string]time.Time),
+		mutePolicies:  make(map[string]string),
+		scheduled:     make(map[string]scheduleState),
+	}
+	t.Cleanup(func() { state = saved })
+	return state
+}
+
+// subscribers returns the number of event bus subscriptions
+func subscribers() int {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	return len(events.subs)
+}
+
+// startTestControlServer serves the control API on a socket in a temporary
+// runtime directory and connects a client to it
+func startTestControlServer(t *testing.T) *rpcClient {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	if err := startControlServer(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stopControlServer)
+
+	client, err := dialControl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return client
+}
+
+// useTestPushToTalk replaces the global push-to-talk controller with one
+// driven by a fake clock and keeps config changes in a temporary directory
+func useTestPushToTalk(t *testing.T, settings pushToTalkConfig) (*pushToTalkController, *fakeClock) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	clock := newFakeClock(week)
+	saved := ptt
+	ptt = &pushToTalkController{clock: clock, settings: settings, holds: make(map[string]stopper)}
+	t.Cleanup(func() {
+		ptt.mu.Lock()
+		ptt.resetLocked()
+		ptt.mu.Unlock()
+		ptt = saved
+	})
+	return ptt, clock
+}
+
+// fakeClock is a timerClock that only moves when advanced, running the
+// functions that fall due on the way in order
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer is a function waiting on a fakeClock
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	f     func()
+}
+
+func newFakeClock(now time.Time) *fakeClock { return &fakeClock{now: now} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) stopper {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, pending := range t.clock.timers {
+		if pending == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// advance moves the clock forward by d, running due functions without
+// holding the clock's lock so they can start or stop timers
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		index := -1
+		for i, t := range c.timers {
+			if !t.at.After(end) && (next == nil || t.at.Before(next.at)) {
+				next, index = t, i
+			}
+		}
+		if next == nil {
+			break
+		}
+		c.timers = append(c.timers[:index], c.timers[index+1:]...)
+		c.now = next.at
+		c.mu.Unlock()
+		next.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
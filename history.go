@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the event history
+const (
+	defaultHistorySize = 1000
+	historyFileName    = "history.jsonl"
+	historyQueueSize   = 256 // events waiting to be written to the file
+)
+
+// historyConfig holds the settings for the event history
+type historyConfig struct {
+	Size    int  `json:"size,omitempty"`    // events kept, defaults to 1000
+	Persist bool `json:"persist,omitempty"` // keep the history across restarts
+}
+
+// historyQuery filters the event history; zero values match everything
+type historyQuery struct {
+	Since  *time.Time `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+	Device string     `json:"device,omitempty"` // ID, alias or part of the name
+	Limit  int        `json:"limit,omitempty"`  // most recent events only
+}
+
+// eventHistory keeps the most recent events in a ring buffer, optionally
+// appending them to a file so the timeline survives restarts
+type eventHistory struct {
+	mu      sync.Mutex
+	events  []appEvent
+	next    int // index of the oldest entry once the buffer is full
+	full    bool
+	volumes map[string]int // last known volume per device, for Previous
+
+	seq    uint64            // number of events recorded so far
+	writes chan historyWrite // events for the writer, nil unless persisting
+	done   chan struct{}     // closed when the writer has finished
+}
+
+// historyWrite is an event queued for the history file
+type historyWrite struct {
+	seq uint64
+	ev  appEvent
+}
+
+// historyWriter appends events to the history file; it is owned by the
+// writer goroutine so file I/O never happens under the history lock
+type historyWriter struct {
+	file     *os.File
+	path     string
+	size     int    // events kept in the ring buffer
+	appended int    // lines written since the file was last compacted
+	written  uint64 // sequence number of the last event in the file
+}
+
+// Global event history, recording everything published on the event bus
+var history = newEventHistory(defaultHistorySize)
+
+// newEventHistory creates an in-memory history holding size events
+func newEventHistory(size int) *eventHistory {
+	return &eventHistory{
+		events:  make([]appEvent, 0, size),
+		volumes: make(map[string]int),
+	}
+}
+
+// record adds an event to the history, filling in the previous volume of
+// volume changes, and returns the completed event
+func (h *eventHistory) record(ev appEvent) appEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch ev.Type {
+	case eventVolumeChanged:
+		if prev, ok := h.volumes[ev.DeviceID]; ok && ev.Previous == nil {
+			ev.Previous = &prev
+		}
+		h.volumes[ev.DeviceID] = ev.Volume
+	case eventVolumeCorrected:
+		h.volumes[ev.DeviceID] = ev.Target
+	case eventInstanceActivated:
+		return ev // not part of the device timeline
+	}
+
+	h.appendLocked(ev)
+	h.persistLocked(ev)
+	return ev
+}
+
+// persistLocked queues an event for the history file without blocking; the
+// caller must hold h.mu
+func (h *eventHistory) persistLocked(ev appEvent) {
+	h.seq++
+	if h.writes == nil {
+		return
+	}
+	select {
+	case h.writes <- historyWrite{seq: h.seq, ev: ev}:
+	default:
+		// The next compaction writes whatever is dropped from the buffer
+		slog.Warn("Dropped event from history file, writer is behind")
+	}
+}
+
+// appendLocked adds an event to the ring buffer; the caller must hold h.mu
+func (h *eventHistory) appendLocked(ev appEvent) {
+	if !h.full {
+		h.events = append(h.events, ev)
+		if len(h.events) == cap(h.events) {
+			h.full = true
+		}
+		return
+	}
+	h.events[h.next] = ev
+	h.next = (h.next + 1) % len(h.events)
+}
+
+// snapshotLocked returns the events from oldest to newest; the caller must hold h.mu
+func (h *eventHistory) snapshotLocked() []appEvent {
+	out := make([]appEvent, 0, len(h.events))
+	out = append(out, h.events[h.next:]...)
+	return append(out, h.events[:h.next]...)
+}
+
+// query returns the events matching q from oldest to newest
+func (h *eventHistory) query(q historyQuery) []appEvent {
+	var deviceID string
+	if q.Device != "" {
+		if device, err := resolveDevice(knownDevices(), q.Device); err == nil {
+			deviceID = device.ID
+		}
+	}
+
+	h.mu.Lock()
+	all := h.snapshotLocked()
+	h.mu.Unlock()
+
+	matched := []appEvent{}
+	for _, ev := range all {
+		if q.Since != nil && ev.Time.Before(*q.Since) {
+			continue
+		}
+		if q.Until != nil && ev.Time.After(*q.Until) {
+			continue
+		}
+		if q.Device != "" && !historyDeviceMatches(ev, q.Device, deviceID) {
+			continue
+		}
+		matched = append(matched, ev)
+	}
+
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[len(matched)-q.Limit:]
+	}
+	return matched
+}
+
+// historyDeviceMatches reports whether an event concerns the selected device,
+// falling back to the name for devices that are no longer connected
+func historyDeviceMatches(ev appEvent, selector, resolvedID string) bool {
+	if resolvedID != "" {
+		return ev.DeviceID == resolvedID
+	}
+	return ev.DeviceID == selector ||
+		(ev.DeviceName != "" && strings.Contains(strings.ToLower(ev.DeviceName), strings.ToLower(selector)))
+}
+
+// historyFilePath returns the location of the persisted history
+func historyFilePath() (string, error) {
+	dir, err := logDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, historyFileName), nil
+}
+
+// loadEventHistory sizes the history from the config file and, if
+// persistence is enabled, restores it from disk and starts appending to it
+func loadEventHistory() {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+	}
+	hc := cfg.History
+	if hc == nil {
+		hc = &historyConfig{}
+	}
+
+	size := defaultHistorySize
+	if hc.Size > 0 {
+		size = hc.Size
+	}
+
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	// Keep events published before the history was configured
+	early := history.snapshotLocked()
+	history.events = make([]appEvent, 0, size)
+	history.next = 0
+	history.full = false
+
+	var w *historyWriter
+	if hc.Persist {
+		if w, err = history.openFileLocked(); err != nil {
+			slog.Warn("Event history will not be persisted", "error", err)
+		}
+	}
+	if w != nil {
+		history.startWriterLocked(w)
+	}
+	for _, ev := range early {
+		history.appendLocked(ev)
+		history.persistLocked(ev)
+	}
+}
+
+// openFileLocked restores persisted events and opens the history file for
+// appending; the caller must hold h.mu
+func (h *eventHistory) openFileLocked() (*historyWriter, error) {
+	path, err := historyFilePath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	return h.openPathLocked(path)
+}
+
+// openPathLocked restores the events persisted at path and opens it for
+// appending; the caller must hold h.mu
+func (h *eventHistory) openPathLocked(path string) (*historyWriter, error) {
+	appended, _ := h.restoreLocked(path)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	return &historyWriter{
+		file:     f,
+		path:     path,
+		size:     cap(h.events),
+		appended: appended,
+		written:  h.seq,
+	}, nil
+}
+
+// restoreLocked adds the events persisted at path to the ring buffer and
+// returns how many were read; the caller must hold h.mu
+func (h *eventHistory) restoreLocked(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev appEvent
+		if json.Unmarshal(scanner.Bytes(), &ev) == nil {
+			h.appendLocked(ev)
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
+
+// startWriterLocked starts the goroutine appending recorded events to the
+// history file; the caller must hold h.mu
+func (h *eventHistory) startWriterLocked(w *historyWriter) {
+	h.writes = make(chan historyWrite, historyQueueSize)
+	h.done = make(chan struct{})
+	go h.runWriter(w, h.writes, h.done)
+}
+
+// runWriter appends queued events to the history file until the queue is
+// closed, rewriting the file with only the buffered events once it holds
+// twice as many lines
+func (h *eventHistory) runWriter(w *historyWriter, writes <-chan historyWrite, done chan<- struct{}) {
+	defer close(done)
+	defer func() { w.file.Close() }() // compaction replaces w.file
+
+	for wr := range writes {
+		if wr.seq <= w.written {
+			continue // already in the file from the last compaction
+		}
+		data, err := json.Marshal(wr.ev)
+		if err != nil {
+			continue
+		}
+		if _, err := w.file.Write(append(data, '\n')); err != nil {
+			slog.Warn("Failed to persist event history", "error", err)
+			continue
+		}
+		w.written = wr.seq
+		w.appended++
+
+		if w.appended >= 2*w.size {
+			if err := w.compact(h); err != nil {
+				slog.Warn("Failed to compact event history", "error", err)
+			}
+		}
+	}
+}
+
+// compact rewrites the history file from the ring buffer, keeping the
+// current file if anything fails
+func (w *historyWriter) compact(h *eventHistory) error {
+	h.mu.Lock()
+	events := h.snapshotLocked()
+	seq := h.seq
+	h.mu.Unlock()
+
+	tmp := w.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return fail(err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return fail(err)
+	}
+
+	w.file.Close()
+	w.file = f
+	w.appended = len(events)
+	w.written = seq
+	return nil
+}
+
+// close stops the writer after it has written the queued events
+func (h *eventHistory) close() {
+	h.mu.Lock()
+	writes, done := h.writes, h.done
+	h.writes = nil
+	h.mu.Unlock()
+
+	if writes != nil {
+		close(writes)
+		<-done
+	}
+}
+
+// closeEventHistory flushes and closes the history file on exit
+func closeEventHistory() {
+	history.close()
+}
+
+// parseHistoryTime parses a time filter given as a duration before now
+// ("2h"), a time of day today ("14:00") or an RFC 3339 timestamp
+func parseHistoryTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected a duration such as 2h, a time such as 14:00 or an RFC 3339 timestamp", s)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTestHistory opens a history of the given size persisted at path
+func startTestHistory(t *testing.T, size int, path string) *eventHistory {
+	t.Helper()
+	h := newEventHistory(size)
+	h.mu.Lock()
+	w, err := h.openPathLocked(path)
+	if err == nil {
+		h.startWriterLocked(w)
+	}
+	h.mu.Unlock()
+	if err != nil {
+		t.Fatalf("openPathLocked: %v", err)
+	}
+	t.Cleanup(h.close)
+	return h
+}
+
+// readHistoryFile returns the volumes of the events in a history file
+func readHistoryFile(t *testing.T, path string) []int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var volumes []int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev appEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		volumes = append(volumes, ev.Volume)
+	}
+	return volumes
+}
+
+func TestHistoryPersistAndCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), historyFileName)
+	h := startTestHistory(t, 3, path)
+
+	start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	for i := 1; i <= 10; i++ {
+		h.record(appEvent{Type: eventVolumeChanged, DeviceID: "mic", Volume: i, Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	h.close()
+
+	// The writer may lag behind, so the compacted file starts anywhere up to
+	// the last three events, but it never repeats or reorders them
+	got := readHistoryFile(t, path)
+	if len(got) < 3 || len(got) >= 6 {
+		t.Fatalf("file holds %v, want it compacted to 3..5 events", got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] != got[i-1]+1 {
+			t.Fatalf("file holds %v, want consecutive events", got)
+		}
+	}
+	if got[len(got)-1] != 10 {
+		t.Fatalf("file holds %v, want it to end with 10", got)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	// A restart restores the most recent events and keeps appending
+	h = startTestHistory(t, 3, path)
+	restored := h.query(historyQuery{})
+	if len(restored) != 3 || restored[0].Volume != 8 || restored[2].Volume != 10 {
+		t.Fatalf("restored %+v, want volumes 8..10", restored)
+	}
+	h.record(appEvent{Type: eventVolumeChanged, DeviceID: "mic", Volume: 11, Time: start.Add(time.Minute)})
+	h.close()
+	if got := readHistoryFile(t, path); len(got) == 0 || got[len(got)-1] != 11 {
+		t.Errorf("file holds %v, want it to end with 11", got)
+	}
+}
+
+func TestHistoryCompactKeepsFileOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, historyFileName)
+	h := newEventHistory(2)
+	h.mu.Lock()
+	w, err := h.openPathLocked(path)
+	h.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.file.Close()
+
+	// A directory in place of the temporary file makes compaction fail
+	if err := os.Mkdir(path+".tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := w.file
+	if err := w.compact(h); err == nil {
+		t.Fatal("compact succeeded, want an error")
+	}
+	if w.file != file {
+		t.Error("compact replaced the history file after failing")
+	}
+	if _, err := w.file.WriteString("{}\n"); err != nil {
+		t.Errorf("history file is no longer writable: %v", err)
+	}
+}
+
+func TestHistoryPreviousVolume(t *testing.T) {
+	h := newEventHistory(10)
+	h.record(appEvent{Type: eventVolumeChanged, DeviceID: "mic", Volume: 80})
+	ev := h.record(appEvent{Type: eventVolumeChanged, DeviceID: "mic", Volume: 40})
+	if ev.Previous == nil || *ev.Previous != 80 {
+		t.Fatalf("Previous = %v, want 80", ev.Previous)
+	}
+	if got := h.query(historyQuery{Device: "mic"}); len(got) != 2 {
+		t.Errorf("query returned %d events, want 2", len(got))
+	}
+}
@@ -0,0 +1,205 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+/*
+#cgo LDFLAGS: -framework Carbon
+#include <Carbon/Carbon.h>
+#include <dispatch/dispatch.h>
+#include <stdlib.h>
+
+// Forward declarations of Go callbacks
+extern void goHotkeyEvent(int id, int pressed);
+extern void goHotkeyRegistered(int id, int status);
+
+#define MAX_HOTKEYS 32
+
+static EventHandlerRef hotkeyHandler = NULL;
+static EventHotKeyRef hotkeyRefs[MAX_HOTKEYS];
+static int hotkeyCount = 0;
+
+// Deliver hotkey presses and releases to Go
+static OSStatus hotkeyEventHandler(EventHandlerCallRef next, EventRef event, void *data) {
+    EventHotKeyID hotkeyID;
+    OSStatus status = GetEventParameter(event, kEventParamDirectObject, typeEventHotKeyID,
+                                        NULL, sizeof(hotkeyID), NULL, &hotkeyID);
+    if (status != noErr) {
+        return eventNotHandledErr;
+    }
+    goHotkeyEvent((int)hotkeyID.id, GetEventKind(event) == kEventHotKeyPressed);
+    return noErr;
+}
+
+// Convert the modifier bits shared with Go (shift, ctrl, alt, super) to Carbon modifiers
+static UInt32 carbonModifiers(int modifiers) {
+    UInt32 result = 0;
+    if (modifiers & 1) result |= shiftKey;
+    if (modifiers & 2) result |= controlKey;
+    if (modifiers & 4) result |= optionKey;
+    if (modifiers & 8) result |= cmdKey;
+    return result;
+}
+
+typedef struct {
+    int count;
+    int *keyCodes;
+    int *modifiers;
+} hotkeyRequest;
+
+// Register hotkeys on the main thread, which runs the application event loop
+static void registerHotkeysOnMain(void *context) {
+    hotkeyRequest *request = (hotkeyRequest *)context;
+
+    if (hotkeyHandler == NULL) {
+        EventTypeSpec types[] = {
+            {kEventClassKeyboard, kEventHotKeyPressed},
+            {kEventClassKeyboard, kEventHotKeyReleased},
+        };
+        InstallApplicationEventHandler(&hotkeyEventHandler, 2, types, NULL, &hotkeyHandler);
+    }
+
+    for (int i = 0; i < request->count && hotkeyCount < MAX_HOTKEYS; i++) {
+        EventHotKeyID hotkeyID = {'MMXR', (UInt32)i};
+        EventHotKeyRef ref = NULL;
+        OSStatus status = RegisterEventHotKey(request->keyCodes[i], carbonModifiers(request->modifiers[i]),
+                                              hotkeyID, GetApplicationEventTarget(), 0, &ref);
+        if (status == noErr) {
+            hotkeyRefs[hotkeyCount++] = ref;
+        }
+        goHotkeyRegistered(i, (int)status);
+    }
+
+    free(request->keyCodes);
+    free(request->modifiers);
+    free(request);
+}
+
+// Unregister all hotkeys on the main thread
+static void unregisterHotkeysOnMain(void *context) {
+    for (int i = 0; i < hotkeyCount; i++) {
+        UnregisterEventHotKey(hotkeyRefs[i]);
+    }
+    hotkeyCount = 0;
+}
+
+// Queue the registration of hotkeys; the arrays are copied
+static void registerHotkeys(int count, int *keyCodes, int *modifiers) {
+    hotkeyRequest *request = malloc(sizeof(hotkeyRequest));
+    request->count = count;
+    request->keyCodes = malloc(count * sizeof(int));
+    request->modifiers = malloc(count * sizeof(int));
+    for (int i = 0; i < count; i++) {
+        request->keyCodes[i] = keyCodes[i];
+        request->modifiers[i] = modifiers[i];
+    }
+    dispatch_async_f(dispatch_get_main_queue(), request, registerHotkeysOnMain);
+}
+
+// Queue the removal of all hotkeys
+static void unregisterHotkeys(void) {
+    dispatch_async_f(dispatch_get_main_queue(), NULL, unregisterHotkeysOnMain);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// carbonKeyCodes maps hotkey key names to macOS virtual key codes (kVK_*)
+var carbonKeyCodes = map[string]int{
+	"a": 0x00, "s": 0x01, "d": 0x02, "f": 0x03, "h": 0x04, "g": 0x05, "z": 0x06, "x": 0x07,
+	"c": 0x08, "v": 0x09, "b": 0x0B, "q": 0x0C, "w": 0x0D, "e": 0x0E, "r": 0x0F, "y": 0x10,
+	"t": 0x11, "1": 0x12, "2": 0x13, "3": 0x14, "4": 0x15, "6": 0x16, "5": 0x17, "9": 0x19,
+	"7": 0x1A, "8": 0x1C, "0": 0x1D, "o": 0x1F, "u": 0x20, "i": 0x22, "p": 0x23, "l": 0x25,
+	"j": 0x26, "k": 0x28, "n": 0x2D, "m": 0x2E,
+	"return": 0x24, "tab": 0x30, "space": 0x31, "backspace": 0x33, "escape": 0x35,
+	"delete": 0x75, "home": 0x73, "end": 0x77, "pageup": 0x74, "pagedown": 0x79,
+	"left": 0x7B, "right": 0x7C, "down": 0x7D, "up": 0x7E,
+	"f1": 0x7A, "f2": 0x78, "f3": 0x63, "f4": 0x76, "f5": 0x60, "f6": 0x61, "f7": 0x62,
+	"f8": 0x64, "f9": 0x65, "f10": 0x6D, "f11": 0x67, "f12": 0x6F, "f13": 0x69, "f14": 0x6B,
+	"f15": 0x71, "f16": 0x6A, "f17": 0x40, "f18": 0x4F, "f19": 0x50, "f20": 0x5A,
+}
+
+// carbonHotkeys holds the hotkeys registered with Carbon and the function
+// receiving their events
+var carbonHotkeys struct {
+	mu         sync.Mutex
+	keys       []hotkey
+	fn         func(i int, pressed bool)
+	generation int // counts listen calls so a stale cancellation is ignored
+}
+
+//export goHotkeyEvent
+func goHotkeyEvent(id C.int, pressed C.int) {
+	carbonHotkeys.mu.Lock()
+	fn := carbonHotkeys.fn
+	carbonHotkeys.mu.Unlock()
+
+	if fn != nil {
+		fn(int(id), pressed != 0)
+	}
+}
+
+//export goHotkeyRegistered
+func goHotkeyRegistered(id C.int, status C.int) {
+	if status == 0 {
+		return
+	}
+	carbonHotkeys.mu.Lock()
+	defer carbonHotkeys.mu.Unlock()
+	if int(id) < len(carbonHotkeys.keys) {
+		slog.Warn("Failed to register hotkey", "hotkey", carbonHotkeys.keys[int(id)].String(), "status", int(status))
+	}
+}
+
+// carbonHotkeyBackend registers hotkeys with the Carbon event manager, which
+// needs no accessibility permission. Events are delivered by the application
+// event loop, so hotkeys only work while the menu bar app is running.
+type carbonHotkeyBackend struct{}
+
+// newHotkeyBackend returns the hotkey backend for this platform. Headless
+// mode runs no application event loop to deliver hotkey events, so hotkeys
+// are left out there.
+func newHotkeyBackend(headless bool) hotkeyBackend {
+	if headless {
+		return unavailableHotkeyBackend{errors.New("global hotkeys need the menu bar app on macOS and are not available in headless mode")}
+	}
+	return carbonHotkeyBackend{}
+}
+
+func (carbonHotkeyBackend) listen(ctx context.Context, keys []hotkey, fn func(i int, pressed bool)) error {
+	keyCodes := make([]C.int, len(keys))
+	modifiers := make([]C.int, len(keys))
+	for i, key := range keys {
+		keyCodes[i] = C.int(carbonKeyCodes[key.key])
+		modifiers[i] = C.int(key.mods)
+	}
+
+	carbonHotkeys.mu.Lock()
+	carbonHotkeys.generation++
+	generation := carbonHotkeys.generation
+	carbonHotkeys.keys = keys
+	carbonHotkeys.fn = fn
+	carbonHotkeys.mu.Unlock()
+
+	// The main queue runs requests in order, so earlier hotkeys are gone
+	// before these are registered
+	C.unregisterHotkeys()
+	C.registerHotkeys(C.int(len(keys)), &keyCodes[0], &modifiers[0])
+
+	go func() {
+		<-ctx.Done()
+		carbonHotkeys.mu.Lock()
+		defer carbonHotkeys.mu.Unlock()
+		if carbonHotkeys.generation == generation {
+			carbonHotkeys.fn = nil
+			C.unregisterHotkeys()
+		}
+	}()
+	return nil
+}
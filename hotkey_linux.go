@@ -0,0 +1,215 @@
+//go:build linux
+// +build linux
+
+package main
+
+/*
+#cgo LDFLAGS: -lX11
+#include <stdlib.h>
+#include <sys/select.h>
+#include <X11/Xlib.h>
+#include <X11/XKBlib.h>
+
+// Error code of the last failed request while grabbing keys
+static int grabError = 0;
+
+static int recordGrabError(Display *display, XErrorEvent *event) {
+    grabError = event->error_code;
+    return 0;
+}
+
+// Grab a key with the given modifiers on the root window, also while Caps
+// Lock or Num Lock is on. Returns the key code, -1 for an unknown key or -2
+// if another client already grabbed the combination.
+static int grabHotkey(Display *display, const char *keysymName, unsigned int modifiers) {
+    KeySym keysym = XStringToKeysym(keysymName);
+    if (keysym == NoSymbol) {
+        return -1;
+    }
+    KeyCode keycode = XKeysymToKeycode(display, keysym);
+    if (keycode == 0) {
+        return -1;
+    }
+
+    unsigned int locks[] = {0, LockMask, Mod2Mask, LockMask | Mod2Mask};
+    XErrorHandler previous = XSetErrorHandler(recordGrabError);
+    grabError = 0;
+    for (int i = 0; i < 4; i++) {
+        XGrabKey(display, keycode, modifiers | locks[i], DefaultRootWindow(display),
+                 False, GrabModeAsync, GrabModeAsync);
+    }
+    XSync(display, False);
+    XSetErrorHandler(previous);
+
+    if (grabError != 0) {
+        for (int i = 0; i < 4; i++) {
+            XUngrabKey(display, keycode, modifiers | locks[i], DefaultRootWindow(display));
+        }
+        XSync(display, False);
+        return -2;
+    }
+    return keycode;
+}
+
+// Wait up to timeoutMs for a key event. Returns 1 with the event's key code,
+// modifiers and whether the key was pressed, 0 on timeout or -1 on error.
+static int nextKeyEvent(Display *display, int timeoutMs, unsigned int *keycode, unsigned int *modifiers, int *pressed) {
+    while (XPending(display) > 0) {
+        XEvent event;
+        XNextEvent(display, &event);
+        if (event.type == KeyPress || event.type == KeyRelease) {
+            *keycode = event.xkey.keycode;
+            *modifiers = event.xkey.state;
+            *pressed = event.type == KeyPress;
+            return 1;
+        }
+    }
+
+    int fd = ConnectionNumber(display);
+    fd_set fds;
+    FD_ZERO(&fds);
+    FD_SET(fd, &fds);
+    struct timeval timeout = {timeoutMs / 1000, (timeoutMs % 1000) * 1000};
+    if (select(fd + 1, &fds, NULL, NULL, &timeout) < 0) {
+        return -1;
+    }
+    return 0;
+}
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// x11PollInterval is how often the X11 event loop checks for cancellation
+const x11PollInterval = 200
+
+// x11KeysymNames maps hotkey key names to X11 keysym names where they differ
+var x11KeysymNames = map[string]string{
+	"tab":       "Tab",
+	"return":    "Return",
+	"escape":    "Escape",
+	"backspace": "BackSpace",
+	"delete":    "Delete",
+	"home":      "Home",
+	"end":       "End",
+	"pageup":    "Prior",
+	"pagedown":  "Next",
+	"up":        "Up",
+	"down":      "Down",
+	"left":      "Left",
+	"right":     "Right",
+}
+
+// x11Modifiers converts hotkey modifiers to an X11 modifier mask, taking Alt
+// as Mod1 and Super as Mod4
+func x11Modifiers(mods hotkeyModifier) C.uint {
+	var mask C.uint
+	if mods&modShift != 0 {
+		mask |= C.ShiftMask
+	}
+	if mods&modCtrl != 0 {
+		mask |= C.ControlMask
+	}
+	if mods&modAlt != 0 {
+		mask |= C.Mod1Mask
+	}
+	if mods&modSuper != 0 {
+		mask |= C.Mod4Mask
+	}
+	return mask
+}
+
+// x11KeysymName returns the X11 keysym name of a hotkey key
+func x11KeysymName(key string) string {
+	if name, ok := x11KeysymNames[key]; ok {
+		return name
+	}
+	if len(key) > 1 && key[0] == 'f' {
+		return strings.ToUpper(key) // F1-F20
+	}
+	return key
+}
+
+// x11HotkeyBackend grabs hotkeys on the root window of the X display named
+// by $DISPLAY, which also works under Xvfb
+type x11HotkeyBackend struct{}
+
+// newHotkeyBackend returns the hotkey backend for this platform, which is the
+// same in headless mode
+func newHotkeyBackend(headless bool) hotkeyBackend {
+	return x11HotkeyBackend{}
+}
+
+func (x11HotkeyBackend) listen(ctx context.Context, keys []hotkey, fn func(i int, pressed bool)) error {
+	ready := make(chan error, 1)
+	go func() {
+		// Xlib connections are not shared between threads
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		display := C.XOpenDisplay(nil)
+		if display == nil {
+			ready <- errors.New("cannot open X display; is DISPLAY set?")
+			return
+		}
+		defer C.XCloseDisplay(display)
+
+		// Report held keys as a single press rather than repeated press
+		// and release pairs
+		C.XkbSetDetectableAutoRepeat(display, C.True, nil)
+
+		keycodes := make([]C.int, len(keys))
+		for i, key := range keys {
+			name := C.CString(x11KeysymName(key.key))
+			keycodes[i] = C.grabHotkey(display, name, x11Modifiers(key.mods))
+			C.free(unsafe.Pointer(name))
+
+			switch keycodes[i] {
+			case -1:
+				slog.Warn("Failed to register hotkey", "hotkey", key.String(), "error", "key not on keyboard")
+			case -2:
+				slog.Warn("Failed to register hotkey", "hotkey", key.String(), "error", "already taken by another application")
+			}
+		}
+		ready <- nil
+
+		held := make(map[C.uint]int) // key code to the index of its pressed hotkey
+		relevant := x11Modifiers(modShift | modCtrl | modAlt | modSuper)
+		for ctx.Err() == nil {
+			var keycode, state C.uint
+			var pressed C.int
+			switch C.nextKeyEvent(display, x11PollInterval, &keycode, &state, &pressed) {
+			case -1:
+				slog.Error("Hotkey listener stopped", "error", "X connection failed")
+				return
+			case 0:
+				continue
+			}
+
+			// A release is matched by key alone, since the modifiers may
+			// have been let go first
+			if pressed == 0 {
+				if i, ok := held[keycode]; ok {
+					delete(held, keycode)
+					fn(i, false)
+				}
+				continue
+			}
+			for i, key := range keys {
+				if keycodes[i] == C.int(keycode) && x11Modifiers(key.mods) == state&relevant {
+					held[keycode] = i
+					fn(i, true)
+					break
+				}
+			}
+		}
+	}()
+	return <-ready
+}
@@ -0,0 +1,125 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestX11KeysymName(t *testing.T) {
+	tests := map[string]string{
+		"m":        "m",
+		"7":        "7",
+		"f5":       "F5",
+		"f20":      "F20",
+		"space":    "space",
+		"pagedown": "Next",
+		"escape":   "Escape",
+	}
+	for key, want := range tests {
+		if got := x11KeysymName(key); got != want {
+			t.Errorf("x11KeysymName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestX11Modifiers(t *testing.T) {
+	// ShiftMask, ControlMask, Mod1Mask (Alt) and Mod4Mask (Super)
+	if got := uint(x11Modifiers(modShift | modCtrl | modAlt | modSuper)); got != 1|4|8|64 {
+		t.Errorf("x11Modifiers(all) = %#x, want %#x", got, 1|4|8|64)
+	}
+	if got := uint(x11Modifiers(0)); got != 0 {
+		t.Errorf("x11Modifiers(none) = %#x, want 0", got)
+	}
+}
+
+func TestX11HotkeysWithoutDisplay(t *testing.T) {
+	t.Setenv("DISPLAY", "")
+	err := newHotkeyBackend(true).listen(context.Background(), []hotkey{{mods: modCtrl, key: "m"}}, func(int, bool) {})
+	if err == nil {
+		t.Error("listen succeeded without an X display")
+	}
+}
+
+// startXvfb starts a virtual X server for the test and points DISPLAY at it
+func startXvfb(t *testing.T) {
+	t.Helper()
+	for _, tool := range []string{"Xvfb", "xdotool"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+
+	cmd := exec.Command("Xvfb", "-displayfd", "1", "-nolisten", "tcp")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start Xvfb: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	display, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read the Xvfb display: %v", err)
+	}
+	t.Setenv("DISPLAY", ":"+strings.TrimSpace(display))
+}
+
+func TestX11HotkeysUnderXvfb(t *testing.T) {
+	startXvfb(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan hotkeyEvent, 16)
+	keys := []hotkey{{mods: modCtrl | modAlt, key: "m"}, {mods: modShift, key: "f5"}}
+	err := newHotkeyBackend(true).listen(ctx, keys, func(i int, pressed bool) {
+		received <- hotkeyEvent{i, pressed}
+	})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	press := func(combo string) {
+		t.Helper()
+		if out, err := exec.Command("xdotool", "keydown", combo, "keyup", combo).CombinedOutput(); err != nil {
+			t.Fatalf("xdotool %s: %v: %s", combo, err, out)
+		}
+	}
+	expect := func(want ...hotkeyEvent) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-received:
+				if got != w {
+					t.Fatalf("received %+v, want %+v", got, w)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no hotkey event, want %+v", w)
+			}
+		}
+	}
+
+	press("ctrl+alt+m")
+	expect(hotkeyEvent{0, true}, hotkeyEvent{0, false})
+	press("shift+F5")
+	expect(hotkeyEvent{1, true}, hotkeyEvent{1, false})
+
+	// Other modifiers do not match
+	press("ctrl+m")
+	select {
+	case got := <-received:
+		t.Errorf("ctrl+m fired %+v", got)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
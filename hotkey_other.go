@@ -0,0 +1,11 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import "errors"
+
+// newHotkeyBackend returns the hotkey backend for this platform
+func newHotkeyBackend(headless bool) hotkeyBackend {
+	return unavailableHotkeyBackend{errors.New("global hotkeys are only supported on macOS and Linux")}
+}
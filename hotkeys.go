@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Actions that can be bound to hotkeys in the config file
+const (
+	hotkeyToggleMute = "toggle_mute"  // mute or unmute the default input device
+	hotkeyPushToTalk = "push_to_talk" // hold push-to-talk, or unmute the default input device while held if it is off
+	hotkeyPause      = "pause"        // pause or resume enforcement of all devices
+)
+
+// hotkeyActions lists the actions hotkeys can be bound to
+var hotkeyActions = []string{hotkeyToggleMute, hotkeyPushToTalk, hotkeyPause}
+
+// hotkeyModifier is a set of modifier keys held with a hotkey
+type hotkeyModifier uint8
+
+// Modifier keys; the values are shared with the platform backends
+const (
+	modShift hotkeyModifier = 1 << iota
+	modCtrl
+	modAlt   // Option on macOS
+	modSuper // Command on macOS
+)
+
+// hotkeyModifierNames maps the modifier names accepted in the config file to
+// their keys
+var hotkeyModifierNames = map[string]hotkeyModifier{
+	"shift":   modShift,
+	"ctrl":    modCtrl,
+	"control": modCtrl,
+	"alt":     modAlt,
+	"option":  modAlt,
+	"super":   modSuper,
+	"cmd":     modSuper,
+	"command": modSuper,
+}
+
+// hotkeyNamedKeys lists the keys other than letters, digits and F1-F20 that
+// hotkeys may use
+var hotkeyNamedKeys = []string{
+	"space", "tab", "return", "escape", "backspace", "delete",
+	"home", "end", "pageup", "pagedown", "up", "down", "left", "right",
+}
+
+// hotkey is a key combination such as ctrl+alt+m
+type hotkey struct {
+	mods hotkeyModifier
+	key  string // lower-case key name
+}
+
+func (h hotkey) String() string {
+	var parts []string
+	for _, m := range []struct {
+		mod  hotkeyModifier
+		name string
+	}{{modCtrl, "ctrl"}, {modAlt, "alt"}, {modShift, "shift"}, {modSuper, "super"}} {
+		if h.mods&m.mod != 0 {
+			parts = append(parts, m.name)
+		}
+	}
+	return strings.Join(append(parts, h.key), "+")
+}
+
+// parseHotkey parses a key combination such as "ctrl+alt+m" or "cmd+shift+f5"
+func parseHotkey(s string) (hotkey, error) {
+	parts := strings.Split(strings.ToLower(strings.ReplaceAll(s, " ", "")), "+")
+	var h hotkey
+	for _, part := range parts[:len(parts)-1] {
+		mod, ok := hotkeyModifierNames[part]
+		if !ok {
+			return hotkey{}, fmt.Errorf("invalid hotkey %q: unknown modifier %q", s, part)
+		}
+		h.mods |= mod
+	}
+
+	h.key = parts[len(parts)-1]
+	if !validHotkeyKey(h.key) {
+		return hotkey{}, fmt.Errorf("invalid hotkey %q: unknown key %q", s, h.key)
+	}
+	return h, nil
+}
+
+// validHotkeyKey reports whether a key name can be used in a hotkey
+func validHotkeyKey(key string) bool {
+	if len(key) == 1 && (key[0] >= 'a' && key[0] <= 'z' || key[0] >= '0' && key[0] <= '9') {
+		return true
+	}
+	if n, ok := strings.CutPrefix(key, "f"); ok {
+		if i, err := strconv.Atoi(n); err == nil && i >= 1 && i <= 20 && strconv.Itoa(i) == n {
+			return true
+		}
+	}
+	for _, name := range hotkeyNamedKeys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hotkeyBackend grabs key combinations system-wide
+type hotkeyBackend interface {
+	// listen grabs keys and calls fn with a key's index whenever it is
+	// pressed or released, until ctx is cancelled. Keys that cannot be
+	// grabbed are logged and skipped.
+	listen(ctx context.Context, keys []hotkey, fn func(i int, pressed bool)) error
+}
+
+// unavailableHotkeyBackend reports why global hotkeys cannot be used
+type unavailableHotkeyBackend struct {
+	err error
+}
+
+func (b unavailableHotkeyBackend) listen(ctx context.Context, keys []hotkey, fn func(i int, pressed bool)) error {
+	return b.err
+}
+
+// hotkeyBinding is a hotkey and the action it runs
+type hotkeyBinding struct {
+	action string
+	key    hotkey
+}
+
+// hotkeyManager runs the actions bound to hotkeys, independently of the
+// platform backend reporting key presses
+type hotkeyManager struct {
+	mu       sync.Mutex
+	bindings []hotkeyBinding
+	held     []bool
+	cancel   context.CancelFunc
+}
+
+// Global hotkey manager instance
+var hotkeys = &hotkeyManager{}
+
+// loadHotkeyBindings parses the hotkeys configured for each action, skipping
+// invalid and duplicate ones
+func loadHotkeyBindings(config map[string]string) []hotkeyBinding {
+	actions := make([]string, 0, len(config))
+	for action := range config {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	var bindings []hotkeyBinding
+	bound := make(map[hotkey]string)
+	for _, action := range actions {
+		known := false
+		for _, a := range hotkeyActions {
+			known = known || a == action
+		}
+		if !known {
+			slog.Warn("Ignoring hotkey for unknown action", "action", action,
+				"actions", strings.Join(hotkeyActions, ", "))
+			continue
+		}
+
+		key, err := parseHotkey(config[action])
+		if err != nil {
+			slog.Warn("Ignoring hotkey", "action", action, "error", err)
+			continue
+		}
+		if other, ok := bound[key]; ok {
+			slog.Warn("Ignoring hotkey already bound to another action", "action", action, "hotkey", key.String(), "bound_to", other)
+			continue
+		}
+		bound[key] = action
+		bindings = append(bindings, hotkeyBinding{action: action, key: key})
+	}
+	return bindings
+}
+
+// start grabs the hotkeys from the config file with backend
+func (m *hotkeyManager) start(backend hotkeyBackend) {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+	}
+	bindings := loadHotkeyBindings(cfg.Hotkeys)
+	if len(bindings) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		return
+	}
+
+	keys := make([]hotkey, len(bindings))
+	for i, b := range bindings {
+		keys[i] = b.key
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := backend.listen(ctx, keys, m.dispatch); err != nil {
+		cancel()
+		slog.Warn("Hotkeys are not available", "error", err)
+		return
+	}
+	m.bindings = bindings
+	m.held = make([]bool, len(bindings))
+	m.cancel = cancel
+
+	for _, b := range bindings {
+		slog.Info("Registered hotkey", "action", b.action, "hotkey", b.key.String())
+	}
+}
+
+// stop releases the hotkeys
+func (m *hotkeyManager) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+		m.bindings = nil
+	}
+}
+
+// dispatch runs the action of a pressed or released hotkey, ignoring the
+// repeated presses of a key held down
+func (m *hotkeyManager) dispatch(i int, pressed bool) {
+	m.mu.Lock()
+	if i < 0 || i >= len(m.bindings) || m.held[i] == pressed {
+		m.mu.Unlock()
+		return
+	}
+	m.held[i] = pressed
+	binding := m.bindings[i]
+	m.mu.Unlock()
+
+	slog.Debug("Hotkey", "action", binding.action, "hotkey", binding.key.String(), "pressed", pressed)
+	runHotkeyAction(binding.action, pressed)
+}
+
+// runHotkeyAction carries out an action on a hotkey press or release
+func runHotkeyAction(action string, pressed bool) {
+	switch action {
+	case hotkeyToggleMute:
+		if pressed {
+			if muted, err := defaultInputMuted(); err == nil {
+				setDefaultInputMute(!muted)
+			} else {
+				slog.Error("Failed to read mute state", "error", err)
+			}
+		}
+	case hotkeyPushToTalk:
+		if !ptt.enabled() {
+			setDefaultInputMute(!pressed)
+		} else if pressed {
+			if err := ptt.hold(pttHolderHotkey, 0); err != nil {
+				slog.Error("Failed to hold push-to-talk", "error", err)
+			}
+		} else {
+			ptt.unhold(pttHolderHotkey)
+		}
+	case hotkeyPause:
+		if !pressed {
+			return
+		}
+		if paused, _ := pauseState(""); paused {
+			resumeEnforcement("")
+		} else {
+			pauseEnforcement("", 0)
+		}
+	}
+}
+
+// defaultInputMuted reports whether the default input device is muted
+func defaultInputMuted() (bool, error) {
+	deviceID, _ := defaultInputDevice()
+	if deviceID == "" {
+		return false, fmt.Errorf("no default input device")
+	}
+	return getSystemInputMute(deviceID)
+}
+
+// setDefaultInputMute mutes or unmutes the default input device
+func setDefaultInputMute(muted bool) {
+	deviceID, name := defaultInputDevice()
+	if deviceID == "" {
+		slog.Error("Failed to change mute state", "error", "no default input device")
+		return
+	}
+	if err := setSystemInputMute(deviceID, muted); err != nil {
+		slog.Error("Failed to change mute state", "device", name, "device_id", deviceID, "muted", muted, "error", err)
+		return
+	}
+	slog.Info("Changed mute state", "device", name, "device_id", deviceID, "muted", muted, "source", sourceUser)
+}
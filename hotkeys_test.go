@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// hotkeyEvent is a press or release reported by a backend
+type hotkeyEvent struct {
+	i       int
+	pressed bool
+}
+
+// fakeHotkeyBackend records the keys it is asked to grab and lets tests
+// press and release them
+type fakeHotkeyBackend struct {
+	keys []hotkey
+	fn   func(i int, pressed bool)
+	ctx  context.Context
+	err  error
+}
+
+func (b *fakeHotkeyBackend) listen(ctx context.Context, keys []hotkey, fn func(i int, pressed bool)) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.ctx, b.keys, b.fn = ctx, keys, fn
+	return nil
+}
+
+// useTestHotkeys replaces the global hotkey manager and writes the hotkeys
+// to a config file in a temporary directory
+func useTestHotkeys(t *testing.T, config map[string]string) *hotkeyManager {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if err := updateConfig(func(cfg *appConfig) { cfg.Hotkeys = config }); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := hotkeys
+	hotkeys = &hotkeyManager{}
+	t.Cleanup(func() {
+		hotkeys.stop()
+		hotkeys = saved
+	})
+	return hotkeys
+}
+
+func TestParseHotkey(t *testing.T) {
+	valid := map[string]hotkey{
+		"ctrl+alt+m":      {mods: modCtrl | modAlt, key: "m"},
+		"Cmd+Shift+F5":    {mods: modSuper | modShift, key: "f5"},
+		"control + space": {mods: modCtrl, key: "space"},
+		"option+7":        {mods: modAlt, key: "7"},
+		"f20":             {key: "f20"},
+	}
+	for s, want := range valid {
+		got, err := parseHotkey(s)
+		if err != nil || got != want {
+			t.Errorf("parseHotkey(%q) = %+v, %v, want %+v", s, got, err, want)
+		}
+	}
+	if got := (hotkey{mods: modSuper | modShift | modCtrl, key: "f5"}).String(); got != "ctrl+shift+super+f5" {
+		t.Errorf("String() = %q, want ctrl+shift+super+f5", got)
+	}
+
+	for _, s := range []string{"", "ctrl+", "hyper+m", "ctrl+mm", "ctrl+f21", "ctrl+f05", "ctrl+f0", "m+ctrl"} {
+		if got, err := parseHotkey(s); err == nil {
+			t.Errorf("parseHotkey(%q) = %+v, want an error", s, got)
+		}
+	}
+}
+
+func TestLoadHotkeyBindings(t *testing.T) {
+	got := loadHotkeyBindings(map[string]string{
+		hotkeyToggleMute: "ctrl+alt+m",
+		hotkeyPushToTalk: "alt+ctrl+m", // the same keys as toggle_mute
+		hotkeyPause:      "ctrl+alt+p",
+		"launch_rockets": "ctrl+alt+r",
+	})
+	// Actions are bound in name order, so pause and push_to_talk come
+	// before toggle_mute and the later duplicate is toggle_mute's
+	want := []hotkeyBinding{
+		{action: hotkeyPause, key: hotkey{mods: modCtrl | modAlt, key: "p"}},
+		{action: hotkeyPushToTalk, key: hotkey{mods: modCtrl | modAlt, key: "m"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadHotkeyBindings() = %+v, want %+v", got, want)
+	}
+
+	got = loadHotkeyBindings(map[string]string{hotkeyPause: "ctrl+alt+nope", hotkeyToggleMute: "f13"})
+	want = []hotkeyBinding{{action: hotkeyToggleMute, key: hotkey{key: "f13"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadHotkeyBindings(invalid) = %+v, want %+v", got, want)
+	}
+}
+
+func TestHotkeyManagerStart(t *testing.T) {
+	m := useTestHotkeys(t, map[string]string{hotkeyPause: "ctrl+alt+p", hotkeyToggleMute: "ctrl+alt+m"})
+
+	// A backend that cannot grab keys leaves the manager stopped
+	m.start(&fakeHotkeyBackend{err: errors.New("no display")})
+	if m.cancel != nil {
+		t.Fatal("manager started although the backend failed")
+	}
+
+	backend := &fakeHotkeyBackend{}
+	m.start(backend)
+	wantKeys := []hotkey{{mods: modCtrl | modAlt, key: "p"}, {mods: modCtrl | modAlt, key: "m"}}
+	if !reflect.DeepEqual(backend.keys, wantKeys) {
+		t.Fatalf("backend grabbed %+v, want %+v", backend.keys, wantKeys)
+	}
+
+	// Starting again keeps the running backend
+	again := &fakeHotkeyBackend{}
+	m.start(again)
+	if again.fn != nil {
+		t.Error("second start grabbed the keys again")
+	}
+
+	m.stop()
+	if backend.ctx.Err() == nil {
+		t.Error("stop did not release the backend's keys")
+	}
+	backend.fn(0, true) // late events after stop are ignored
+}
+
+func TestHotkeyDispatchIgnoresKeyRepeat(t *testing.T) {
+	st := useTestState(t)
+	m := useTestHotkeys(t, map[string]string{hotkeyPause: "ctrl+alt+p"})
+	backend := &fakeHotkeyBackend{}
+	m.start(backend)
+
+	ch, unsubscribe := events.subscribe(16)
+	defer unsubscribe()
+
+	// Auto-repeat delivers the press again while the key is held; only the
+	// first press toggles the pause
+	for i := 0; i < 4; i++ {
+		backend.fn(0, true)
+	}
+	backend.fn(0, false)
+	backend.fn(0, false)
+	toggles := 0
+	for len(ch) > 0 {
+		if ev := <-ch; ev.Type == eventEnforcementPaused || ev.Type == eventEnforcementResumed {
+			toggles++
+		}
+	}
+	if !st.paused || toggles != 1 {
+		t.Errorf("holding the pause key toggled the pause %d times, want once", toggles)
+	}
+
+	// Presses of keys that are not bound are ignored
+	backend.fn(1, true)
+	backend.fn(-1, true)
+}
+
+func TestHotkeyPushToTalk(t *testing.T) {
+	useTestState(t)
+	c, clock := useTestPushToTalk(t, pushToTalkConfig{Mode: pttModeTalk})
+	m := useTestHotkeys(t, map[string]string{hotkeyPushToTalk: "f13"})
+	backend := &fakeHotkeyBackend{}
+	m.start(backend)
+
+	backend.fn(0, true)
+	backend.fn(0, true) // repeat
+	if st := c.status(); !st.Held || st.Silenced {
+		t.Fatalf("status while the key is held = %+v, want held and live", st)
+	}
+
+	backend.fn(0, false)
+	clock.advance(defaultPTTReleaseTailMS * time.Millisecond)
+	if st := c.status(); st.Held || !st.Silenced {
+		t.Fatalf("status after the key is released = %+v, want released and silenced", st)
+	}
+
+	// The hotkey holds independently of an API hold
+	if err := c.hold(pttHolderAPI, 0); err != nil {
+		t.Fatal(err)
+	}
+	backend.fn(0, true)
+	backend.fn(0, false)
+	clock.advance(time.Second)
+	if st := c.status(); !st.Held {
+		t.Errorf("status after the key is released during an API hold = %+v, want held", st)
+	}
+}
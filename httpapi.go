@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// Timeouts for the HTTP API server
+const (
+	httpReadHeaderTimeout = 5 * time.Second
+	httpShutdownTimeout   = 5 * time.Second
+	sseKeepAliveInterval  = 30 * time.Second
+)
+
+// httpAPI serves the REST API and event stream on a loopback address
+type httpAPI struct {
+	server *http.Server
+	cancel context.CancelFunc
+}
+
+// Running HTTP API server, if any; a second launch can start it from an RPC
+// handler while the main goroutine starts or stops it
+var (
+	restAPIMu sync.Mutex
+	restAPI   *httpAPI
+)
+
+// startHTTPServer serves the REST API on addr, which must be a loopback
+// address, authenticating requests with the configured token. It does
+// nothing if the API is already being served.
+func startHTTPServer(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid HTTP listen address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("HTTP listen address %q must be a loopback IP such as 127.0.0.1", addr)
+	}
+
+	restAPIMu.Lock()
+	defer restAPIMu.Unlock()
+	if restAPI != nil {
+		return nil
+	}
+
+	token, err := httpToken()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	// Cancelling the base context ends open event streams on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{
+		Handler:           newHTTPHandler(token),
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	restAPI = &httpAPI{server: server, cancel: cancel}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP API server failed", "error", err)
+		}
+	}()
+
+	slog.Info("HTTP API listening", "addr", listener.Addr().String())
+	return nil
+}
+
+// stopHTTPServer shuts the HTTP API down, closing event streams
+func stopHTTPServer() {
+	restAPIMu.Lock()
+	defer restAPIMu.Unlock()
+	if restAPI == nil {
+		return
+	}
+
+	restAPI.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := restAPI.server.Shutdown(ctx); err != nil {
+		slog.Warn("Failed to shut down HTTP API cleanly", "error", err)
+	}
+	restAPI = nil
+}
+
+// httpToken returns the API token from the config file, generating and
+// saving a new one on first use
+func httpToken() (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	if cfg.HTTP != nil && cfg.HTTP.Token != "" {
+		return cfg.HTTP.Token, nil
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	err = updateConfig(func(cfg *appConfig) {
+		if cfg.HTTP == nil {
+			cfg.HTTP = &httpConfig{}
+		}
+		cfg.HTTP.Token = token
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save API token: %w", err)
+	}
+
+	path, _ := configPath()
+	slog.Info("Generated a new HTTP API token", "config", path)
+	return token, nil
+}
+
+// newHTTPHandler builds the REST API routes behind token authentication
+func newHTTPHandler(token string) http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/v1/status", handleStatus)
+	api.HandleFunc("GET /api/v1/devices", handleListDevices)
+	api.HandleFunc("GET /api/v1/devices/{device}", handleGetDevice)
+	api.HandleFunc("PUT /api/v1/devices/{device}/volume", handleSetVolume)
+	api.HandleFunc("PUT /api/v1/devices/{device}/mute", handleSetMute)
+	api.HandleFunc("PUT /api/v1/devices/{device}/enforced", handleSetEnforced)
+	api.HandleFunc("PUT /api/v1/devices/{device}/target", handleSetTarget)
+	api.HandleFunc("PUT /api/v1/devices/{device}/agc", handleSetAGC)
+	api.HandleFunc("PUT /api/v1/devices/{device}/paused", handleSetDevicePaused)
+	api.HandleFunc("PUT /api/v1/enforcement", handleSetEnforcement)
+	api.HandleFunc("PUT /api/v1/talk", handleSetTalking)
+	api.HandleFunc("GET /api/v1/events", handleEvents)
+	api.HandleFunc("GET /api/v1/history", handleHistory)
+	api.HandleFunc("GET /api/v1/devices/{device}/meter", handleMeter)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+	})
+	mux.Handle("/api/", requireToken(token, api))
+	return mux
+}
+
+// requireToken rejects requests without the API token, accepted either as a
+// bearer token or, for EventSource clients that cannot set headers, as a
+// "token" query parameter
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="MicMaxer"`)
+			writeHTTPError(w, http.StatusUnauthorized, errors.New("missing or invalid API token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeHTTPJSON writes v as a JSON response
+func writeHTTPJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeHTTPError writes an error as a JSON response
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	writeHTTPJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// readHTTPJSON decodes a JSON request body, writing a 400 response on failure
+func readHTTPJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+// requireBool checks that a required boolean field was given, so that an
+// empty or misspelled body does not act as false; it writes a 400 response
+// if the field is missing
+func requireBool(w http.ResponseWriter, name string, v *bool) bool {
+	if v == nil {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("%s must be true or false", name))
+		return false
+	}
+	return true
+}
+
+// pathDevice resolves the {device} path parameter, writing a 404 response on failure
+func pathDevice(w http.ResponseWriter, r *http.Request) (deviceRef, bool) {
+	device, err := resolveDevice(knownDevices(), r.PathValue("device"))
+	if err != nil {
+		writeHTTPError(w, http.StatusNotFound, err)
+		return deviceRef{}, false
+	}
+	return device, true
+}
+
+// readLevel decodes a {"level": n} body, validating the percentage
+func readLevel(w http.ResponseWriter, r *http.Request) (float32, bool) {
+	var body struct {
+		Level *float64 `json:"level"`
+	}
+	if !readHTTPJSON(w, r, &body) {
+		return 0, false
+	}
+	if body.Level == nil || *body.Level < 0 || *body.Level > 100 {
+		writeHTTPError(w, http.StatusBadRequest, errors.New("level must be between 0 and 100"))
+		return 0, false
+	}
+	return float32(*body.Level / 100), true
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeHTTPJSON(w, http.StatusOK, currentStatus())
+}
+
+func handleListDevices(w http.ResponseWriter, r *http.Request) {
+	writeHTTPJSON(w, http.StatusOK, currentStatus().Devices)
+}
+
+func handleGetDevice(w http.ResponseWriter, r *http.Request) {
+	device, ok := pathDevice(w, r)
+	if !ok {
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, queryDeviceStatus(device))
+}
+
+func handleSetVolume(w http.ResponseWriter, r *http.Request) {
+	device, ok := pathDevice(w, r)
+	if !ok {
+		return
+	}
+	level, ok := readLevel(w, r)
+	if !ok {
+		return
+	}
+
+	if err := setSystemInputLevel(device.ID, level); err != nil {
+		writeHTTPError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, queryDeviceStatus(device))
+}
+
+func handleSetMute(w http.ResponseWriter, r *http.Request) {
+	device, ok := pathDevice(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Muted *bool `json:"muted"`
+	}
+	if !readHTTPJSON(w, r, &body) || !requireBool(w, "muted", body.Muted) {
+		return
+	}
+
+	if err := setSystemInputMute(device.ID, *body.Muted); err != nil {
+		writeHTTPError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, queryDeviceStatus(device))
+}
+
+func handleSetEnforced(w http.ResponseWriter, r *http.Request) {
+	device, ok := pathDevice(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Enforced *bool `json:"enforced"`
+	}
+	if !readHTTPJSON(w, r, &body) || !requireBool(w, "enforced", body.Enforced) {
+		return
+	}
+
+	setDeviceChecked(device.ID, *body.Enforced)
+	writeHTTPJSON(w, http.StatusOK, queryDeviceStatus(device))
+}
+
+func handleSetTarget(w http.ResponseWriter, r *http.Request) {
+	device, ok := pathDevice(w, r)
+	if !ok {
+		return
+	}
+	level, ok := readLevel(w, r)
+	if !ok {
+		return
+	}
+
+	if err := setDeviceTarget(device.ID, level); err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, queryDeviceStatus(device))
+}
+
+func handleSetAGC(w http.ResponseWriter, r *http.Request) {
+	device, ok := pathDevice(w, r)
+	if !ok {
+		return
+	}
+	var body agcConfig
+	if !readHTTPJSON(w, r, &body) {
+		return
+	}
+
+	if err := setDeviceAGC(device.ID, body); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, queryDeviceStatus(device))
+}
+
+// pauseBody is the request body of the pause endpoints
+type pauseBody struct {
+	Paused   *bool  `json:"paused"`
+	Duration string `json:"duration"` // e.g. "5m"; until resumed if empty
+}
+
+func handleSetEnforcement(w http.ResponseWriter, r *http.Request) {
+	var body pauseBody
+	if !readHTTPJSON(w, r, &body) {
+		return
+	}
+	if !setPausedFromBody(w, "", body) {
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, currentStatus())
+}
+
+func handleSetDevicePaused(w http.ResponseWriter, r *http.Request) {
+	device, ok := pathDevice(w, r)
+	if !ok {
+		return
+	}
+	var body pauseBody
+	if !readHTTPJSON(w, r, &body) {
+		return
+	}
+	if !setPausedFromBody(w, device.ID, body) {
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, queryDeviceStatus(device))
+}
+
+// setPausedFromBody pauses or resumes a device, or all devices if deviceID
+// is empty, writing an error response for an invalid duration
+func setPausedFromBody(w http.ResponseWriter, deviceID string, body pauseBody) bool {
+	if !requireBool(w, "paused", body.Paused) {
+		return false
+	}
+	if !*body.Paused {
+		resumeEnforcement(deviceID)
+		return true
+	}
+	d, err := parsePauseDuration(body.Duration)
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return false
+	}
+	pauseEnforcement(deviceID, d)
+	return true
+}
+
+// talkBody is the request body of the push-to-talk endpoint
+type talkBody struct {
+	Held     *bool  `json:"held"`
+	Duration string `json:"duration"` // hold limit, e.g. "10s"; 30s if empty
+}
+
+func handleSetTalking(w http.ResponseWriter, r *http.Request) {
+	var body talkBody
+	if !readHTTPJSON(w, r, &body) || !requireBool(w, "held", body.Held) {
+		return
+	}
+
+	if *body.Held {
+		d, err := parseHoldDuration(body.Duration)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := ptt.hold(pttHolderAPI, d); err != nil {
+			writeHTTPError(w, http.StatusConflict, err)
+			return
+		}
+	} else {
+		ptt.unhold(pttHolderAPI)
+	}
+	writeHTTPJSON(w, http.StatusOK, currentStatus())
+}
+
+// handleMeter streams the input signal level of a device as server-sent
+// events, capturing from the device for as long as the client is connected
+func handleMeter(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTPError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	device, ok := pathDevice(w, r)
+	if !ok {
+		return
+	}
+
+	window := defaultMeterWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeHTTPError(w, http.StatusBadRequest, errors.New("window must be a positive duration such as 100ms"))
+			return
+		}
+		window = d
+	}
+
+	source, err := newCaptureSource(device.ID)
+	if err != nil {
+		writeHTTPError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err = runMeter(r.Context(), source, window, func(reading levelReading) {
+		data, err := json.Marshal(reading)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: level\ndata: %s\n\n", data)
+		flusher.Flush()
+	})
+	if err != nil {
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+}
+
+// handleHistory returns recorded events filtered by the since, until,
+// device and limit query parameters
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	var q historyQuery
+	params := r.URL.Query()
+	now := time.Now()
+
+	for name, dst := range map[string]**time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := params.Get(name); v != "" {
+			t, err := parseHistoryTime(v, now)
+			if err != nil {
+				writeHTTPError(w, http.StatusBadRequest, err)
+				return
+			}
+			*dst = &t
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			writeHTTPError(w, http.StatusBadRequest, errors.New("limit must be a non-negative integer"))
+			return
+		}
+		q.Limit = limit
+	}
+	q.Device = params.Get("device")
+
+	writeHTTPJSON(w, http.StatusOK, history.query(q))
+}
+
+// handleEvents streams events from the listener and enforcer as server-sent events
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTPError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	ch, unsubscribe := events.subscribe(64)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+func TestRequireToken(t *testing.T) {
+	handler := requireToken(testToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		auth   string
+		query  string
+		status int
+	}{
+		{"missing", "", "", http.StatusUnauthorized},
+		{"wrong bearer", "Bearer fedcba9876543210fedcba9876543210", "", http.StatusUnauthorized},
+		{"bearer prefix only", "Bearer " + testToken[:16], "", http.StatusUnauthorized},
+		{"bearer with extra", "Bearer " + testToken + "0", "", http.StatusUnauthorized},
+		{"not a bearer", "Basic " + testToken, "", http.StatusUnauthorized},
+		{"wrong query", "", "token=nope", http.StatusUnauthorized},
+		{"bearer wins over query", "Bearer nope", "token=" + testToken, http.StatusUnauthorized},
+		{"bearer", "Bearer " + testToken, "", http.StatusNoContent},
+		{"query", "", "token=" + testToken, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/status?"+tt.query, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("rejection has no WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestHTTPHandlerRequiresToken(t *testing.T) {
+	server := httptest.NewServer(newHTTPHandler(testToken))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /api/v1/status without a token = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	// The API description is public
+	resp, err = http.Get(server.URL + "/api/v1/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/v1/openapi.yaml = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestStartHTTPServerRejectsNonLoopback(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:8765", "192.168.1.10:8765", "[::]:8765", "localhost:8765", ":8765", "127.0.0.1"} {
+		err := startHTTPServer(addr)
+		if err == nil {
+			stopHTTPServer()
+			t.Errorf("startHTTPServer(%q) succeeded, want an error", addr)
+		}
+	}
+}
+
+func TestHTTPEventStream(t *testing.T) {
+	server := httptest.NewServer(newHTTPHandler(testToken))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("GET /api/v1/events = %d %s, want 200 text/event-stream", resp.StatusCode, ct)
+	}
+
+	// The handler subscribes before sending the headers, so the event is
+	// not missed
+	events.publish(appEvent{Type: eventVolumeChanged, DeviceID: "test-mic", DeviceName: "Test Mic", Volume: 42, Source: sourceListener})
+
+	var name string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "event: "); ok {
+			name = value
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+
+		var ev appEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatalf("event data %q: %v", data, err)
+		}
+		if name != string(eventVolumeChanged) || ev.Type != eventVolumeChanged || ev.DeviceID != "test-mic" || ev.Volume != 42 {
+			t.Errorf("received event %q %+v, want the published volume change", name, ev)
+		}
+		return
+	}
+	t.Fatalf("stream ended without an event: %v", scanner.Err())
+}
+
+func TestHTTPRequiresBooleanFields(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	st := useTestState(t)
+	var info malgo.DeviceInfo
+	info.ID[0] = 0x2a
+	st.audioInputDevices = []malgo.DeviceInfo{info}
+	handler := newHTTPHandler(testToken)
+
+	// put sends a request body and returns the response status and error
+	put := func(path, body string) (int, string) {
+		r := httptest.NewRequest(http.MethodPut, "/api/v1"+path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+testToken)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	tests := []struct {
+		path, body, field string
+	}{
+		{"/devices/2a/mute", `{}`, "muted"},
+		{"/devices/2a/mute", `{"muted": null}`, "muted"},
+		{"/devices/2a/enforced", `{}`, "enforced"},
+		{"/devices/2a/paused", `{"duration": "5m"}`, "paused"},
+		{"/enforcement", `{}`, "paused"},
+		{"/talk", `{"duration": "10s"}`, "held"},
+	}
+	for _, tt := range tests {
+		if status, body := put(tt.path, tt.body); status != http.StatusBadRequest || !strings.Contains(body, tt.field+" must be true or false") {
+			t.Errorf("PUT %s %s = %d %s, want %d about %s", tt.path, tt.body, status, body, http.StatusBadRequest, tt.field)
+		}
+	}
+
+	// A misspelled field is rejected rather than read as false
+	if status, _ := put("/devices/2a/mute", `{"mute": true}`); status != http.StatusBadRequest {
+		t.Errorf("PUT mute with a misspelled field = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	if status, body := put("/enforcement", `{"paused": false}`); status != http.StatusOK {
+		t.Errorf("PUT /enforcement paused false = %d %s, want %d", status, body, http.StatusOK)
+	}
+	if st.deviceStates[info.ID.String()] || st.paused {
+		t.Error("a rejected request changed the enforcement state")
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+//go:embed assets/*.png
+var iconData embed.FS
+
+// conflictIconDuration is how long the icon shows a detected conflict
+const conflictIconDuration = 5 * time.Minute
+
+// iconState is the condition shown by the menu bar icon
+type iconState int
+
+// Icon states, from least to most urgent
+const (
+	iconIdle iconState = iota
+	iconEnforcing
+	iconPaused
+	iconMuted
+	iconConflict
+	iconError
+)
+
+// iconFiles maps icon states to their embedded images
+var iconFiles = map[iconState]string{
+	iconIdle:      "assets/icon.png",
+	iconEnforcing: "assets/icon-enforcing.png",
+	iconPaused:    "assets/icon-paused.png",
+	iconMuted:     "assets/icon-muted.png",
+	iconConflict:  "assets/icon-conflict.png",
+	iconError:     "assets/icon-error.png",
+}
+
+func (s iconState) String() string {
+	switch s {
+	case iconEnforcing:
+		return "enforcing"
+	case iconPaused:
+		return "paused"
+	case iconMuted:
+		return "muted"
+	case iconConflict:
+		return "conflict"
+	case iconError:
+		return "error"
+	}
+	return "idle"
+}
+
+// trayIcon keeps the menu bar icon in line with the enforcement state
+type trayIcon struct {
+	mu          sync.Mutex
+	shown       bool
+	current     iconState
+	conflicts   map[string]time.Time                // device ID to time of its last conflict
+	errors      map[string]error                    // device ID to its last enforcement error
+	muted       map[string]bool                     // device ID to its last known mute state
+	readMute    func(deviceID string) (bool, error) // nil to read the system mute state
+	unsubscribe func()
+}
+
+// Global tray icon instance
+var icon = &trayIcon{
+	conflicts: make(map[string]time.Time),
+	errors:    make(map[string]error),
+	muted:     make(map[string]bool),
+}
+
+// loadIcon returns the image for an icon state
+func loadIcon(s iconState) ([]byte, error) {
+	return iconData.ReadFile(iconFiles[s])
+}
+
+// start shows the icon for the current state and keeps it updated from events
+func (t *trayIcon) start() {
+	ch, unsubscribe := events.subscribe(32)
+	t.mu.Lock()
+	t.shown = true
+	t.current = -1
+	t.unsubscribe = unsubscribe
+	t.mu.Unlock()
+	t.refresh()
+
+	go func() {
+		for ev := range ch {
+			t.observe(ev)
+			t.refresh()
+		}
+	}()
+}
+
+// stop hides the icon from further updates and ends its subscription
+func (t *trayIcon) stop() {
+	t.mu.Lock()
+	t.shown = false
+	unsubscribe := t.unsubscribe
+	t.unsubscribe = nil
+	t.mu.Unlock()
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+}
+
+// observe records what an event says about conflicts, failures and mute
+// states. The listener reports the mute state of the default device; other
+// devices are read again after changes that may mute or unmute them.
+func (t *trayIcon) observe(ev appEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch ev.Type {
+	case eventConflictDetected:
+		t.conflicts[ev.DeviceID] = ev.Time
+		time.AfterFunc(conflictIconDuration, t.refresh)
+	case eventDeviceRemoved:
+		delete(t.conflicts, ev.DeviceID)
+		delete(t.errors, ev.DeviceID)
+		delete(t.muted, ev.DeviceID)
+	case eventDeviceUnchecked:
+		delete(t.errors, ev.DeviceID)
+		delete(t.muted, ev.DeviceID)
+	case eventVolumeChanged:
+		if ev.Source == sourceListener && ev.DeviceID != "" {
+			t.muted[ev.DeviceID] = ev.Muted
+		}
+	case eventDeviceChecked, eventEnforcementResumed, eventPushToTalkHeld, eventPushToTalkReleased:
+		clear(t.muted)
+	}
+}
+
+// isMuted returns the last known mute state of a device, reading it from
+// the system the first time it is needed. A device whose mute state cannot
+// be read counts as unmuted.
+func (t *trayIcon) isMuted(deviceID string) bool {
+	t.mu.Lock()
+	muted, known := t.muted[deviceID]
+	readMute := t.readMute
+	t.mu.Unlock()
+	if known {
+		return muted
+	}
+
+	if readMute == nil {
+		readMute = getSystemInputMute
+	}
+	muted, err := readMute(deviceID)
+	if err != nil {
+		muted = false
+	}
+	t.mu.Lock()
+	t.muted[deviceID] = muted
+	t.mu.Unlock()
+	return muted
+}
+
+// activate answers a second launch of the app. The menu of a tray icon
+// cannot be opened for the user, so this points them at the icon with a
+// notification of its current state; it does nothing without an icon.
+func (t *trayIcon) activate() {
+	t.mu.Lock()
+	shown := t.shown
+	t.mu.Unlock()
+	if !shown {
+		return
+	}
+
+	t.refresh()
+	title, message := activationText(t.evaluate())
+	go func() {
+		if err := desktopNotify(title, message); err != nil {
+			slog.Warn("Failed to show notification", "event", eventInstanceActivated, "error", err)
+		}
+	}()
+}
+
+// activationText returns the notification shown when the app is launched
+// again, describing the icon's state
+func activationText(s iconState, tooltip string) (string, string) {
+	status := "No devices are enforced"
+	if s != iconIdle {
+		status = strings.TrimPrefix(tooltip, "MicMaxer: ")
+		status = strings.ToUpper(status[:1]) + status[1:]
+	}
+	return "MicMaxer is already running", status + ". Use its menu bar icon to change settings."
+}
+
+// setDeviceError records the outcome of applying a device's volume; a nil
+// error clears a previous failure
+func (t *trayIcon) setDeviceError(deviceID string, err error) {
+	t.mu.Lock()
+	_, had := t.errors[deviceID]
+	if err != nil {
+		t.errors[deviceID] = err
+	} else {
+		delete(t.errors, deviceID)
+	}
+	t.mu.Unlock()
+
+	if had != (err != nil) {
+		t.refresh()
+	}
+}
+
+// evaluate determines the most urgent state and a tooltip describing it
+func (t *trayIcon) evaluate() (iconState, string) {
+	state.mu.RLock()
+	paused := state.paused
+	var enforced, pausedDevices []string
+	for id, checked := range state.deviceStates {
+		if checked {
+			enforced = append(enforced, id)
+			if _, ok := state.devicePauses[id]; ok {
+				pausedDevices = append(pausedDevices, id)
+			}
+		}
+	}
+	names := make(map[string]string, len(state.audioInputDevices))
+	for _, device := range state.audioInputDevices {
+		names[device.ID.String()] = device.Name()
+	}
+	state.mu.RUnlock()
+
+	// Devices are considered in ID order so the tooltip does not change
+	// between refreshes when several qualify
+	slices.Sort(enforced)
+	slices.Sort(pausedDevices)
+
+	t.mu.Lock()
+	if failing := slices.Sorted(maps.Keys(t.errors)); len(failing) > 0 {
+		err := t.errors[failing[0]]
+		t.mu.Unlock()
+		return iconError, fmt.Sprintf("MicMaxer: %s: %v", names[failing[0]], err)
+	}
+	for _, id := range slices.Sorted(maps.Keys(t.conflicts)) {
+		if time.Since(t.conflicts[id]) < conflictIconDuration {
+			t.mu.Unlock()
+			return iconConflict, fmt.Sprintf("MicMaxer: another app keeps changing %s", names[id])
+		}
+		delete(t.conflicts, id)
+	}
+	t.mu.Unlock()
+
+	for _, id := range enforced {
+		if t.isMuted(id) {
+			return iconMuted, fmt.Sprintf("MicMaxer: %s is muted", names[id])
+		}
+	}
+	switch {
+	case paused:
+		return iconPaused, "MicMaxer: enforcement paused"
+	case len(pausedDevices) > 0:
+		return iconPaused, fmt.Sprintf("MicMaxer: enforcement of %s paused", names[pausedDevices[0]])
+	case len(enforced) == 1:
+		return iconEnforcing, fmt.Sprintf("MicMaxer: enforcing %s", names[enforced[0]])
+	case len(enforced) > 1:
+		return iconEnforcing, fmt.Sprintf("MicMaxer: enforcing %d devices", len(enforced))
+	}
+	return iconIdle, "MicMaxer"
+}
+
+// refresh switches the icon if the state changed
+func (t *trayIcon) refresh() {
+	t.mu.Lock()
+	shown := t.shown
+	t.mu.Unlock()
+	if !shown {
+		return
+	}
+
+	s, tooltip := t.evaluate()
+	systray.SetTooltip(tooltip)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s == t.current {
+		return
+	}
+	data, err := loadIcon(s)
+	if err != nil {
+		slog.Error("Failed to load icon", "state", s.String(), "error", err)
+		return
+	}
+	systray.SetIcon(data)
+	slog.Debug("Tray icon changed", "state", s.String())
+	t.current = s
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestIcon replaces the global tray icon and enforcement failures with
+// empty ones; the icon is not shown, so only its state is evaluated
+func useTestIcon(t *testing.T) *trayIcon {
+	t.Helper()
+	savedIcon, savedFailures := icon, failures
+	icon = &trayIcon{
+		conflicts: make(map[string]time.Time),
+		errors:    make(map[string]error),
+		muted:     make(map[string]bool),
+		readMute:  func(string) (bool, error) { return false, nil },
+	}
+	failures = &failureTracker{failing: make(map[string]bool)}
+	t.Cleanup(func() { icon, failures = savedIcon, savedFailures })
+	return icon
+}
+
+func TestTrayIconStates(t *testing.T) {
+	st := useTestState(t)
+	ti := useTestIcon(t)
+
+	check := func(step string, want iconState, tooltip string) {
+		t.Helper()
+		got, tip := ti.evaluate()
+		if got != want || !strings.Contains(tip, tooltip) {
+			t.Errorf("%s: state %s tooltip %q, want %s containing %q", step, got, tip, want, tooltip)
+		}
+	}
+
+	check("nothing enforced", iconIdle, "MicMaxer")
+
+	st.deviceStates["a"] = true
+	check("one device enforced", iconEnforcing, "enforcing")
+	st.deviceStates["b"] = true
+	check("two devices enforced", iconEnforcing, "enforcing 2 devices")
+
+	st.devicePauses["b"] = time.Time{}
+	check("one device paused", iconPaused, "enforcement of")
+	st.paused = true
+	check("all paused", iconPaused, "enforcement paused")
+	st.paused = false
+	delete(st.devicePauses, "b")
+
+	ti.conflicts["a"] = time.Now().Add(-conflictIconDuration)
+	check("expired conflict", iconEnforcing, "enforcing 2 devices")
+	ti.conflicts["a"] = time.Now()
+	check("conflict", iconConflict, "another app keeps changing")
+
+	// Errors outrank conflicts, and the first failing device by ID is shown
+	// whatever order the map iterates in
+	ti.setDeviceError("b", errors.New("b failed"))
+	ti.setDeviceError("a", errors.New("a failed"))
+	for i := 0; i < 20; i++ {
+		check("two devices failing", iconError, "a failed")
+	}
+	ti.setDeviceError("a", nil)
+	check("one device failing", iconError, "b failed")
+	ti.setDeviceError("b", nil)
+	check("failure cleared", iconConflict, "another app keeps changing")
+}
+
+func TestSetDeviceCheckedReportsFailure(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	useTestState(t)
+	ti := useTestIcon(t)
+	ch, unsubscribe := events.subscribe(16)
+	defer unsubscribe()
+
+	// Checking a device whose volume cannot be set, here because it does not
+	// exist, shows the error at once rather than on the next enforcer pass
+	setDeviceChecked("mic", true)
+	if state, _ := ti.evaluate(); state != iconError {
+		t.Fatalf("icon after checking a failing device = %s, want %s", state, iconError)
+	}
+
+	var reported bool
+	for len(ch) > 0 {
+		if ev := <-ch; ev.Type == eventEnforcementFailed && ev.DeviceID == "mic" && ev.Source == sourceEnforcer {
+			reported = true
+		}
+	}
+	if !reported {
+		t.Errorf("no %s event for the device", eventEnforcementFailed)
+	}
+
+	setDeviceChecked("mic", false)
+	if state, _ := ti.evaluate(); state != iconIdle {
+		t.Errorf("icon after unchecking the failing device = %s, want %s", state, iconIdle)
+	}
+}
+
+func TestTrayIconMuteState(t *testing.T) {
+	st := useTestState(t)
+	ti := useTestIcon(t)
+	reads := 0
+	systemMuted := true
+	ti.readMute = func(string) (bool, error) {
+		reads++
+		return systemMuted, nil
+	}
+	st.deviceStates["a"] = true
+
+	// The mute state is read once, not on every refresh
+	for i := 0; i < 3; i++ {
+		if got, _ := ti.evaluate(); got != iconMuted {
+			t.Fatalf("icon for a muted device = %s, want %s", got, iconMuted)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("mute state read %d times for three refreshes, want 1", reads)
+	}
+
+	// The listener reports changes of the default device
+	ti.observe(appEvent{Type: eventVolumeChanged, DeviceID: "a", Muted: false, Source: sourceListener})
+	if got, _ := ti.evaluate(); got != iconEnforcing || reads != 1 {
+		t.Errorf("icon after the listener reported an unmute = %s with %d reads, want %s with 1", got, reads, iconEnforcing)
+	}
+
+	// Push-to-talk may mute any enforced device, so they are read again
+	ti.observe(appEvent{Type: eventPushToTalkHeld, Muted: true, Source: sourceUser})
+	if got, _ := ti.evaluate(); got != iconMuted || reads != 2 {
+		t.Errorf("icon after push-to-talk = %s with %d reads, want %s with 2", got, reads, iconMuted)
+	}
+
+	// An unreadable mute state counts as unmuted and is not read again
+	delete(ti.muted, "a")
+	ti.readMute = func(string) (bool, error) {
+		reads++
+		return false, errors.New("no mute control")
+	}
+	for i := 0; i < 2; i++ {
+		if got, _ := ti.evaluate(); got != iconEnforcing {
+			t.Errorf("icon for a device without mute control = %s, want %s", got, iconEnforcing)
+		}
+	}
+	if reads != 3 {
+		t.Errorf("unreadable mute state read %d times in total, want 3", reads)
+	}
+}
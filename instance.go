@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// errAlreadyRunning is returned when another instance holds the instance lock
+var errAlreadyRunning = errors.New("another MicMaxer instance is already running")
+
+// A second launch may race the first one's startup, so keep trying to reach
+// the control socket for a short while
+const (
+	handoffRetries = 10
+	handoffDelay   = 200 * time.Millisecond
+)
+
+// instanceLockPath returns the per-user location of the instance lock file
+func instanceLockPath() (string, error) {
+	return runtimePath("micmaxer2.lock", "instance.lock")
+}
+
+// handoffToRunningInstance forwards this launch's arguments to the running
+// instance over the control socket and returns the exit code for this process
+func handoffToRunningInstance(args []string) int {
+	var client *rpcClient
+	var err error
+	for i := 0; i < handoffRetries; i++ {
+		if client, err = dialControl(); err == nil {
+			break
+		}
+		time.Sleep(handoffDelay)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "micmaxer: %v, but its control socket is not responding: %v\n", errAlreadyRunning, err)
+		return 1
+	}
+	defer client.Close()
+
+	if err := client.call("instance.activate", rpcActivateParams{Args: args}, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "micmaxer: failed to hand off to the running instance: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintln(os.Stderr, "micmaxer: MicMaxer is already running; passed the request to it")
+	return 0
+}
+
+// activateInstance handles a second launch: it shows the menu bar icon's
+// state, rescans devices so anything plugged in since startup is picked up,
+// and applies any forwarded options that can change at runtime
+func activateInstance(args []string) error {
+	opts, err := parseRunOptions(args, flag.ContinueOnError)
+	if err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	slog.Info("Activated by a second launch", "args", args)
+	events.publish(appEvent{Type: eventInstanceActivated})
+
+	// Rescan before replying so the second launch returns once the
+	// devices are up to date
+	refreshAudioInputDevices()
+	icon.activate()
+
+	// Both do nothing when the server is already running
+	if opts.httpAddr != "" {
+		if err := startHTTPServer(opts.httpAddr); err != nil {
+			return err
+		}
+	}
+	if opts.metricsAddr != "" {
+		if err := startMetricsServer(opts.metricsAddr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Held for the lifetime of the process
+var instanceLock io.Closer
+
+// releaseInstanceLock releases the instance lock, if held
+func releaseInstanceLock() {
+	if instanceLock != nil {
+		instanceLock.Close()
+		instanceLock = nil
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+// acquireInstanceLock is not implemented for this platform; the control
+// socket still refuses to start while another instance is listening
+func acquireInstanceLock() error {
+	return nil
+}
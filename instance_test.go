@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHandoffToRunningInstance(t *testing.T) {
+	useTestState(t)
+	startTestControlServer(t)
+	ch, unsubscribe := events.subscribe(16)
+	defer unsubscribe()
+
+	// activated reports whether the running instance was activated since
+	// the last call; devices found by its rescan are published too
+	activated := func() bool {
+		var found bool
+		for len(ch) > 0 {
+			if ev := <-ch; ev.Type == eventInstanceActivated {
+				found = true
+			}
+		}
+		return found
+	}
+
+	if code := handoffToRunningInstance([]string{"--log-level", "debug"}); code != 0 {
+		t.Fatalf("handoff exit code %d, want 0", code)
+	}
+	if !activated() {
+		t.Errorf("no %s event on handoff", eventInstanceActivated)
+	}
+
+	// Arguments the running instance cannot parse fail the second launch
+	if code := handoffToRunningInstance([]string{"--bogus"}); code != 1 {
+		t.Errorf("handoff of unknown flags exit code %d, want 1", code)
+	}
+	if activated() {
+		t.Errorf("%s event on a failed handoff", eventInstanceActivated)
+	}
+}
+
+func TestActivationText(t *testing.T) {
+	tests := []struct {
+		state   iconState
+		tooltip string
+		want    string
+	}{
+		{iconIdle, "MicMaxer", "No devices are enforced. Use its menu bar icon to change settings."},
+		{iconEnforcing, "MicMaxer: enforcing 2 devices", "Enforcing 2 devices. Use its menu bar icon to change settings."},
+		{iconMuted, "MicMaxer: USB mic is muted", "USB mic is muted. Use its menu bar icon to change settings."},
+	}
+	for _, tt := range tests {
+		if title, got := activationText(tt.state, tt.tooltip); title != "MicMaxer is already running" || got != tt.want {
+			t.Errorf("activationText(%s, %q) = %q, %q, want %q", tt.state, tt.tooltip, title, got, tt.want)
+		}
+	}
+}
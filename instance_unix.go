@@ -0,0 +1,46 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// acquireInstanceLock takes an exclusive advisory lock on the per-user lock
+// file. The kernel drops the lock when the process exits, so a crash never
+// leaves a stale lock behind.
+func acquireInstanceLock() error {
+	path, err := instanceLockPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return errAlreadyRunning
+		}
+		return fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// Record our PID to help when debugging
+	_ = file.Truncate(0)
+	_, _ = fmt.Fprintf(file, "%d\n", os.Getpid())
+
+	instanceLock = file
+	return nil
+}
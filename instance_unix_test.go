@@ -0,0 +1,73 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useTestInstanceLock keeps the instance lock in a temporary runtime
+// directory and releases it after the test
+func useTestInstanceLock(t *testing.T) string {
+	t.Helper()
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Cleanup(releaseInstanceLock)
+	path, err := instanceLockPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInstanceLock(t *testing.T) {
+	path := useTestInstanceLock(t)
+
+	if err := acquireInstanceLock(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err != nil || pid != os.Getpid() {
+		t.Errorf("lock file holds %q, want our PID %d", data, os.Getpid())
+	}
+
+	// The lock is per open file, so a second attempt fails even from the
+	// same process
+	held := instanceLock
+	if err := acquireInstanceLock(); err != errAlreadyRunning {
+		t.Errorf("second acquireInstanceLock() = %v, want %v", err, errAlreadyRunning)
+	}
+	if instanceLock != held {
+		t.Error("failed attempt replaced the held lock")
+	}
+
+	releaseInstanceLock()
+	if err := acquireInstanceLock(); err != nil {
+		t.Errorf("acquireInstanceLock() after release: %v", err)
+	}
+}
+
+func TestInstanceLockStale(t *testing.T) {
+	path := useTestInstanceLock(t)
+
+	// A lock file left behind by a crashed instance is not locked by anyone
+	if err := os.WriteFile(path, []byte("999999\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := acquireInstanceLock(); err != nil {
+		t.Fatalf("acquireInstanceLock() over a stale lock file: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), strconv.Itoa(os.Getpid())+"\n"; got != want {
+		t.Errorf("lock file holds %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Defaults for the persistent log file
+const (
+	logFileName        = "micmaxer2.log"
+	defaultLogMaxSize  = 5 // megabytes
+	defaultLogMaxFiles = 3 // rotated files kept besides the current one
+	logFollowInterval  = 500 * time.Millisecond
+)
+
+// logDir returns the per-user directory holding log files
+func logDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %w", err)
+	}
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(home, "Library", "Logs", "MicMaxer2"), nil
+	}
+
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		state = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(state, "MicMaxer2"), nil
+}
+
+// logFilePath returns the location of the current log file
+func logFilePath() (string, error) {
+	dir, err := logDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, logFileName), nil
+}
+
+// rotatingFile is an io.Writer that appends to a log file, rotating it to
+// numbered backups once it grows past maxSize bytes
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	warn     io.Writer // where rotation failures are reported; stderr if nil
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	moved   bool // the file was rotated to a backup but no new file could be opened yet
+	failing bool // a rotation failure has been reported and not resolved since
+}
+
+// openRotatingFile opens path for appending, creating its directory if needed
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	f, size, err := openLogWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles, file: f, size: size}, nil
+}
+
+// openLogWriter opens a log file for appending and returns its size
+func openLogWriter(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("failed to stat log file: %w", err)
+	}
+	return f, info.Size(), nil
+}
+
+// Write appends p to the log file, rotating first if it would exceed the
+// size limit. A failed rotation is reported and logging carries on in the
+// file already open, so no records are lost.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.moved || (r.size > 0 && r.size+int64(len(p)) > r.maxSize) {
+		r.report(r.rotate())
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// report writes the first of a series of rotation failures to the warning
+// output, since it cannot be logged; the caller must hold r.mu
+func (r *rotatingFile) report(err error) {
+	if err == nil {
+		r.failing = false
+		return
+	}
+	if r.failing {
+		return
+	}
+	r.failing = true
+
+	w := r.warn
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "micmaxer: failed to rotate log file %s: %v\n", r.path, err)
+}
+
+// rotate shifts micmaxer2.log to micmaxer2.log.1 and so on, dropping the
+// oldest backup beyond maxFiles, and starts a new file. The current file
+// stays open until the new one is, so writes go on if it cannot be opened;
+// the next write tries again. If the current file cannot be moved aside, it
+// is kept until it has grown by another maxSize.
+func (r *rotatingFile) rotate() error {
+	var errs []error
+	if !r.moved {
+		if err := os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		for i := r.maxFiles - 1; i >= 1; i-- {
+			err := os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+
+		var err error
+		if r.maxFiles > 0 {
+			err = os.Rename(r.path, r.path+".1")
+		} else {
+			err = os.Remove(r.path)
+		}
+		if err != nil {
+			r.size = 0
+			return errors.Join(append(errs, err)...)
+		}
+		r.moved = true
+	}
+
+	f, size, err := openLogWriter(r.path)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	r.file.Close()
+	r.file, r.size, r.moved = f, size, false
+	return errors.Join(errs...)
+}
+
+// Close closes the log file
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// openLogFile opens the rotating log file using the size and retention
+// settings from the config file
+func openLogFile(cfg *loggingConfig) (*rotatingFile, error) {
+	path, err := logFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize, maxFiles := defaultLogMaxSize, defaultLogMaxFiles
+	if cfg != nil {
+		if cfg.MaxSizeMB > 0 {
+			maxSize = cfg.MaxSizeMB
+		}
+		if cfg.MaxFiles > 0 {
+			maxFiles = cfg.MaxFiles
+		}
+	}
+	return openRotatingFile(path, int64(maxSize)<<20, maxFiles)
+}
+
+// showLogFile opens the current log file in the platform's default viewer
+func showLogFile() error {
+	path, err := logFilePath()
+	if err != nil {
+		return err
+	}
+
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	cmd := exec.Command(opener, path)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// tailLines returns the last n lines of the file at path
+func tailLines(path string, n int) ([]string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	offset, err := f.Seek(0, io.SeekCurrent)
+	return lines, offset, err
+}
+
+// followLogFile copies lines appended to the log file at path to w from
+// offset onwards until ctx is cancelled. The file is kept open and compared
+// with the one at path, so after a rotation the rest of the old file is
+// copied before following the new one from its start.
+func followLogFile(ctx context.Context, path string, offset int64, w io.Writer) error {
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+
+	for {
+		if f != nil {
+			if _, err := io.Copy(w, f); err != nil {
+				return err
+			}
+		}
+
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			offset = 0 // between rotation steps; the next file is new
+		} else if err != nil {
+			return err
+		} else if f == nil || !sameFile(f, info) {
+			next, err := os.Open(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if next != nil {
+				if f != nil {
+					f.Close()
+					offset = 0 // a rotated file is followed from its start
+				}
+				f = next
+				if _, err := f.Seek(offset, io.SeekStart); err != nil {
+					return err
+				}
+				continue
+			}
+		} else if pos, err := f.Seek(0, io.SeekCurrent); err == nil && info.Size() < pos {
+			// Truncated in place
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// sameFile reports whether the open file f is the file described by info
+func sameFile(f *os.File, info os.FileInfo) bool {
+	current, err := f.Stat()
+	return err == nil && os.SameFile(current, info)
+}
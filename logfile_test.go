@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readFile returns the contents of a file, or "" if it does not exist
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ""
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", logFileName)
+	r, err := openRotatingFile(path, 22, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Two lines fill a file, and the oldest lines fall out past two backups
+	for i := 1; i <= 7; i++ {
+		if _, err := fmt.Fprintf(r, "line %d ...\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		path:        "line 7 ...\n",
+		path + ".1": "line 5 ...\nline 6 ...\n",
+		path + ".2": "line 3 ...\nline 4 ...\n",
+		path + ".3": "",
+	} {
+		if got := readFile(t, name); got != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), got, want)
+		}
+	}
+}
+
+func TestRotatingFileReopensWithSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), logFileName)
+	if err := os.WriteFile(path, []byte("earlier run\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The size of the existing file counts towards the limit
+	r, err := openRotatingFile(path, 20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("this run\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("closed\n")); err == nil {
+		t.Error("write after close succeeded")
+	}
+
+	if got, want := readFile(t, path), "this run\n"; got != want {
+		t.Errorf("current file = %q, want %q", got, want)
+	}
+	if got, want := readFile(t, path+".1"), "earlier run\n"; got != want {
+		t.Errorf("rotated file = %q, want %q", got, want)
+	}
+}
+
+func TestRotatingFileKeepsLoggingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), logFileName)
+	r, err := openRotatingFile(path, 22, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var warnings bytes.Buffer
+	r.warn = &warnings
+
+	// A directory in the way of the first backup stops the current file from
+	// being moved aside, so it keeps growing
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		if _, err := fmt.Fprintf(r, "line %d ...\n", i); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if got, want := readFile(t, path), "line 1 ...\nline 2 ...\nline 3 ...\nline 4 ...\n"; got != want {
+		t.Errorf("current file = %q, want %q", got, want)
+	}
+	if n := strings.Count(warnings.String(), "failed to rotate"); n != 1 {
+		t.Errorf("reported %d rotation failures, want 1:\n%s", n, warnings.String())
+	}
+
+	// Once the way is clear, rotation resumes
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(r, "line 5 ...\n")
+	if got, want := readFile(t, path), "line 5 ...\n"; got != want {
+		t.Errorf("current file after recovering = %q, want %q", got, want)
+	}
+
+	// When the new file cannot be opened, writes go on in the rotated file
+	// until it can
+	warnings.Reset()
+	fmt.Fprint(r, "line 6 ...\n")
+	r.mu.Lock()
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+	r.moved = true
+	r.mu.Unlock()
+
+	fmt.Fprint(r, "line 7 ...\n")
+	if got, want := readFile(t, path+".1"), "line 5 ...\nline 6 ...\nline 7 ...\n"; got != want {
+		t.Errorf("rotated file while the new one cannot be opened = %q, want %q", got, want)
+	}
+	if !strings.Contains(warnings.String(), "failed to open log file") {
+		t.Errorf("open failure not reported: %q", warnings.String())
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(r, "line 8 ...\n")
+	if got, want := readFile(t, path), "line 8 ...\n"; got != want {
+		t.Errorf("current file once it can be opened = %q, want %q", got, want)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for a writer and a reader goroutine
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor waits until the buffer holds want
+func (b *syncBuffer) waitFor(t *testing.T, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for b.String() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("followed %q, want %q", b.String(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFollowLogFileAcrossRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), logFileName)
+	r, err := openRotatingFile(path, 100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	fmt.Fprint(r, "before following\n", "first line, already shown by the tail\n")
+	_, offset, err := tailLines(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var out syncBuffer
+	done := make(chan error, 1)
+	go func() { done <- followLogFile(ctx, path, offset, &out) }()
+
+	second := "second line\n"
+	fmt.Fprint(r, second)
+	out.waitFor(t, second)
+
+	// The last line of the old file is copied before the new file, which
+	// grows past the old file's size before the next poll
+	third := "third line, old file\n"
+	fmt.Fprint(r, third)
+	old, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated string
+	for i := 1; i <= 3; i++ {
+		line := fmt.Sprintf("new file, line %d%s\n", i, strings.Repeat(".", 15))
+		fmt.Fprint(r, line)
+		rotated += line
+	}
+	if info, err := os.Stat(path); err != nil || os.SameFile(old, info) || info.Size() <= old.Size() {
+		t.Fatalf("log file was not rotated and grown past %d bytes: %v", old.Size(), err)
+	}
+	out.waitFor(t, second+third+rotated)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("followLogFile: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Defaults for diagnostic logging
+const (
+	defaultLogLevel  = "info"
+	defaultLogFormat = "text"
+)
+
+// loggingConfig selects the level and format of diagnostic logging
+type loggingConfig struct {
+	Level     string `json:"level,omitempty"`       // debug, info, warn or error
+	Format    string `json:"format,omitempty"`      // text or json
+	MaxSizeMB int    `json:"max_size_mb,omitempty"` // rotate the log file past this size
+	MaxFiles  int    `json:"max_files,omitempty"`   // rotated log files to keep
+}
+
+// newLogHandler creates a slog handler writing to w at the given level and format
+func newLogHandler(w io.Writer, level, format string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: expected debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: expected text or json", format)
+	}
+}
+
+// setupLogging installs the default slog logger; messages from the standard
+// log package are routed through it as well
+func setupLogging(w io.Writer, level, format string) error {
+	handler, err := newLogHandler(w, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Log file written by the running app, if it could be opened
+var logFile *rotatingFile
+
+// configureLogging sets up logging for the app from the command-line options,
+// falling back to the config file and then the defaults. Messages go to
+// stderr and to the rotating log file in the per-user log directory.
+func configureLogging(opts runOptions) error {
+	level, format := defaultLogLevel, defaultLogFormat
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "micmaxer: %v\n", err)
+	}
+	if cfg.Logging != nil {
+		if cfg.Logging.Level != "" {
+			level = cfg.Logging.Level
+		}
+		if cfg.Logging.Format != "" {
+			format = cfg.Logging.Format
+		}
+	}
+
+	if opts.logLevel != "" {
+		level = opts.logLevel
+	}
+	if opts.logFormat != "" {
+		format = opts.logFormat
+	}
+
+	var w io.Writer = os.Stderr
+	file, fileErr := openLogFile(cfg.Logging)
+	if fileErr == nil {
+		logFile = file
+		w = io.MultiWriter(os.Stderr, file)
+	}
+
+	if err := setupLogging(w, level, format); err != nil {
+		return err
+	}
+	if fileErr != nil {
+		slog.Warn("Logging to stderr only", "error", fileErr)
+	}
+	return nil
+}
+
+// closeLogFile closes the log file on exit
+func closeLogFile() {
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+}
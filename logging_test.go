@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// handlerLevel returns the lowest standard level a handler logs at
+func handlerLevel(h slog.Handler) slog.Level {
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError} {
+		if h.Enabled(context.Background(), level) {
+			return level
+		}
+	}
+	return slog.LevelError + 1
+}
+
+// isJSONHandler reports whether h writes JSON, failing for unknown handlers
+func isJSONHandler(t *testing.T, h slog.Handler) bool {
+	t.Helper()
+	switch h.(type) {
+	case *slog.JSONHandler:
+		return true
+	case *slog.TextHandler:
+		return false
+	}
+	t.Fatalf("handler is a %T, want a text or JSON handler", h)
+	return false
+}
+
+func TestNewLogHandler(t *testing.T) {
+	tests := []struct {
+		level, format string
+		wantLevel     slog.Level
+		wantJSON      bool
+		wantErr       bool
+	}{
+		{level: "debug", format: "text", wantLevel: slog.LevelDebug},
+		{level: "info", format: "json", wantLevel: slog.LevelInfo, wantJSON: true},
+		{level: "WARN", format: "JSON", wantLevel: slog.LevelWarn, wantJSON: true},
+		{level: "error", format: "Text", wantLevel: slog.LevelError},
+		{level: "verbose", format: "text", wantErr: true},
+		{level: "", format: "text", wantErr: true},
+		{level: "info", format: "xml", wantErr: true},
+		{level: "info", format: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		h, err := newLogHandler(io.Discard, tt.level, tt.format)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("newLogHandler(%q, %q) succeeded, want an error", tt.level, tt.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newLogHandler(%q, %q): %v", tt.level, tt.format, err)
+			continue
+		}
+		if level, json := handlerLevel(h), isJSONHandler(t, h); level != tt.wantLevel || json != tt.wantJSON {
+			t.Errorf("newLogHandler(%q, %q) logs at %v with JSON %v, want %v with JSON %v",
+				tt.level, tt.format, level, json, tt.wantLevel, tt.wantJSON)
+		}
+	}
+}
+
+func TestConfigureLogging(t *testing.T) {
+	saved := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(saved) })
+
+	tests := []struct {
+		name      string
+		config    *loggingConfig
+		args      []string
+		wantLevel slog.Level
+		wantJSON  bool
+		wantErr   bool
+	}{
+		{name: "defaults", wantLevel: slog.LevelInfo},
+		{name: "config", config: &loggingConfig{Level: "debug", Format: "json"}, wantLevel: slog.LevelDebug, wantJSON: true},
+		{name: "config level only", config: &loggingConfig{Level: "warn"}, wantLevel: slog.LevelWarn},
+		{name: "flags", args: []string{"--log-level", "error", "--log-format", "json"}, wantLevel: slog.LevelError, wantJSON: true},
+		{name: "flags override config", config: &loggingConfig{Level: "debug", Format: "json"},
+			args: []string{"--log-level=warn", "--log-format=text"}, wantLevel: slog.LevelWarn},
+		{name: "flag overrides invalid config", config: &loggingConfig{Level: "verbose"},
+			args: []string{"--log-level", "info"}, wantLevel: slog.LevelInfo},
+		{name: "invalid flag level", args: []string{"--log-level", "loud"}, wantErr: true},
+		{name: "invalid flag format", args: []string{"--log-format", "xml"}, wantErr: true},
+		{name: "invalid config format", config: &loggingConfig{Format: "xml"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("XDG_STATE_HOME", t.TempDir())
+			if tt.config != nil {
+				if err := saveConfig(&appConfig{Logging: tt.config}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opts, err := parseRunOptions(tt.args, flag.ContinueOnError)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			placeholder := slog.New(slog.NewTextHandler(io.Discard, nil))
+			slog.SetDefault(placeholder)
+			err = configureLogging(opts)
+			t.Cleanup(closeLogFile)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("configureLogging succeeded, want an error")
+				}
+				if slog.Default() != placeholder {
+					t.Error("configureLogging replaced the logger despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("configureLogging: %v", err)
+			}
+
+			h := slog.Default().Handler()
+			if level, json := handlerLevel(h), isJSONHandler(t, h); level != tt.wantLevel || json != tt.wantJSON {
+				t.Errorf("logging at %v with JSON %v, want %v with JSON %v", level, json, tt.wantLevel, tt.wantJSON)
+			}
+			if logFile == nil {
+				t.Error("the log file was not opened")
+			}
+		})
+	}
+}
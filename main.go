@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
-	"embed"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"os"
 	"sync"
 	"time"
 
@@ -12,14 +15,13 @@ import (
 	"github.com/getlantern/systray"
 )
 
-//go:embed assets/icon.png
-var iconData embed.FS
-
 // Constants for configuration
 const (
 	volumeEnforcerInterval = 60 * time.Second
+	deviceScanInterval     = 10 * time.Second
 	volumeResetDelay       = 0 * time.Second
 	targetVolumeLevel      = 1.0 // 100%
+	enforcerStopTimeout    = 5 * time.Second
 )
 
 // audioState manages the application's audio device state with proper synchronization
@@ -27,171 +29,248 @@ type audioState struct {
 	mu                sync.RWMutex
 	audioInputDevices []malgo.DeviceInfo
 	deviceStates      map[string]bool
+	deviceTargets     map[string]float32
+	paused            bool
+	pausedUntil       time.Time                // zero while paused until resumed
+	devicePauses      map[string]time.Time     // per-device pauses, zero time until resumed
+	mutePolicies      map[string]string        // mute state enforced per device, absent to leave it alone
+	scheduled         map[string]scheduleState // schedule state of enforced devices with a schedule
 	enforcerCancel    context.CancelFunc
+	enforcerDone      chan struct{}
 }
 
 // Global audio state instance
 var state = &audioState{
-	deviceStates: make(map[string]bool),
+	deviceStates:  make(map[string]bool),
+	deviceTargets: make(map[string]float32),
+	devicePauses:  make(map[string]time.Time),
+	mutePolicies:  make(map[string]string),
+	scheduled:     make(map[string]scheduleState),
 }
 
 func main() {
-	// Scan and log audio input devices on startup
-	if err := scanAudioInputDevices(); err != nil {
-		log.Printf("Error scanning audio input devices: %v", err)
-		// Continue execution even if scanning fails
+	// Subcommands such as "devices" or "set" run the command-line interface
+	if len(os.Args) > 1 && isCLICommand(os.Args[1]) {
+		os.Exit(runCLI(os.Args[1:]))
 	}
 
-	// Load saved preferences and restore device states
-	loadAndApplyDeviceStates()
+	opts, err := parseRunOptions(os.Args[1:], flag.ExitOnError)
+	if err != nil {
+		os.Exit(2)
+	}
 
-	// Start the volume change listener
-	if err := startVolumeChangeListener(); err != nil {
-		log.Printf("Error starting volume change listener: %v", err)
-		log.Println("Volume change events will not be monitored")
-	} else {
-		log.Println("Volume change listener is active - changes will be logged")
+	// Only one instance may enforce at a time; pass our arguments to it
+	// instead, before opening the log file it writes to
+	lockErr := acquireInstanceLock()
+	if errors.Is(lockErr, errAlreadyRunning) {
+		os.Exit(handoffToRunningInstance(os.Args[1:]))
 	}
 
-	// Start the periodic volume enforcer
-	startPeriodicVolumeEnforcer()
+	if err := configureLogging(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "micmaxer: %v\n", err)
+		os.Exit(2)
+	}
+	if lockErr != nil {
+		slog.Warn("Failed to acquire instance lock", "error", lockErr)
+	}
+
+	if opts.headless {
+		os.Exit(runHeadless(opts))
+	}
+
+	// Start scanning, listening and enforcing before the tray takes over the main thread
+	startCore(context.Background(), opts)
 
 	// Run the app
 	systray.Run(onReady, onExit)
 }
 
-func onReady() {
-	// Load icon data
-	iconBytes, err := iconData.ReadFile("assets/icon.png")
-	if err != nil {
-		log.Printf("Error loading icon: %v", err)
-		panic(err)
-	}
+// runOptions holds the command-line options shared by the tray app and headless mode
+type runOptions struct {
+	headless    bool
+	httpAddr    string
+	metricsAddr string
+	logLevel    string
+	logFormat   string
+}
 
-	// Set the icon and tooltip
-	systray.SetIcon(iconBytes)
-	systray.SetTitle("")
-	systray.SetTooltip("MicMaxer")
+// parseRunOptions parses the application flags. It is also used to interpret
+// arguments forwarded by a second launch.
+func parseRunOptions(args []string, handling flag.ErrorHandling) (runOptions, error) {
+	var opts runOptions
+	fs := flag.NewFlagSet("micmaxer", handling)
+	if handling == flag.ContinueOnError {
+		// Forwarded arguments are reported to the second launch, not printed here
+		fs.SetOutput(io.Discard)
+	}
+	fs.BoolVar(&opts.headless, "headless", false, "run as a daemon without the menu bar icon")
+	fs.StringVar(&opts.httpAddr, "http", "", "serve the REST API on this loopback address (e.g. 127.0.0.1:8765)")
+	fs.StringVar(&opts.metricsAddr, "metrics", "", "serve Prometheus metrics at /metrics on this address (e.g. 127.0.0.1:9465, or :9465 for loopback)")
+	fs.StringVar(&opts.logLevel, "log-level", "", "log level: debug, info, warn or error (default info)")
+	fs.StringVar(&opts.logFormat, "log-format", "", "log format: text or json (default text)")
+	err := fs.Parse(args)
+	return opts, err
+}
 
-	// Create menu items
-	// Note: The systray library shows menu on both left and right click
-	// but we can't differentiate between them
+// startCore scans devices, restores saved preferences and starts the volume
+// change listener, periodic enforcer and control APIs. It is shared by the
+// tray app and headless mode; the enforcer stops when ctx is cancelled or
+// stopCore is called.
+func startCore(ctx context.Context, opts runOptions) {
+	// Scan and log audio input devices on startup
+	if err := scanAudioInputDevices(); err != nil {
+		slog.Error("Failed to scan audio input devices", "error", err)
+		// Continue execution even if scanning fails
+	}
 
-	// Add audio input devices section
-	state.mu.RLock()
-	devices := make([]malgo.DeviceInfo, len(state.audioInputDevices))
-	copy(devices, state.audioInputDevices)
-	state.mu.RUnlock()
+	// Size the event history and restore it if persisted
+	loadEventHistory()
 
-	if len(devices) > 0 {
-		systray.AddMenuItem("Audio Input Devices", "").Disable()
-		systray.AddSeparator()
+	// Load saved preferences and restore device states
+	loadDeviceTargets()
+	schedules.load()
+	loadAndApplyDeviceStates()
+	startConfiguredAGC()
+	alerts.start()
+	notifications.start()
+	ptt.start()
+	hotkeys.start(newHotkeyBackend(opts.headless))
+
+	// Serve the local control API for the CLI and scripts
+	if err := startControlServer(); err != nil {
+		slog.Error("Failed to start control API", "error", err)
+	}
 
-		// Add each audio device with toggle functionality
-		for _, device := range devices {
-			deviceID := device.ID.String()
+	// Export the service on the D-Bus session bus where available
+	if err := startDBusService(); err != nil {
+		slog.Warn("Failed to start D-Bus service", "error", err)
+	}
 
-			// Initialize device state (default to disabled)
-			state.mu.Lock()
-			if _, exists := state.deviceStates[deviceID]; !exists {
-				state.deviceStates[deviceID] = false
-			}
-			currentState := state.deviceStates[deviceID]
-			state.mu.Unlock()
-
-			// Create menu item with initial state
-			menuTitle := getDeviceMenuTitle(device.Name(), currentState)
-			deviceItem := systray.AddMenuItem(menuTitle, "Click to toggle")
-
-			// Handle clicks in a goroutine
-			go func(item *systray.MenuItem, id string, name string) {
-				for range item.ClickedCh {
-					// Toggle the state with proper locking
-					state.mu.Lock()
-					wasUnchecked := !state.deviceStates[id]
-					state.deviceStates[id] = !state.deviceStates[id]
-					newState := state.deviceStates[id]
-					state.mu.Unlock()
-
-					// Update the menu item title
-					newTitle := getDeviceMenuTitle(name, newState)
-					item.SetTitle(newTitle)
-
-					// Log the state change
-					log.Printf("Device '%s' toggled to: %v", name, newState)
-
-					// Save preferences
-					saveDeviceStates()
-
-					// If going from unchecked to checked, query and log the audio level, then set to 100%
-					if wasUnchecked && newState {
-						level, err := getAudioInputLevel(id)
-						if err != nil {
-							log.Printf("Error getting audio level for device '%s': %v", name, err)
-						} else {
-							log.Printf("Audio input level for device '%s': %d%%", name, level)
-						}
-
-						// Set the input level to target volume
-						if err := setSystemInputLevel(id, targetVolumeLevel); err != nil {
-							log.Printf("Error setting audio level to %d%% for device '%s': %v", int(targetVolumeLevel*100), name, err)
-						} else {
-							log.Printf("Successfully set audio level to %d%% for device '%s'", int(targetVolumeLevel*100), name)
-						}
-					}
-				}
-			}(deviceItem, deviceID, device.Name())
+	// Serve the optional HTTP API, from the command line or the config file
+	httpAddr := opts.httpAddr
+	if httpAddr == "" {
+		if cfg, err := loadConfig(); err == nil && cfg.HTTP != nil {
+			httpAddr = cfg.HTTP.Listen
 		}
+	}
+	if httpAddr != "" {
+		if err := startHTTPServer(httpAddr); err != nil {
+			slog.Error("Failed to start HTTP API", "error", err)
+		}
+	}
 
-		systray.AddSeparator()
+	// Serve the optional Prometheus metrics endpoint
+	metricsAddr := opts.metricsAddr
+	if metricsAddr == "" {
+		if cfg, err := loadConfig(); err == nil && cfg.Metrics != nil {
+			metricsAddr = cfg.Metrics.Listen
+		}
+	}
+	if metricsAddr != "" {
+		if err := startMetricsServer(metricsAddr); err != nil {
+			slog.Error("Failed to start metrics server", "error", err)
+		}
 	}
 
-	mQuit := systray.AddMenuItem("Quit", "Quit the application")
+	// Start the volume change listener
+	if err := startListener(); err != nil {
+		slog.Warn("Volume change listener unavailable - volume change events will not be monitored", "error", err)
+	} else {
+		slog.Info("Volume change listener is active")
+	}
 
-	// Handle menu item clicks in a goroutine
-	go func() {
-		for range mQuit.ClickedCh {
-			systray.Quit()
-		}
-	}()
+	// Start the periodic volume enforcer
+	startPeriodicVolumeEnforcer(ctx)
 }
 
-func onExit() {
+// The volume change listener run by the core; tests replace it with a fake
+var (
+	startListener = startVolumeChangeListener
+	stopListener  = stopVolumeChangeListener
+)
+
+// stopCore stops the periodic enforcer, waiting for it to finish its current
+// pass, and unregisters the volume change listener
+func stopCore() {
 	// Stop the periodic volume enforcer
 	state.mu.Lock()
-	if state.enforcerCancel != nil {
-		state.enforcerCancel()
-	}
+	cancel := state.enforcerCancel
+	done := state.enforcerDone
+	state.enforcerCancel = nil
+	state.enforcerDone = nil
 	state.mu.Unlock()
 
-	// Stop the volume change listener
-	if err := stopVolumeChangeListener(); err != nil {
-		log.Printf("Error stopping volume change listener: %v", err)
+	if cancel != nil {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(enforcerStopTimeout):
+			slog.Warn("Periodic volume enforcer did not stop in time", "timeout", enforcerStopTimeout.String())
+		}
 	}
 
-	// Cleanup tasks go here
-	log.Println("MicMaxer exited")
-}
+	// Stop automatic gain control and signal monitoring
+	agc.stopAll()
+	alerts.stop()
+	notifications.stop()
+	hotkeys.stop()
+	ptt.stop()
 
-// getDeviceMenuTitle returns the menu title with appropriate state indicator
-func getDeviceMenuTitle(deviceName string, enabled bool) string {
-	if enabled {
-		return "✓ " + deviceName
+	// Stop the volume change listener
+	if err := stopListener(); err != nil {
+		slog.Warn("Failed to stop volume change listener", "error", err)
 	}
-	return "   " + deviceName // Three spaces to align with checkmark
+
+	// Stop serving the control APIs
+	stopDBusService()
+	stopHTTPServer()
+	stopMetricsServer()
+	stopControlServer()
 }
 
-// hasSelectedDevice checks if any device is currently selected
-func hasSelectedDevice() bool {
-	state.mu.RLock()
-	defer state.mu.RUnlock()
+// trayMenu is the menu drawn by onReady
+var trayMenu *menuView
+
+func onReady() {
+	// Load the icon for the current state and keep it updated
+	if _, err := loadIcon(iconIdle); err != nil {
+		slog.Error("Failed to load icon", "error", err)
+		panic(err)
+	}
+	systray.SetTitle("")
+	icon.start()
 
-	for _, enabled := range state.deviceStates {
-		if enabled {
-			return true
+	// Show the input level indicator if it was left on
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+	}
+	if cfg.Tray != nil && cfg.Tray.VUMeter {
+		if err := vu.start(); err != nil {
+			slog.Error("Failed to show input level", "error", err)
 		}
 	}
-	return false
+
+	// Build the menu from the current state and keep it updated
+	// Note: The systray library shows menu on both left and right click
+	// but we can't differentiate between them
+	trayMenu = newMenuView(&systrayRenderer{}, appMenuActions{}, currentMenuSnapshot)
+	trayMenu.start()
+}
+
+func onExit() {
+	vu.stop()
+	if trayMenu != nil {
+		trayMenu.stop()
+	}
+	icon.stop()
+	stopCore()
+	releaseInstanceLock()
+
+	// Cleanup tasks go here
+	slog.Info("MicMaxer exited")
+	closeEventHistory()
+	closeLogFile()
 }
 
 // getAudioInputLevel reads the input volume level from the device settings (0-100)
@@ -203,10 +282,37 @@ func getAudioInputLevel(deviceID string) (int, error) {
 
 // scanAudioInputDevices scans and logs all available audio input devices
 func scanAudioInputDevices() error {
+	infos, err := listAudioInputDevices()
+	if err != nil {
+		return err
+	}
+
+	// Store the devices with proper locking
+	state.mu.Lock()
+	state.audioInputDevices = infos
+	state.mu.Unlock()
+
+	slog.Info("Scanned audio input devices", "count", len(infos))
+
+	for _, info := range infos {
+		slog.Info("Found audio input device",
+			"device", info.Name(), "device_id", info.ID.String(), "default", info.IsDefault != 0)
+	}
+
+	if len(infos) == 0 {
+		slog.Warn("No audio input devices found")
+	}
+
+	return nil
+}
+
+// listAudioInputDevices enumerates the available audio input devices without
+// logging or storing them
+func listAudioInputDevices() ([]malgo.DeviceInfo, error) {
 	// Initialize malgo context
 	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
 	if err != nil {
-		return fmt.Errorf("failed to initialize audio context: %w", err)
+		return nil, fmt.Errorf("failed to initialize audio context: %w", err)
 	}
 	defer func() {
 		_ = ctx.Uninit()
@@ -216,31 +322,24 @@ func scanAudioInputDevices() error {
 	// Get capture (input) devices
 	infos, err := ctx.Devices(malgo.Capture)
 	if err != nil {
-		return fmt.Errorf("failed to get capture devices: %w", err)
+		return nil, fmt.Errorf("failed to get capture devices: %w", err)
 	}
 
-	// Store the devices with proper locking
-	state.mu.Lock()
-	state.audioInputDevices = infos
-	state.mu.Unlock()
-
-	log.Println("=== Audio Input Devices ===")
-	log.Printf("Found %d audio input device(s):", len(infos))
+	return infos, nil
+}
 
-	for i, info := range infos {
-		log.Printf("  Device %d:", i+1)
-		log.Printf("    Name: %s", info.Name())
-		log.Printf("    ID: %s", info.ID.String())
-		log.Printf("    Is Default: %v", info.IsDefault != 0)
-	}
+// defaultInputDevice returns the ID and name of the default input device from
+// the last scan, or empty strings if it is unknown
+func defaultInputDevice() (string, string) {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
 
-	if len(infos) == 0 {
-		log.Println("  No audio input devices found")
+	for _, device := range state.audioInputDevices {
+		if device.IsDefault != 0 {
+			return device.ID.String(), device.Name()
+		}
 	}
-
-	log.Println("==========================")
-
-	return nil
+	return "", ""
 }
 
 // loadAndApplyDeviceStates loads saved device states from preferences and applies them
@@ -249,18 +348,31 @@ func loadAndApplyDeviceStates() {
 	// Load saved device IDs
 	savedDeviceIDs, err := loadCheckedDevices()
 	if err != nil {
-		log.Printf("Error loading saved device preferences: %v", err)
+		slog.Error("Failed to load saved device preferences", "error", err)
 		return
 	}
 
 	if len(savedDeviceIDs) == 0 {
-		log.Println("No saved device preferences found")
+		slog.Info("No saved device preferences found")
 		return
 	}
 
-	log.Printf("Loaded %d saved device preference(s)", len(savedDeviceIDs))
+	slog.Info("Loaded saved device preferences", "count", len(savedDeviceIDs))
+
+	// Apply saved states to existing devices, reporting the outcomes once the
+	// lock is released since the tray icon reads the state
+	type applied struct {
+		deviceID, deviceName string
+		target               float32
+		err                  error
+	}
+	var results []applied
+	defer func() {
+		for _, r := range results {
+			reportEnforcementResult(r.deviceID, r.deviceName, r.target, r.err)
+		}
+	}()
 
-	// Apply saved states to existing devices
 	state.mu.Lock()
 	defer state.mu.Unlock()
 
@@ -279,16 +391,30 @@ func loadAndApplyDeviceStates() {
 		if deviceExists {
 			// Mark device as checked
 			state.deviceStates[savedID] = true
-			log.Printf("Restored checked state for device '%s'", deviceName)
+			slog.Info("Restored enforcement for device", "device", deviceName, "device_id", savedID)
+
+			// Leave devices outside their scheduled windows alone
+			if current, ok := schedules.at(savedID); ok {
+				state.scheduled[savedID] = current
+				if current.released {
+					slog.Info("Device released by its schedule", "device", deviceName, "device_id", savedID)
+					continue
+				}
+			}
 
 			// Set the input level to target volume
-			if err := setSystemInputLevel(savedID, targetVolumeLevel); err != nil {
-				log.Printf("Error setting audio level to %d%% for device '%s': %v", int(targetVolumeLevel*100), deviceName, err)
+			target := state.targetLocked(savedID)
+			err := setSystemInputLevel(savedID, target)
+			results = append(results, applied{savedID, deviceName, target, err})
+			if err != nil {
+				slog.Error("Failed to set audio level",
+					"device", deviceName, "device_id", savedID, "new_volume", volumePercent(target), "error", err)
 			} else {
-				log.Printf("Successfully set audio level to %d%% for device '%s'", int(targetVolumeLevel*100), deviceName)
+				slog.Debug("Set audio level",
+					"device", deviceName, "device_id", savedID, "new_volume", volumePercent(target))
 			}
 		} else {
-			log.Printf("Saved device ID '%s' no longer exists on the system", savedID)
+			slog.Info("Saved device no longer exists on the system", "device_id", savedID)
 		}
 	}
 }
@@ -307,32 +433,62 @@ func saveDeviceStates() {
 
 	// Save to preferences
 	saveCheckedDevices(checkedDeviceIDs)
-	log.Printf("Saved %d checked device(s) to preferences", len(checkedDeviceIDs))
+	slog.Debug("Saved checked devices to preferences", "count", len(checkedDeviceIDs))
 }
 
 // startPeriodicVolumeEnforcer starts a background goroutine that periodically
-// reapplies volume settings for all checked devices
-func startPeriodicVolumeEnforcer() {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// Store the cancel function
+// reapplies volume settings for all checked devices, rescans for connected or
+// disconnected devices and opens and closes scheduled enforcement windows
+// until parent is cancelled
+func startPeriodicVolumeEnforcer(parent context.Context) {
+	ctx, cancel := context.WithCancel(parent)
+	done := make(chan struct{})
+
+	// Store the cancel function and completion channel
 	state.mu.Lock()
 	state.enforcerCancel = cancel
+	state.enforcerDone = done
 	state.mu.Unlock()
 
 	go func() {
+		defer close(done)
 		ticker := time.NewTicker(volumeEnforcerInterval)
 		defer ticker.Stop()
 
-		log.Printf("Started periodic volume enforcer - will reapply settings every %v", volumeEnforcerInterval)
+		scanTicker := time.NewTicker(deviceScanInterval)
+		defer scanTicker.Stop()
+
+		// Wake up when the next schedule window opens or closes, and
+		// re-evaluate on every pass in case the clock jumped or the
+		// schedules were edited
+		scheduleTimer := time.NewTimer(0)
+		defer scheduleTimer.Stop()
+		evaluateSchedules := func() bool {
+			schedules.reload()
+			next, enforce := schedules.evaluate()
+			if !next.IsZero() {
+				scheduleTimer.Reset(max(next.Sub(schedules.clock.Now()), time.Second))
+			}
+			return enforce
+		}
+
+		slog.Info("Started periodic volume enforcer", "interval", volumeEnforcerInterval.String())
 
 		for {
 			select {
 			case <-ctx.Done():
-				log.Println("Stopping periodic volume enforcer")
+				slog.Info("Stopping periodic volume enforcer")
 				return
 			case <-ticker.C:
+				evaluateSchedules()
 				enforceVolumeSettings()
+			case <-scanTicker.C:
+				refreshAudioInputDevices()
+			case <-scheduleTimer.C:
+				// Apply the target of a window as soon as it opens
+				if evaluateSchedules() {
+					enforceVolumeSettings()
+				}
 			}
 		}
 	}()
@@ -341,32 +497,68 @@ func startPeriodicVolumeEnforcer() {
 // enforceVolumeSettings reapplies volume settings for all checked devices
 func enforceVolumeSettings() {
 	state.mu.RLock()
+	if state.paused {
+		state.mu.RUnlock()
+		return
+	}
+
+	start := time.Now()
+	defer func() { metrics.observeEnforcerLoop(time.Since(start)) }()
+
 	// Create a copy of checked devices to avoid holding the lock during I/O operations
 	checkedDevices := make(map[string]string)
+	targets := make(map[string]float32)
 	for deviceID, checked := range state.deviceStates {
-		if checked {
+		if checked && !state.pausedLocked(deviceID) {
 			// Find the device name for logging
-			deviceName := "Unknown"
-			for _, device := range state.audioInputDevices {
-				if device.ID.String() == deviceID {
-					deviceName = device.Name()
-					break
-				}
-			}
-			checkedDevices[deviceID] = deviceName
+			checkedDevices[deviceID] = state.deviceNameLocked(deviceID)
+			targets[deviceID] = state.targetLocked(deviceID)
 		}
 	}
 	state.mu.RUnlock()
 
 	// Apply volume settings without holding the lock
 	for deviceID, deviceName := range checkedDevices {
+		target := ptt.volume(targets[deviceID])
+
+		// Devices under automatic gain control are not pinned to a target
+		if agc.active(deviceID) {
+			continue
+		}
+
+		// Read the current level first so real corrections can be reported
+		level, levelErr := getSystemInputLevel(deviceID)
+
 		// Set the input level to target volume
-		if err := setSystemInputLevel(deviceID, targetVolumeLevel); err != nil {
-			log.Printf("Periodic enforcer: Error setting audio level to %d%% for device '%s': %v",
-				int(targetVolumeLevel*100), deviceName, err)
+		err := setSystemInputLevel(deviceID, target)
+		reportEnforcementResult(deviceID, deviceName, target, err)
+		if err != nil {
+			slog.Error("Failed to reapply audio level",
+				"device", deviceName, "device_id", deviceID, "new_volume", volumePercent(target),
+				"source", sourceEnforcer, "error", err)
+			continue
+		}
+
+		if levelErr == nil && volumeDiffers(float32(level)/100, target) {
+			app := volumeChanger()
+			slog.Info("Corrected audio level",
+				"device", deviceName, "device_id", deviceID, "old_volume", level, "new_volume", volumePercent(target),
+				"source", sourceEnforcer, "app", app)
+
+			publishCorrection(appEvent{
+				DeviceID:   deviceID,
+				DeviceName: deviceName,
+				Volume:     level,
+				Target:     volumePercent(target),
+				Source:     sourceEnforcer,
+				App:        app,
+			})
 		} else {
-			log.Printf("Periodic enforcer: Successfully reapplied %d%% audio level for device '%s'",
-				int(targetVolumeLevel*100), deviceName)
+			slog.Debug("Reapplied audio level",
+				"device", deviceName, "device_id", deviceID, "new_volume", volumePercent(target),
+				"source", sourceEnforcer)
 		}
+
+		enforceMutePolicy(deviceID, sourceEnforcer)
 	}
 }
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// menuRefreshDelay groups bursts of events into a single menu refresh
+const menuRefreshDelay = 200 * time.Millisecond
+
+// menuSpareDeviceSlots is how many hidden device entries the menu keeps for
+// devices connected later, since the tray cannot insert items between others
+const menuSpareDeviceSlots = 4
+
+// Volume targets offered in a device's submenu, percent
+var targetPresets = []int{50, 70, 85, 100}
+
+// Mute policies offered in a device's submenu
+var mutePolicyChoices = []struct {
+	label  string
+	policy string
+}{
+	{"Leave Alone", mutePolicyAllow},
+	{"Keep Unmuted", mutePolicyUnmuted},
+	{"Keep Muted", mutePolicyMuted},
+}
+
+// menuItem describes one entry of the tray menu. The menu is regenerated
+// from the application state whenever it changes; separators are only
+// supported at the top level.
+type menuItem struct {
+	Title     string
+	Tooltip   string
+	Checkbox  bool
+	Checked   bool
+	Disabled  bool
+	Hidden    bool
+	Separator bool
+	Children  []*menuItem
+	Action    func()
+}
+
+// menuSnapshot is the application state a menu is built from
+type menuSnapshot struct {
+	Status        appStatus
+	InputLevel    bool // input level indicator shown next to the icon
+	Notifications notificationsConfig
+}
+
+// menuActions carries out the commands chosen from the menu
+type menuActions interface {
+	setEnforced(deviceID string, enforced bool)
+	setTarget(deviceID string, percent int) error
+	setMutePolicy(deviceID, policy string) error
+	pause(deviceID string, d time.Duration)
+	resume(deviceID string)
+	makeDefault(deviceID string) error
+	prompt(title, prompt, defaultValue string) (string, error)
+	rename(deviceID, alias string) error
+	setInputLevel(enabled bool) error
+	setNotifications(t eventType, enabled bool) error
+	setPushToTalkMode(mode string) error
+	showLogs() error
+	quit()
+}
+
+// currentMenuSnapshot collects the state the tray menu shows
+func currentMenuSnapshot() menuSnapshot {
+	return menuSnapshot{
+		Status:        currentStatus(),
+		InputLevel:    vu.running(),
+		Notifications: notifications.current(),
+	}
+}
+
+// buildMenu returns the menu for a snapshot, padded with hidden entries to at
+// least deviceSlots devices, with items invoking actions when chosen
+func buildMenu(s menuSnapshot, actions menuActions, deviceSlots int) []*menuItem {
+	header := &menuItem{Title: "Audio Input Devices", Disabled: true}
+	if len(s.Status.Devices) == 0 {
+		header.Title = "No Audio Input Devices"
+	}
+	items := []*menuItem{header, {Separator: true}}
+
+	for _, d := range s.Status.Devices {
+		items = append(items, deviceMenu(d, actions))
+	}
+	for i := len(s.Status.Devices); i < deviceSlots; i++ {
+		placeholder := deviceMenu(deviceStatus{}, actions)
+		placeholder.Hidden = true
+		items = append(items, placeholder)
+	}
+
+	items = append(items,
+		&menuItem{Separator: true},
+		pauseMenu("Pause Enforcement", "", s.Status.Paused, s.Status.PausedUntil, actions),
+		pushToTalkMenu(s.Status.PushToTalk, actions),
+		&menuItem{Separator: true},
+		notificationsMenu(s.Notifications, actions),
+		&menuItem{
+			Title:    "Show Input Level",
+			Tooltip:  "Show the level of the default input device next to the icon",
+			Checkbox: true,
+			Checked:  s.InputLevel,
+			Action: func() {
+				if err := actions.setInputLevel(!s.InputLevel); err != nil {
+					slog.Error("Failed to toggle input level indicator", "error", err)
+				}
+			},
+		},
+		&menuItem{
+			Title:   "Show Logs",
+			Tooltip: "Open the log file",
+			Action: func() {
+				if err := actions.showLogs(); err != nil {
+					slog.Error("Failed to show logs", "error", err)
+				}
+			},
+		},
+		&menuItem{Title: "Quit", Tooltip: "Quit the application", Action: func() { actions.quit() }},
+	)
+	return items
+}
+
+// pushToTalkMenu returns the submenu choosing the push-to-talk mode, titled
+// with whether the microphones are live
+func pushToTalkMenu(p *pushToTalkStatus, actions menuActions) *menuItem {
+	mode := pttModeOff
+	if p != nil {
+		mode = p.Mode
+	}
+
+	m := &menuItem{
+		Title:   "Push to Talk",
+		Tooltip: "Keep enforced microphones silenced except while the push-to-talk key is held",
+	}
+	switch {
+	case p == nil:
+	case p.Silenced:
+		m.Title = "Push to Talk: Silenced"
+	default:
+		m.Title = "Push to Talk: Live"
+	}
+	for _, choice := range pttModeChoices {
+		m.Children = append(m.Children, &menuItem{
+			Title:    choice.label,
+			Checkbox: true,
+			Checked:  choice.mode == mode,
+			Action: func() {
+				if err := actions.setPushToTalkMode(choice.mode); err != nil {
+					slog.Error("Failed to change push-to-talk mode", "mode", choice.mode, "error", err)
+				}
+			},
+		})
+	}
+	return m
+}
+
+// notificationsMenu returns the submenu turning desktop notifications on or
+// off, overall and per event type
+func notificationsMenu(settings notificationsConfig, actions menuActions) *menuItem {
+	toggle := func(t eventType, enabled bool) func() {
+		return func() {
+			if err := actions.setNotifications(t, enabled); err != nil {
+				slog.Error("Failed to change notification settings", "event", t, "error", err)
+			}
+		}
+	}
+
+	m := &menuItem{
+		Title: "Notifications",
+		Children: []*menuItem{{
+			Title:    "Show Notifications",
+			Checkbox: true,
+			Checked:  settings.Enabled,
+			Action:   toggle("", !settings.Enabled),
+		}},
+	}
+	for _, e := range notifiableEvents {
+		shown := settings.shows(e.typ)
+		m.Children = append(m.Children, &menuItem{
+			Title:    e.label,
+			Checkbox: true,
+			Checked:  shown,
+			Disabled: !settings.Enabled,
+			Action:   toggle(e.typ, !shown),
+		})
+	}
+	return m
+}
+
+// getDeviceMenuTitle returns the menu title with appropriate state indicator
+// and the device's current volume or mute state
+func getDeviceMenuTitle(status deviceStatus) string {
+	name := status.Name
+	if status.Alias != "" {
+		name = status.Alias
+	}
+	title := "   " + name // Three spaces to align with checkmark
+	if status.Enforced {
+		title = "✓ " + name
+	}
+
+	switch {
+	case status.Muted != nil && *status.Muted:
+		title += " — muted"
+	case status.Volume != nil:
+		title += fmt.Sprintf(" — %d%%", *status.Volume)
+	}
+	if status.Paused {
+		title += " (paused)"
+	}
+	if status.Schedule != nil && !status.Schedule.Active {
+		title += " (off schedule)"
+	}
+	return title
+}
+
+// deviceMenu returns the submenu of a device. Every device gets the same
+// entries, disabled where they do not apply, so the menu keeps its shape
+// as settings change.
+func deviceMenu(d deviceStatus, actions menuActions) *menuItem {
+	id := d.ID
+
+	enforce := &menuItem{
+		Title:    fmt.Sprintf("Enforce at %d%%", d.Target),
+		Checkbox: true,
+		Checked:  d.Enforced,
+		Action:   func() { actions.setEnforced(id, !d.Enforced) },
+	}
+	if d.AGC {
+		enforce.Title = "Enforce with Automatic Gain Control"
+	}
+
+	target := &menuItem{Title: fmt.Sprintf("Target Volume: %d%%", d.Target)}
+	custom := true
+	for _, percent := range targetPresets {
+		target.Children = append(target.Children, &menuItem{
+			Title:    fmt.Sprintf("%d%%", percent),
+			Checkbox: true,
+			Checked:  percent == d.Target,
+			Action: func() {
+				if err := actions.setTarget(id, percent); err != nil {
+					slog.Error("Failed to set target volume", "device_id", id, "error", err)
+				}
+			},
+		})
+		custom = custom && percent != d.Target
+	}
+	target.Children = append(target.Children, &menuItem{
+		Title:    fmt.Sprintf("%d%% (custom)", d.Target),
+		Checkbox: true,
+		Checked:  true,
+		Disabled: true,
+		Hidden:   !custom,
+	})
+
+	policy := d.MutePolicy
+	if policy == "" {
+		policy = mutePolicyAllow
+	}
+	mute := &menuItem{Title: "Mute"}
+	for _, choice := range mutePolicyChoices {
+		if choice.policy == policy {
+			mute.Title = "Mute: " + choice.label
+		}
+		mute.Children = append(mute.Children, &menuItem{
+			Title:    choice.label,
+			Checkbox: true,
+			Checked:  choice.policy == policy,
+			Disabled: d.Muted == nil && choice.policy != policy,
+			Action: func() {
+				if err := actions.setMutePolicy(id, choice.policy); err != nil {
+					slog.Error("Failed to set mute policy", "device_id", id, "error", err)
+				}
+			},
+		})
+	}
+
+	makeDefault := &menuItem{
+		Title:    "Make Default Input",
+		Checkbox: true,
+		Checked:  d.Default,
+		Disabled: d.Default,
+		Action: func() {
+			if err := actions.makeDefault(id); err != nil {
+				slog.Error("Failed to change default input device", "device_id", id, "error", err)
+			}
+		},
+	}
+
+	rename := &menuItem{
+		Title:   "Rename…",
+		Tooltip: "Set the name this device is shown and selected by",
+		Action:  func() { renameFromMenu(d, actions) },
+	}
+
+	scheduleInfo := &menuItem{Title: "Schedule", Disabled: true, Hidden: d.Schedule == nil}
+	if d.Schedule != nil {
+		scheduleInfo.Title = "Schedule: " + describeSchedule(d.Schedule.Active, d.Schedule.Target, d.Schedule.Until, time.Now())
+	}
+
+	volumeInfo := "Volume Control: not supported"
+	if d.Volume != nil {
+		volumeInfo = "Volume Control: supported"
+	}
+	muteInfo := "Mute Control: not supported"
+	if d.Muted != nil {
+		muteInfo = "Mute Control: supported"
+	}
+
+	return &menuItem{
+		Title:   getDeviceMenuTitle(d),
+		Tooltip: d.Name,
+		Children: []*menuItem{
+			enforce,
+			target,
+			mute,
+			pauseMenu("Pause", id, d.Paused, d.PausedUntil, actions),
+			makeDefault,
+			rename,
+			scheduleInfo,
+			{Title: volumeInfo, Disabled: true},
+			{Title: muteInfo, Disabled: true},
+			{Title: "ID: " + id, Disabled: true},
+		},
+	}
+}
+
+// pauseMenu returns a submenu pausing a device, or all devices if deviceID is
+// empty, for each pause length, with a resume item showing when the pause ends
+func pauseMenu(title, deviceID string, paused bool, until *time.Time, actions menuActions) *menuItem {
+	m := &menuItem{Title: title, Tooltip: "Stop restoring volumes for a while"}
+	for _, p := range pauseDurations {
+		m.Children = append(m.Children, &menuItem{
+			Title:  p.label,
+			Action: func() { actions.pause(deviceID, p.duration) },
+		})
+	}
+
+	resume := &menuItem{
+		Title:    "Resume",
+		Disabled: !paused,
+		Action:   func() { actions.resume(deviceID) },
+	}
+	if paused {
+		resume.Title = "Resume (paused " + pauseUntilLabel(until) + ")"
+	}
+	m.Children = append(m.Children, resume)
+	return m
+}
+
+// renameFromMenu asks for a device's new alias; entering the device's own
+// name or nothing removes the alias
+func renameFromMenu(d deviceStatus, actions menuActions) {
+	current := d.Alias
+	if current == "" {
+		current = d.Name
+	}
+	alias, err := actions.prompt("Rename Device", "Name for "+d.Name+":", current)
+	if errors.Is(err, errPromptCancelled) {
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to ask for device name", "device_id", d.ID, "error", err)
+		return
+	}
+
+	if strings.TrimSpace(alias) == d.Name {
+		alias = ""
+	}
+	if err := actions.rename(d.ID, alias); err != nil {
+		slog.Error("Failed to rename device", "device_id", d.ID, "error", err)
+	}
+}
+
+// menuShape summarises the structure of a menu, which must match for a
+// rendered menu to be updated in place
+func menuShape(items []*menuItem) string {
+	var b strings.Builder
+	for _, m := range items {
+		switch {
+		case m.Separator:
+			b.WriteByte('-')
+		case m.Checkbox:
+			b.WriteByte('c')
+		default:
+			b.WriteByte('i')
+		}
+		if len(m.Children) > 0 {
+			b.WriteString("(" + menuShape(m.Children) + ")")
+		}
+	}
+	return b.String()
+}
+
+// menuRenderer draws menu items, such as in the system tray
+type menuRenderer interface {
+	// add draws an item below parent, or at the top level if parent is nil,
+	// and calls clicked whenever the item is chosen
+	add(parent menuEntry, m *menuItem, clicked func()) menuEntry
+	// addSeparator draws a separator at the top level
+	addSeparator()
+	// clear hides everything drawn so far, before the menu is drawn afresh
+	clear()
+}
+
+// menuEntry is an item drawn by a menuRenderer
+type menuEntry interface {
+	setTitle(title string)
+	setTooltip(tooltip string)
+	setChecked(checked bool)
+	setDisabled(disabled bool)
+	setHidden(hidden bool)
+}
+
+// menuNode is a drawn menu item together with the model it shows
+type menuNode struct {
+	entry    menuEntry // nil for separators
+	shown    menuItem
+	children []*menuNode
+}
+
+// menuView keeps a rendered menu in line with the application state,
+// updating drawn items in place and drawing the menu afresh only when its
+// shape changes
+type menuView struct {
+	renderer menuRenderer
+	actions  menuActions
+	snapshot func() menuSnapshot
+
+	mu          sync.Mutex
+	slots       int // device entries, including hidden spares
+	shape       string
+	nodes       []*menuNode
+	pending     bool
+	unsubscribe func()
+}
+
+// newMenuView returns a view drawing the menu for snapshots with renderer
+// and carrying out chosen items with actions
+func newMenuView(renderer menuRenderer, actions menuActions, snapshot func() menuSnapshot) *menuView {
+	return &menuView{renderer: renderer, actions: actions, snapshot: snapshot}
+}
+
+// start draws the menu and keeps it updated from events
+func (v *menuView) start() {
+	v.refresh()
+
+	ch, unsubscribe := events.subscribe(32)
+	v.mu.Lock()
+	v.unsubscribe = unsubscribe
+	v.mu.Unlock()
+	go func() {
+		for range ch {
+			v.scheduleRefresh()
+		}
+	}()
+}
+
+// stop ends the view's subscription so events no longer refresh the menu
+func (v *menuView) stop() {
+	v.mu.Lock()
+	unsubscribe := v.unsubscribe
+	v.unsubscribe = nil
+	v.mu.Unlock()
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+}
+
+// scheduleRefresh refreshes the menu shortly, once for a burst of calls
+func (v *menuView) scheduleRefresh() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.pending {
+		return
+	}
+	v.pending = true
+	time.AfterFunc(menuRefreshDelay, func() {
+		v.mu.Lock()
+		v.pending = false
+		v.mu.Unlock()
+		v.refresh()
+	})
+}
+
+// refresh regenerates the menu from a new snapshot and draws the changes
+func (v *menuView) refresh() {
+	s := v.snapshot()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(s.Status.Devices) > v.slots {
+		v.slots = len(s.Status.Devices) + menuSpareDeviceSlots
+	}
+	items := buildMenu(s, v.actions, v.slots)
+
+	if shape := menuShape(items); shape != v.shape {
+		// This only happens at startup and when more devices appear than
+		// were reserved
+		v.renderer.clear()
+		v.nodes = v.nodes[:0]
+		for _, m := range items {
+			v.nodes = append(v.nodes, v.add(nil, m))
+		}
+		v.shape = shape
+		return
+	}
+
+	for i, m := range items {
+		v.nodes[i].update(m)
+	}
+}
+
+// add draws a model item below parent, or at the top level if parent is nil
+func (v *menuView) add(parent menuEntry, m *menuItem) *menuNode {
+	node := &menuNode{shown: *m}
+	if m.Separator {
+		v.renderer.addSeparator()
+		return node
+	}
+
+	node.entry = v.renderer.add(parent, m, func() { v.click(node) })
+	for _, child := range m.Children {
+		node.children = append(node.children, v.add(node.entry, child))
+	}
+	return node
+}
+
+// click runs the action a drawn item currently stands for
+func (v *menuView) click(node *menuNode) {
+	v.mu.Lock()
+	action := node.shown.Action
+	v.mu.Unlock()
+
+	if action != nil {
+		action()
+		v.refresh()
+	}
+}
+
+// update applies the differences between a drawn item and its new model
+func (n *menuNode) update(m *menuItem) {
+	old := n.shown
+	n.shown = *m
+	if n.entry == nil {
+		return
+	}
+
+	if m.Title != old.Title {
+		n.entry.setTitle(m.Title)
+	}
+	if m.Tooltip != old.Tooltip {
+		n.entry.setTooltip(m.Tooltip)
+	}
+	if m.Checked != old.Checked {
+		n.entry.setChecked(m.Checked)
+	}
+	if m.Disabled != old.Disabled {
+		n.entry.setDisabled(m.Disabled)
+	}
+	if m.Hidden != old.Hidden {
+		n.entry.setHidden(m.Hidden)
+	}
+	for i, child := range m.Children {
+		n.children[i].update(child)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMenuActions records the actions chosen from a menu and applies the
+// ones that change what the menu shows to its snapshot
+type fakeMenuActions struct {
+	mu       sync.Mutex
+	calls    []string
+	snapshot menuSnapshot
+}
+
+func (f *fakeMenuActions) record(format string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeMenuActions) current() menuSnapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.snapshot
+}
+
+func (f *fakeMenuActions) setEnforced(deviceID string, enforced bool) {
+	f.record("setEnforced %s %v", deviceID, enforced)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.snapshot.Status.Devices {
+		if f.snapshot.Status.Devices[i].ID == deviceID {
+			f.snapshot.Status.Devices[i].Enforced = enforced
+		}
+	}
+}
+
+func (f *fakeMenuActions) setTarget(deviceID string, percent int) error {
+	f.record("setTarget %s %d", deviceID, percent)
+	return nil
+}
+
+func (f *fakeMenuActions) setMutePolicy(deviceID, policy string) error {
+	f.record("setMutePolicy %s %s", deviceID, policy)
+	return nil
+}
+
+func (f *fakeMenuActions) pause(deviceID string, d time.Duration) {
+	f.record("pause %q %s", deviceID, d)
+}
+
+func (f *fakeMenuActions) resume(deviceID string) { f.record("resume %q", deviceID) }
+
+func (f *fakeMenuActions) makeDefault(deviceID string) error {
+	f.record("makeDefault %s", deviceID)
+	return nil
+}
+
+func (f *fakeMenuActions) prompt(title, prompt, defaultValue string) (string, error) {
+	f.record("prompt %q %q", prompt, defaultValue)
+	return "Podcast", nil
+}
+
+func (f *fakeMenuActions) rename(deviceID, alias string) error {
+	f.record("rename %s %q", deviceID, alias)
+	return nil
+}
+
+func (f *fakeMenuActions) setInputLevel(enabled bool) error {
+	f.record("setInputLevel %v", enabled)
+	return nil
+}
+
+func (f *fakeMenuActions) setNotifications(t eventType, enabled bool) error {
+	f.record("setNotifications %q %v", t, enabled)
+	return nil
+}
+
+func (f *fakeMenuActions) setPushToTalkMode(mode string) error {
+	f.record("setPushToTalkMode %q", mode)
+	return nil
+}
+
+func (f *fakeMenuActions) showLogs() error {
+	f.record("showLogs")
+	return nil
+}
+
+func (f *fakeMenuActions) quit() { f.record("quit") }
+
+// testMenuSnapshot has an enforced USB microphone and an unenforced
+// built-in one that is muted
+func testMenuSnapshot() menuSnapshot {
+	volume, muted, unmuted := 80, true, false
+	return menuSnapshot{
+		Status: appStatus{Devices: []deviceStatus{
+			{ID: "usb", Name: "USB Microphone", Default: true, Enforced: true, Target: 80, MutePolicy: mutePolicyUnmuted, Volume: &volume, Muted: &unmuted},
+			{ID: "builtin", Name: "Built-in Microphone", Alias: "laptop", Target: 100, Volume: &volume, Muted: &muted},
+		}},
+		Notifications: notificationsConfig{Enabled: true},
+	}
+}
+
+// findEntry returns the entry reached through the titles of a textMenu,
+// matching each title by prefix
+func findEntry(t *testing.T, m *textMenu, path ...string) *textMenuEntry {
+	t.Helper()
+	entries := m.entries
+	var found *textMenuEntry
+	for _, title := range path {
+		found = nil
+		for _, e := range entries {
+			if !e.Hidden && !e.Separator && strings.HasPrefix(e.Title, title) {
+				found = e
+				break
+			}
+		}
+		if found == nil {
+			t.Fatalf("no menu item %q in %q", title, path)
+		}
+		entries = found.children
+	}
+	return found
+}
+
+const wantTestMenu = `Audio Input Devices (disabled)
+────
+✓ USB Microphone — 80%
+    [x] Enforce at 80%
+    Target Volume: 80%
+        [ ] 50%
+        [ ] 70%
+        [ ] 85%
+        [ ] 100%
+        [x] 80% (custom) (disabled)
+    Mute: Keep Unmuted
+        [ ] Leave Alone
+        [x] Keep Unmuted
+        [ ] Keep Muted
+    Pause
+        For 5 Minutes
+        For 1 Hour
+        Until Resumed
+        Resume (disabled)
+    [x] Make Default Input (disabled)
+    Rename…
+    Volume Control: supported (disabled)
+    Mute Control: supported (disabled)
+    ID: usb (disabled)
+   laptop — muted
+    [ ] Enforce at 100%
+    Target Volume: 100%
+        [ ] 50%
+        [ ] 70%
+        [ ] 85%
+        [x] 100%
+    Mute: Leave Alone
+        [x] Leave Alone
+        [ ] Keep Unmuted
+        [ ] Keep Muted
+    Pause
+        For 5 Minutes
+        For 1 Hour
+        Until Resumed
+        Resume (disabled)
+    [ ] Make Default Input
+    Rename…
+    Volume Control: supported (disabled)
+    Mute Control: supported (disabled)
+    ID: builtin (disabled)
+────
+Pause Enforcement
+    For 5 Minutes
+    For 1 Hour
+    Until Resumed
+    Resume (disabled)
+Push to Talk
+    [x] Off
+    [ ] Push to Talk
+    [ ] Push to Mute
+────
+Notifications
+    [x] Show Notifications
+    [x] Volume Restored
+    [x] Volume Conflicts
+    [x] Enforcement Failures
+    [x] Device Connected
+    [ ] Device Disconnected
+    [ ] Pause Ended
+    [x] Silent Microphone
+    [x] Clipping
+[ ] Show Input Level
+Show Logs
+Quit
+`
+
+func TestMenuText(t *testing.T) {
+	var menu textMenu
+	newMenuView(&menu, &fakeMenuActions{}, testMenuSnapshot).refresh()
+
+	if got := menu.String(); got != wantTestMenu {
+		t.Errorf("menu differs:\n%s\nwant:\n%s", got, wantTestMenu)
+	}
+}
+
+func TestMenuClick(t *testing.T) {
+	actions := &fakeMenuActions{snapshot: testMenuSnapshot()}
+	var menu textMenu
+	view := newMenuView(&menu, actions, actions.current)
+	view.refresh()
+
+	tests := []struct {
+		path []string
+		want string
+	}{
+		{[]string{"✓ USB Microphone", "Target Volume", "50%"}, "setTarget usb 50"},
+		{[]string{"✓ USB Microphone", "Mute", "Keep Muted"}, "setMutePolicy usb muted"},
+		{[]string{"   laptop", "Make Default Input"}, "makeDefault builtin"},
+		{[]string{"   laptop", "Pause", "For 1 Hour"}, `pause "builtin" 1h0m0s`},
+		{[]string{"Pause Enforcement", "For 5 Minutes"}, `pause "" 5m0s`},
+		{[]string{"Push to Talk", "Push to Mute"}, `setPushToTalkMode "mute"`},
+		{[]string{"Notifications", "Device Disconnected"}, `setNotifications "device_removed" true`},
+		{[]string{"Show Input Level"}, "setInputLevel true"},
+		{[]string{"Quit"}, "quit"},
+	}
+	for _, tt := range tests {
+		actions.calls = nil
+		findEntry(t, &menu, tt.path...).clicked()
+		if len(actions.calls) != 1 || actions.calls[0] != tt.want {
+			t.Errorf("clicking %q called %q, want %q", tt.path, actions.calls, tt.want)
+		}
+	}
+
+	// Renaming asks for the name first
+	actions.calls = nil
+	findEntry(t, &menu, "   laptop", "Rename").clicked()
+	if want := []string{`prompt "Name for Built-in Microphone:" "laptop"`, `rename builtin "Podcast"`}; strings.Join(actions.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("renaming called %q, want %q", actions.calls, want)
+	}
+
+	// The menu is updated in place after an action
+	actions.calls = nil
+	findEntry(t, &menu, "   laptop", "Enforce at 100%").clicked()
+	if len(actions.calls) != 1 || actions.calls[0] != "setEnforced builtin true" {
+		t.Fatalf("enforcing called %q", actions.calls)
+	}
+	if e := findEntry(t, &menu, "✓ laptop", "Enforce at 100%"); !e.Checked {
+		t.Error("enforced device is not checked after the refresh")
+	}
+}
+
+func TestMenuViewStop(t *testing.T) {
+	before := subscribers()
+	var menu textMenu
+	view := newMenuView(&menu, &fakeMenuActions{}, testMenuSnapshot)
+	view.start()
+	if got := subscribers(); got != before+1 {
+		t.Fatalf("subscribers after start = %d, want %d", got, before+1)
+	}
+	view.stop()
+	view.stop()
+	if got := subscribers(); got != before {
+		t.Errorf("subscribers after stop = %d, want %d", got, before)
+	}
+}
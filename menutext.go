@@ -0,0 +1,76 @@
+package main
+
+import "strings"
+
+// textMenu is a menu renderer that keeps the menu in memory instead of the
+// tray, so the menu can be printed and its items clicked without a desktop
+type textMenu struct {
+	entries []*textMenuEntry
+}
+
+// textMenuEntry is a menu item held by a textMenu
+type textMenuEntry struct {
+	menuItem // drawn state; Children and Action are unused
+	children []*textMenuEntry
+	clicked  func()
+}
+
+func (t *textMenu) add(parent menuEntry, m *menuItem, clicked func()) menuEntry {
+	e := &textMenuEntry{menuItem: *m, clicked: clicked}
+	e.Children, e.Action = nil, nil
+	if p, ok := parent.(*textMenuEntry); ok {
+		p.children = append(p.children, e)
+	} else {
+		t.entries = append(t.entries, e)
+	}
+	return e
+}
+
+func (t *textMenu) addSeparator() {
+	t.entries = append(t.entries, &textMenuEntry{menuItem: menuItem{Separator: true}})
+}
+
+func (t *textMenu) clear() {
+	t.entries = nil
+}
+
+func (e *textMenuEntry) setTitle(title string)     { e.Title = title }
+func (e *textMenuEntry) setTooltip(tooltip string) { e.Tooltip = tooltip }
+func (e *textMenuEntry) setChecked(checked bool)   { e.Checked = checked }
+func (e *textMenuEntry) setDisabled(disabled bool) { e.Disabled = disabled }
+func (e *textMenuEntry) setHidden(hidden bool)     { e.Hidden = hidden }
+
+// String prints the visible menu, one item per line, indenting submenus and
+// marking checkboxes and disabled items
+func (t *textMenu) String() string {
+	var b strings.Builder
+	writeTextMenu(&b, t.entries, 0)
+	return b.String()
+}
+
+// writeTextMenu prints entries at the given submenu depth
+func writeTextMenu(b *strings.Builder, entries []*textMenuEntry, depth int) {
+	for _, e := range entries {
+		if e.Hidden {
+			continue
+		}
+		b.WriteString(strings.Repeat("    ", depth))
+		if e.Separator {
+			b.WriteString("────\n")
+			continue
+		}
+		if e.Checkbox {
+			if e.Checked {
+				b.WriteString("[x] ")
+			} else {
+				b.WriteString("[ ] ")
+			}
+		}
+		b.WriteString(e.Title)
+		if e.Disabled {
+			b.WriteString(" (disabled)")
+		}
+		b.WriteString("\n")
+		writeTextMenu(b, e.children, depth+1)
+	}
+}
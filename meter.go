@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// Settings for input level metering
+const (
+	meterSampleRate    = 48000
+	defaultMeterWindow = 100 * time.Millisecond
+	meterFloorDBFS     = -120.0 // reported for digital silence
+	wavChunkFrames     = 1024
+)
+
+// levelReading summarises the input signal over one metering window
+type levelReading struct {
+	Time     time.Time `json:"time"`
+	Peak     float64   `json:"peak"` // 0.0-1.0 of full scale
+	RMS      float64   `json:"rms"`  // 0.0-1.0 of full scale
+	PeakDBFS float64   `json:"peak_dbfs"`
+	RMSDBFS  float64   `json:"rms_dbfs"`
+}
+
+// toDBFS converts a linear level relative to full scale to decibels
+func toDBFS(level float64) float64 {
+	if level <= 0 {
+		return meterFloorDBFS
+	}
+	return math.Max(20*math.Log10(level), meterFloorDBFS)
+}
+
+// levelMeter accumulates samples and reports a reading for every window of
+// interleaved samples
+type levelMeter struct {
+	window     int
+	count      int
+	peak       float64
+	sumSquares float64
+	emit       func(levelReading)
+}
+
+// newLevelMeter creates a meter reporting every window at the given sample
+// rate and channel count
+func newLevelMeter(window time.Duration, sampleRate, channels int, emit func(levelReading)) *levelMeter {
+	samples := int(window.Seconds() * float64(sampleRate*channels))
+	if samples < 1 {
+		samples = 1
+	}
+	return &levelMeter{window: samples, emit: emit}
+}
+
+// process adds samples in the range -1.0 to 1.0 to the current window
+func (m *levelMeter) process(samples []float32) {
+	for _, s := range samples {
+		v := math.Abs(float64(s))
+		if v > m.peak {
+			m.peak = v
+		}
+		m.sumSquares += v * v
+		m.count++
+
+		if m.count == m.window {
+			rms := math.Sqrt(m.sumSquares / float64(m.count))
+			m.emit(levelReading{
+				Time:     time.Now(),
+				Peak:     m.peak,
+				RMS:      rms,
+				PeakDBFS: toDBFS(m.peak),
+				RMSDBFS:  toDBFS(rms),
+			})
+			m.count, m.peak, m.sumSquares = 0, 0, 0
+		}
+	}
+}
+
+// sampleSource delivers input samples until ctx is cancelled or the source ends
+type sampleSource interface {
+	// format returns the sample rate and channel count of the samples
+	format() (sampleRate, channels int)
+	// run calls fn with interleaved float samples from the source
+	run(ctx context.Context, fn func([]float32)) error
+	// live reports whether samples arrive in real time, in which case
+	// readings are dropped rather than holding up the source
+	live() bool
+}
+
+// captureSource reads samples from an input device through malgo
+type captureSource struct {
+	deviceID *malgo.DeviceID // nil for the default device
+	backends []malgo.Backend // nil to let miniaudio choose
+}
+
+// newCaptureSource creates a source capturing from the scanned device with
+// the given ID, or the default device if id is empty
+func newCaptureSource(id string) (*captureSource, error) {
+	if id == "" {
+		return &captureSource{}, nil
+	}
+
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	for _, info := range state.audioInputDevices {
+		if info.ID.String() == id {
+			deviceID := info.ID
+			return &captureSource{deviceID: &deviceID}, nil
+		}
+	}
+	return nil, fmt.Errorf("no input device with ID %q", id)
+}
+
+// miniaudio's null backend. malgo's BackendNull constant omits
+// ma_backend_custom from the enumeration and actually selects the custom backend.
+const nullBackend = malgo.BackendNull + 1
+
+// newNullCaptureSource creates a source using miniaudio's null backend,
+// which captures silence without touching any hardware
+func newNullCaptureSource() *captureSource {
+	return &captureSource{backends: []malgo.Backend{nullBackend}}
+}
+
+func (s *captureSource) format() (int, int) {
+	return meterSampleRate, 1
+}
+
+func (s *captureSource) live() bool {
+	return true
+}
+
+func (s *captureSource) run(ctx context.Context, fn func([]float32)) error {
+	mctx, err := malgo.InitContext(s.backends, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to initialize audio context: %w", err)
+	}
+	defer func() {
+		_ = mctx.Uninit()
+		mctx.Free()
+	}()
+
+	config := malgo.DefaultDeviceConfig(malgo.Capture)
+	config.Capture.Format = malgo.FormatF32
+	config.Capture.Channels = 1
+	config.SampleRate = meterSampleRate
+	if s.deviceID != nil {
+		config.Capture.DeviceID = s.deviceID.Pointer()
+	}
+
+	// The data callback runs on the audio thread, so samples are decoded
+	// into a buffer reused between callbacks
+	var buf []float32
+	device, err := malgo.InitDevice(mctx.Context, config, malgo.DeviceCallbacks{
+		Data: func(_, input []byte, _ uint32) {
+			buf = decodeFloat32(buf[:0], input)
+			fn(buf)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open capture device: %w", err)
+	}
+	defer device.Uninit()
+
+	if err := device.Start(); err != nil {
+		return fmt.Errorf("failed to start capture: %w", err)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// decodeFloat32 appends little-endian 32-bit float samples from data to dst
+func decodeFloat32(dst []float32, data []byte) []float32 {
+	for i := 0; i+4 <= len(data); i += 4 {
+		dst = append(dst, math.Float32frombits(binary.LittleEndian.Uint32(data[i:])))
+	}
+	return dst
+}
+
+// wavSource reads samples from a PCM WAV file, for testing the meter with
+// known signals
+type wavSource struct {
+	r          io.ReadCloser
+	sampleRate int
+	channels   int
+	bits       int
+	float      bool
+	realtime   bool // pace the samples as if they were being captured
+}
+
+// openWAVSource opens a 16-bit, 24-bit or 32-bit integer or 32-bit float WAV file
+func openWAVSource(path string, realtime bool) (*wavSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &wavSource{r: f, realtime: realtime}
+	if err := s.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// readHeader parses the RIFF chunks up to the start of the sample data
+func (s *wavSource) readHeader() error {
+	var riff [12]byte
+	if _, err := io.ReadFull(s.r, riff[:]); err != nil {
+		return errors.New("not a WAV file")
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return errors.New("not a WAV file")
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(s.r, header[:]); err != nil {
+			return errors.New("no data chunk")
+		}
+		id := string(header[0:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		switch id {
+		case "fmt ":
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(s.r, chunk); err != nil || size < 16 {
+				return errors.New("invalid fmt chunk")
+			}
+			format := binary.LittleEndian.Uint16(chunk[0:2])
+			s.channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			s.sampleRate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+			s.bits = int(binary.LittleEndian.Uint16(chunk[14:16]))
+			if format == 0xFFFE && size >= 26 { // WAVE_FORMAT_EXTENSIBLE
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+			s.float = format == 3
+			if (format != 1 && format != 3) || s.channels < 1 || s.sampleRate < 1 {
+				return fmt.Errorf("unsupported WAV format %d", format)
+			}
+			if (s.float && s.bits != 32) || (!s.float && s.bits != 16 && s.bits != 24 && s.bits != 32) {
+				return fmt.Errorf("unsupported sample size of %d bits", s.bits)
+			}
+		case "data":
+			if s.channels == 0 {
+				return errors.New("data chunk before fmt chunk")
+			}
+			return nil
+		default:
+			if _, err := io.CopyN(io.Discard, s.r, size+size%2); err != nil {
+				return errors.New("no data chunk")
+			}
+		}
+	}
+}
+
+func (s *wavSource) format() (int, int) {
+	return s.sampleRate, s.channels
+}
+
+func (s *wavSource) live() bool {
+	return s.realtime
+}
+
+func (s *wavSource) run(ctx context.Context, fn func([]float32)) error {
+	defer s.r.Close()
+
+	bytesPerSample := s.bits / 8
+	chunk := make([]byte, wavChunkFrames*s.channels*bytesPerSample)
+	samples := make([]float32, 0, wavChunkFrames*s.channels)
+	chunkDuration := time.Duration(wavChunkFrames) * time.Second / time.Duration(s.sampleRate)
+
+	var ticker *time.Ticker
+	if s.realtime {
+		ticker = time.NewTicker(chunkDuration)
+		defer ticker.Stop()
+	}
+
+	for {
+		n, err := io.ReadFull(s.r, chunk)
+		n -= n % bytesPerSample
+		if n > 0 {
+			samples = s.decode(samples[:0], chunk[:n])
+			fn(samples)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if ticker != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		} else if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// decode converts raw samples to floats in the range -1.0 to 1.0
+func (s *wavSource) decode(dst []float32, data []byte) []float32 {
+	if s.float {
+		return decodeFloat32(dst, data)
+	}
+	switch s.bits {
+	case 16:
+		for i := 0; i+2 <= len(data); i += 2 {
+			dst = append(dst, float32(int16(binary.LittleEndian.Uint16(data[i:])))/32768)
+		}
+	case 24:
+		for i := 0; i+3 <= len(data); i += 3 {
+			v := int32(data[i]) | int32(data[i+1])<<8 | int32(int8(data[i+2]))<<16
+			dst = append(dst, float32(v)/8388608)
+		}
+	case 32:
+		for i := 0; i+4 <= len(data); i += 4 {
+			dst = append(dst, float32(int32(binary.LittleEndian.Uint32(data[i:])))/2147483648)
+		}
+	}
+	return dst
+}
+
+// runMeter meters a source, calling fn with a reading for every window until
+// ctx is cancelled or the source ends. Samples are processed on the source's
+// goroutine, which for device capture is the audio thread, and readings are
+// handed to fn through a buffered channel; for live sources a slow consumer
+// misses readings instead of stalling capture.
+func runMeter(ctx context.Context, source sampleSource, window time.Duration, fn func(levelReading)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	readings := make(chan levelReading, 16)
+	sampleRate, channels := source.format()
+	meter := newLevelMeter(window, sampleRate, channels, func(r levelReading) {
+		if source.live() {
+			select {
+			case readings <- r:
+			default:
+			}
+			return
+		}
+		select {
+		case readings <- r:
+		case <-ctx.Done():
+		}
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- source.run(ctx, meter.process)
+		close(readings)
+	}()
+
+	for r := range readings {
+		fn(r)
+	}
+	return <-done
+}
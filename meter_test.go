@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// wavFormat selects the sample encoding of a generated WAV file
+type wavFormat struct {
+	bits  int
+	float bool
+}
+
+// writeTestWAV writes frames of interleaved samples in the range -1.0 to 1.0
+// to a WAV file in a temporary directory and returns its path
+func writeTestWAV(t *testing.T, f wavFormat, sampleRate, channels int, samples []float64) string {
+	t.Helper()
+	bytesPerSample := f.bits / 8
+	var data []byte
+	for _, s := range samples {
+		s = math.Max(-1, math.Min(1, s))
+		switch {
+		case f.float:
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(s)))
+		case f.bits == 16:
+			data = binary.LittleEndian.AppendUint16(data, uint16(int16(math.Round(s*32767))))
+		case f.bits == 24:
+			v := int32(math.Round(s * 8388607))
+			data = append(data, byte(v), byte(v>>8), byte(v>>16))
+		case f.bits == 32:
+			data = binary.LittleEndian.AppendUint32(data, uint32(int32(math.Round(s*2147483647))))
+		}
+	}
+
+	format := uint16(1)
+	if f.float {
+		format = 3
+	}
+	var buf []byte
+	buf = append(buf, "RIFF"...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(36+len(data)))
+	buf = append(buf, "WAVEfmt "...)
+	buf = binary.LittleEndian.AppendUint32(buf, 16)
+	buf = binary.LittleEndian.AppendUint16(buf, format)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(channels))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(sampleRate))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(sampleRate*channels*bytesPerSample))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(channels*bytesPerSample))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(f.bits))
+	buf = append(buf, "data"...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	buf = append(buf, data...)
+
+	path := filepath.Join(t.TempDir(), "test.wav")
+	if err := os.WriteFile(path, buf, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// tone returns a sine wave of the given peak amplitude, frequency and
+// duration, repeated on every channel
+func tone(amplitude, frequency float64, sampleRate, channels int, d time.Duration) []float64 {
+	frames := int(d.Seconds() * float64(sampleRate))
+	samples := make([]float64, 0, frames*channels)
+	for i := 0; i < frames; i++ {
+		s := amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate))
+		for c := 0; c < channels; c++ {
+			samples = append(samples, s)
+		}
+	}
+	return samples
+}
+
+// meterWAV meters a WAV file and returns all readings
+func meterWAV(t *testing.T, path string, window time.Duration) []levelReading {
+	t.Helper()
+	source, err := openWAVSource(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readings []levelReading
+	err = runMeter(context.Background(), source, window, func(r levelReading) {
+		readings = append(readings, r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readings
+}
+
+func TestMeterTone(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     wavFormat
+		sampleRate int
+		channels   int
+		peakDBFS   float64
+	}{
+		{"16-bit full scale", wavFormat{bits: 16}, 48000, 1, 0},
+		{"16-bit -20 dBFS", wavFormat{bits: 16}, 44100, 1, -20},
+		{"16-bit stereo -6 dBFS", wavFormat{bits: 16}, 48000, 2, -6},
+		{"24-bit -40 dBFS", wavFormat{bits: 24}, 48000, 1, -40},
+		{"32-bit -12 dBFS", wavFormat{bits: 32}, 16000, 1, -12},
+		{"float -60 dBFS", wavFormat{bits: 32, float: true}, 48000, 1, -60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amplitude := math.Pow(10, tt.peakDBFS/20)
+			samples := tone(amplitude, 1000, tt.sampleRate, tt.channels, time.Second)
+			path := writeTestWAV(t, tt.format, tt.sampleRate, tt.channels, samples)
+
+			readings := meterWAV(t, path, 100*time.Millisecond)
+			if len(readings) != 10 {
+				t.Fatalf("got %d readings of a second in 100ms windows, want 10", len(readings))
+			}
+
+			// A sine wave's RMS is its peak over the square root of two
+			wantRMS := tt.peakDBFS - 20*math.Log10(math.Sqrt2)
+			for i, r := range readings {
+				if math.Abs(r.PeakDBFS-tt.peakDBFS) > 0.1 {
+					t.Errorf("reading %d: peak = %.2f dBFS, want %.2f", i, r.PeakDBFS, tt.peakDBFS)
+				}
+				if math.Abs(r.RMSDBFS-wantRMS) > 0.1 {
+					t.Errorf("reading %d: RMS = %.2f dBFS, want %.2f", i, r.RMSDBFS, wantRMS)
+				}
+			}
+		})
+	}
+}
+
+func TestMeterDigitalSilence(t *testing.T) {
+	path := writeTestWAV(t, wavFormat{bits: 16}, 48000, 1, make([]float64, 48000/2))
+	for _, r := range meterWAV(t, path, 100*time.Millisecond) {
+		if r.PeakDBFS != meterFloorDBFS || r.RMSDBFS != meterFloorDBFS {
+			t.Errorf("silence reads peak %.1f, RMS %.1f dBFS, want %.0f", r.PeakDBFS, r.RMSDBFS, meterFloorDBFS)
+		}
+	}
+}
+
+func TestMeterNullSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	var readings []levelReading
+	err := runMeter(ctx, newNullCaptureSource(), 50*time.Millisecond, func(r levelReading) {
+		readings = append(readings, r)
+	})
+	if err != nil {
+		t.Skipf("null backend unavailable: %v", err)
+	}
+	if len(readings) == 0 {
+		t.Fatal("null source produced no readings")
+	}
+	for i, r := range readings {
+		if r.PeakDBFS != -120 || r.RMSDBFS != -120 {
+			t.Errorf("reading %d: peak %.1f, RMS %.1f dBFS, want -120", i, r.PeakDBFS, r.RMSDBFS)
+		}
+	}
+}
+
+func TestToDBFS(t *testing.T) {
+	tests := []struct {
+		level, want float64
+	}{
+		{1, 0},
+		{0.5, -6.0206},
+		{0.1, -20},
+		{0, meterFloorDBFS},
+		{1e-9, meterFloorDBFS},
+	}
+	for _, tt := range tests {
+		if got := toDBFS(tt.level); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("toDBFS(%g) = %.4f, want %.4f", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestOpenWAVSourceRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("not audio at all"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openWAVSource(path, false); err == nil {
+		t.Error("openWAVSource accepted a text file")
+	}
+}
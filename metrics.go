@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds in seconds of the enforcer loop duration histogram buckets
+var enforcerDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// correctionKey identifies a corrections counter
+type correctionKey struct {
+	deviceID string
+	source   string
+}
+
+// metricsRegistry accumulates enforcement activity for the metrics endpoint
+type metricsRegistry struct {
+	mu             sync.Mutex
+	corrections    map[correctionKey]uint64
+	deviceNames    map[string]string // last known name of corrected devices, for device info
+	listenerEvents uint64
+	backendErrors  map[string]uint64 // keyed by error kind
+	loopBuckets    []uint64          // cumulative counts per enforcerDurationBuckets entry
+	loopSum        float64
+	loopCount      uint64
+}
+
+// Enforcement metrics collected since startup
+var metrics = &metricsRegistry{
+	corrections:   make(map[correctionKey]uint64),
+	deviceNames:   make(map[string]string),
+	backendErrors: make(map[string]uint64),
+	loopBuckets:   make([]uint64, len(enforcerDurationBuckets)),
+}
+
+// recordCorrection counts a volume correction
+func (m *metricsRegistry) recordCorrection(ev appEvent) {
+	m.mu.Lock()
+	m.corrections[correctionKey{ev.DeviceID, ev.Source}]++
+	if ev.DeviceName != "" {
+		m.deviceNames[ev.DeviceID] = ev.DeviceName
+	}
+	m.mu.Unlock()
+}
+
+// recordListenerEvent counts a notification from the volume change listener
+func (m *metricsRegistry) recordListenerEvent() {
+	m.mu.Lock()
+	m.listenerEvents++
+	m.mu.Unlock()
+}
+
+// recordBackendError counts a failed call into the audio backend
+func (m *metricsRegistry) recordBackendError(err error) {
+	m.mu.Lock()
+	m.backendErrors[backendErrorKind(err)]++
+	m.mu.Unlock()
+}
+
+// observeEnforcerLoop records how long one enforcer pass took
+func (m *metricsRegistry) observeEnforcerLoop(d time.Duration) {
+	seconds := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, bound := range enforcerDurationBuckets {
+		if seconds <= bound {
+			m.loopBuckets[i]++
+		}
+	}
+	m.loopSum += seconds
+	m.loopCount++
+}
+
+// backendErrorKind classifies an audio backend error for the errors counter
+func backendErrorKind(err error) string {
+	switch {
+	case errors.Is(err, errDeviceNotFound):
+		return "device_not_found"
+	case errors.Is(err, errVolumeUnsupported):
+		return "volume_unsupported"
+	case errors.Is(err, errVolumeSetFailed):
+		return "set_failed"
+	default:
+		return "other"
+	}
+}
+
+// writeTo renders the metrics in the Prometheus text exposition format,
+// reading current volumes from the audio backend
+func (m *metricsRegistry) writeTo(w io.Writer) {
+	status := currentStatus()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Names live in a separate info metric so renaming a device does not
+	// start new counter or gauge series
+	writeMetricHeader(w, "micmaxer_device_info", "gauge", "Name of each known device, always 1.")
+	names := make(map[string]string, len(m.deviceNames)+len(status.Devices))
+	for id, name := range m.deviceNames {
+		names[id] = name
+	}
+	for _, d := range status.Devices {
+		names[d.ID] = d.Name
+	}
+	ids := make([]string, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Fprintf(w, "micmaxer_device_info{device_id=%s,device=%s} 1\n", quoteLabel(id), quoteLabel(names[id]))
+	}
+
+	writeMetricHeader(w, "micmaxer_corrections_total", "counter", "Volume corrections by device and source.")
+	keys := make([]correctionKey, 0, len(m.corrections))
+	for key := range m.corrections {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].deviceID != keys[j].deviceID {
+			return keys[i].deviceID < keys[j].deviceID
+		}
+		return keys[i].source < keys[j].source
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "micmaxer_corrections_total{device_id=%s,source=%s} %d\n",
+			quoteLabel(key.deviceID), quoteLabel(key.source), m.corrections[key])
+	}
+
+	writeMetricHeader(w, "micmaxer_listener_events_total", "counter", "Notifications received from the volume change listener.")
+	fmt.Fprintf(w, "micmaxer_listener_events_total %d\n", m.listenerEvents)
+
+	writeMetricHeader(w, "micmaxer_backend_errors_total", "counter", "Failed audio backend calls by kind.")
+	kinds := make([]string, 0, len(m.backendErrors))
+	for kind := range m.backendErrors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "micmaxer_backend_errors_total{kind=%s} %d\n", quoteLabel(kind), m.backendErrors[kind])
+	}
+
+	writeMetricHeader(w, "micmaxer_device_volume_percent", "gauge", "Current input volume of each device.")
+	for _, d := range status.Devices {
+		if d.Volume != nil {
+			fmt.Fprintf(w, "micmaxer_device_volume_percent{device_id=%s} %d\n", quoteLabel(d.ID), *d.Volume)
+		}
+	}
+
+	writeMetricHeader(w, "micmaxer_device_target_percent", "gauge", "Enforced volume of each enforced device.")
+	for _, d := range status.Devices {
+		if d.Enforced {
+			fmt.Fprintf(w, "micmaxer_device_target_percent{device_id=%s} %d\n", quoteLabel(d.ID), d.Target)
+		}
+	}
+
+	writeMetricHeader(w, "micmaxer_enforcement_paused", "gauge", "Whether enforcement is paused (1) or active (0).")
+	paused := 0
+	if status.Paused {
+		paused = 1
+	}
+	fmt.Fprintf(w, "micmaxer_enforcement_paused %d\n", paused)
+
+	writeMetricHeader(w, "micmaxer_enforcer_loop_duration_seconds", "histogram", "Duration of periodic enforcer passes.")
+	for i, bound := range enforcerDurationBuckets {
+		fmt.Fprintf(w, "micmaxer_enforcer_loop_duration_seconds_bucket{le=\"%g\"} %d\n", bound, m.loopBuckets[i])
+	}
+	fmt.Fprintf(w, "micmaxer_enforcer_loop_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.loopCount)
+	fmt.Fprintf(w, "micmaxer_enforcer_loop_duration_seconds_sum %g\n", m.loopSum)
+	fmt.Fprintf(w, "micmaxer_enforcer_loop_duration_seconds_count %d\n", m.loopCount)
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a metric family
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// quoteLabel quotes a label value, escaping it as the text format requires
+func quoteLabel(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// Running metrics server, if any, guarded like restAPI
+var (
+	metricsServerMu sync.Mutex
+	metricsServer   *http.Server
+)
+
+// metricsListenAddress returns the address to serve metrics on. An address
+// without a host, such as ":9465", listens on loopback only; an explicit
+// host is used as given, so a Prometheus on another machine can scrape it.
+func metricsListenAddress(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid metrics listen address %q: %w", addr, err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// isLoopbackHost reports whether a listen address only accepts connections
+// from this machine
+func isLoopbackHost(addr string) bool {
+	host, _, _ := net.SplitHostPort(addr)
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// newMetricsHandler serves the metrics at /metrics, requiring the token
+// like the REST API does if one is set
+func newMetricsHandler(token string) http.Handler {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.writeTo(w)
+	})
+	if token != "" {
+		handler = requireToken(token, handler)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", handler)
+	return mux
+}
+
+// startMetricsServer serves the metrics at /metrics on addr, with the token
+// from the metrics settings if one is configured. It does nothing if the
+// metrics are already being served.
+func startMetricsServer(addr string) error {
+	addr, err := metricsListenAddress(addr)
+	if err != nil {
+		return err
+	}
+
+	var token string
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Metrics != nil {
+		token = cfg.Metrics.Token
+	}
+
+	metricsServerMu.Lock()
+	defer metricsServerMu.Unlock()
+	if metricsServer != nil {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	metricsServer = &http.Server{Handler: newMetricsHandler(token), ReadHeaderTimeout: httpReadHeaderTimeout}
+
+	server := metricsServer
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "error", err)
+		}
+	}()
+
+	if token == "" && !isLoopbackHost(addr) {
+		slog.Warn("Metrics reachable from other machines without a token", "addr", listener.Addr().String())
+	}
+	slog.Info("Metrics listening", "addr", listener.Addr().String(), "token", token != "")
+	return nil
+}
+
+// stopMetricsServer stops serving metrics
+func stopMetricsServer() {
+	metricsServerMu.Lock()
+	defer metricsServerMu.Unlock()
+	if metricsServer == nil {
+		return
+	}
+	if err := metricsServer.Close(); err != nil {
+		slog.Warn("Failed to stop metrics server", "error", err)
+	}
+	metricsServer = nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gen2brain/malgo"
+)
+
+// newTestMetrics returns an empty metrics registry
+func newTestMetrics() *metricsRegistry {
+	return &metricsRegistry{
+		corrections:   make(map[correctionKey]uint64),
+		deviceNames:   make(map[string]string),
+		backendErrors: make(map[string]uint64),
+		loopBuckets:   make([]uint64, len(enforcerDurationBuckets)),
+	}
+}
+
+func TestMetricsCorrectionsSurviveRename(t *testing.T) {
+	m := newTestMetrics()
+	m.recordCorrection(appEvent{DeviceID: "metrics-test", DeviceName: "USB Mic", Source: "enforcer"})
+	m.recordCorrection(appEvent{DeviceID: "metrics-test", DeviceName: "Desk Mic", Source: "enforcer"})
+	m.recordCorrection(appEvent{DeviceID: "metrics-test", DeviceName: "Desk Mic", Source: "listener"})
+
+	var first bytes.Buffer
+	m.writeTo(&first)
+	out := first.String()
+
+	// A rename keeps counting in the same series
+	for _, want := range []string{
+		`micmaxer_corrections_total{device_id="metrics-test",source="enforcer"} 2`,
+		`micmaxer_corrections_total{device_id="metrics-test",source="listener"} 1`,
+		`micmaxer_device_info{device_id="metrics-test",device="Desk Mic"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics do not contain %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "USB Mic") {
+		t.Errorf("metrics still mention the old name:\n%s", out)
+	}
+	if strings.Index(out, `source="enforcer"`) > strings.Index(out, `source="listener"`) {
+		t.Errorf("corrections not sorted by source:\n%s", out)
+	}
+
+	for i := 0; i < 20; i++ {
+		var again bytes.Buffer
+		m.writeTo(&again)
+		if again.String() != out {
+			t.Fatalf("output changed between scrapes:\n%s\nthen:\n%s", out, again.String())
+		}
+	}
+}
+
+func TestMetricsDeviceGaugesLabelledByID(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	st := useTestState(t)
+	var info malgo.DeviceInfo
+	info.ID[0] = 0x2a
+	st.audioInputDevices = []malgo.DeviceInfo{info}
+	st.deviceStates[info.ID.String()] = true
+
+	var buf bytes.Buffer
+	newTestMetrics().writeTo(&buf)
+	out := buf.String()
+
+	want := `micmaxer_device_target_percent{device_id="2a"} 100`
+	if !strings.Contains(out, want+"\n") {
+		t.Errorf("metrics do not contain %s:\n%s", want, out)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, "device=") && !strings.HasPrefix(line, "micmaxer_device_info{") {
+			t.Errorf("series other than device info labelled with the name: %s", line)
+		}
+	}
+}
+
+func TestMetricsListenAddress(t *testing.T) {
+	tests := []struct {
+		addr, want string
+	}{
+		{":9465", "127.0.0.1:9465"},
+		{"127.0.0.1:9465", "127.0.0.1:9465"},
+		{"localhost:9465", "localhost:9465"},
+		{"0.0.0.0:9465", "0.0.0.0:9465"},
+		{"192.168.1.10:9465", "192.168.1.10:9465"},
+		{"[::]:9465", "[::]:9465"},
+		{"127.0.0.1", ""},
+	}
+	for _, tt := range tests {
+		got, err := metricsListenAddress(tt.addr)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("metricsListenAddress(%q) = %q, want an error", tt.addr, got)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("metricsListenAddress(%q) = %q, %v, want %q", tt.addr, got, err, tt.want)
+		}
+	}
+
+	for addr, want := range map[string]bool{"127.0.0.1:9465": true, "[::1]:9465": true, "localhost:9465": true, "0.0.0.0:9465": false, "192.168.1.10:9465": false} {
+		if got := isLoopbackHost(addr); got != want {
+			t.Errorf("isLoopbackHost(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestMetricsHandlerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		auth   string
+		status int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"missing", testToken, "", http.StatusUnauthorized},
+		{"wrong", testToken, "Bearer fedcba9876543210fedcba9876543210", http.StatusUnauthorized},
+		{"bearer", testToken, "Bearer " + testToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		newMetricsHandler(tt.token).ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestStartMetricsServerRemote(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	err := updateConfig(func(cfg *appConfig) {
+		cfg.Metrics = &metricsConfig{Token: testToken}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An explicit address reachable from other machines is honoured
+	if err := startMetricsServer("0.0.0.0:0"); err != nil {
+		t.Fatalf("startMetricsServer(0.0.0.0:0): %v", err)
+	}
+	defer stopMetricsServer()
+	metricsServerMu.Lock()
+	defer metricsServerMu.Unlock()
+	if metricsServer == nil {
+		t.Fatal("no metrics server running")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	metricsServer.Handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("scrape without the configured token: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestStartMetricsServerConcurrently(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- startMetricsServer("127.0.0.1:0")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	defer stopMetricsServer()
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("startMetricsServer: %v", err)
+		}
+	}
+	metricsServerMu.Lock()
+	running := metricsServer != nil
+	metricsServerMu.Unlock()
+	if !running {
+		t.Error("no metrics server running after concurrent starts")
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for desktop notification rate limiting
+const (
+	defaultNotifyIntervalSeconds = 60 // between notifications of one kind for one device
+	defaultNotifyMaxPerMinute    = 5  // across all notifications
+)
+
+// notifiableEvents lists the events that can be shown as desktop
+// notifications, in menu order, and whether each is shown by default
+var notifiableEvents = []struct {
+	typ   eventType
+	label string
+	on    bool
+}{
+	{eventVolumeCorrected, "Volume Restored", true},
+	{eventConflictDetected, "Volume Conflicts", true},
+	{eventEnforcementFailed, "Enforcement Failures", true},
+	{eventDeviceAdded, "Device Connected", true},
+	{eventDeviceRemoved, "Device Disconnected", false},
+	{eventEnforcementResumed, "Pause Ended", false},
+	{eventSilenceDetected, "Silent Microphone", true},
+	{eventClippingDetected, "Clipping", true},
+}
+
+// notificationsConfig chooses which events are shown as desktop
+// notifications; zero values select the defaults
+type notificationsConfig struct {
+	Enabled         bool            `json:"enabled"`
+	Events          map[string]bool `json:"events,omitempty"`           // event type to whether it is shown, overriding the defaults
+	IntervalSeconds int             `json:"interval_seconds,omitempty"` // minimum time between notifications of one kind for one device
+	MaxPerMinute    int             `json:"max_per_minute,omitempty"`   // limit across all notifications
+}
+
+// withDefaults returns the settings with unset values filled in
+func (c notificationsConfig) withDefaults() notificationsConfig {
+	if c.IntervalSeconds == 0 {
+		c.IntervalSeconds = defaultNotifyIntervalSeconds
+	}
+	if c.MaxPerMinute == 0 {
+		c.MaxPerMinute = defaultNotifyMaxPerMinute
+	}
+	return c
+}
+
+// shows reports whether events of a type are shown as notifications
+func (c notificationsConfig) shows(t eventType) bool {
+	if shown, ok := c.Events[string(t)]; ok {
+		return shown
+	}
+	for _, e := range notifiableEvents {
+		if e.typ == t {
+			return e.on
+		}
+	}
+	return false
+}
+
+// notifier shows desktop notifications for application events, limiting how
+// often they appear
+type notifier struct {
+	show func(title, message string) error // nil to show desktop notifications
+
+	mu          sync.Mutex
+	settings    notificationsConfig
+	last        map[string]time.Time // event type and device to last notification
+	recent      []time.Time          // notifications shown in the last minute
+	unsubscribe func()
+}
+
+// Global desktop notifier instance
+var notifications = &notifier{last: make(map[string]time.Time)}
+
+// start loads the notification settings and follows events; it runs while
+// notifications are disabled so they can be turned on from the menu
+func (n *notifier) start() {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+	}
+	var settings notificationsConfig
+	if cfg.Notifications != nil {
+		settings = *cfg.Notifications
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.unsubscribe != nil {
+		return
+	}
+	n.settings = settings
+	ch, unsubscribe := events.subscribe(32)
+	n.unsubscribe = unsubscribe
+
+	go func() {
+		for ev := range ch {
+			n.handle(ev)
+		}
+	}()
+}
+
+// stop ends following events
+func (n *notifier) stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.unsubscribe != nil {
+		n.unsubscribe()
+		n.unsubscribe = nil
+	}
+}
+
+// current returns the notification settings in effect
+func (n *notifier) current() notificationsConfig {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.settings
+}
+
+// handle shows a notification for an event if its type is enabled and the
+// rate limits allow it. Changes the user made through MicMaxer are not shown.
+func (n *notifier) handle(ev appEvent) {
+	if ev.Source == sourceUser {
+		return
+	}
+	title, message := notificationText(ev)
+	if title == "" {
+		return
+	}
+
+	n.mu.Lock()
+	settings := n.settings.withDefaults()
+	if !settings.Enabled || !settings.shows(ev.Type) {
+		n.mu.Unlock()
+		return
+	}
+	allowed := n.allow(string(ev.Type)+"/"+ev.DeviceID, ev.Time, settings)
+	n.mu.Unlock()
+	show := n.show
+	if show == nil {
+		show = desktopNotify
+	}
+
+	if !allowed {
+		slog.Debug("Notification suppressed by rate limit", "event", ev.Type, "device_id", ev.DeviceID)
+		return
+	}
+	if err := show(title, message); err != nil {
+		slog.Warn("Failed to show notification", "event", ev.Type, "error", err)
+	}
+}
+
+// allow applies the per-kind interval and the overall limit per minute,
+// recording the notification if it may be shown; the caller must hold n.mu
+func (n *notifier) allow(key string, now time.Time, settings notificationsConfig) bool {
+	if last, ok := n.last[key]; ok && now.Sub(last) < time.Duration(settings.IntervalSeconds)*time.Second {
+		return false
+	}
+
+	recent := n.recent[:0]
+	for _, t := range n.recent {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	n.recent = recent
+	if len(n.recent) >= settings.MaxPerMinute {
+		return false
+	}
+
+	n.last[key] = now
+	n.recent = append(n.recent, now)
+	return true
+}
+
+// notificationText returns the title and message of the notification for an
+// event, or an empty title if the event is not shown as one
+func notificationText(ev appEvent) (string, string) {
+	name := ev.DeviceName
+	if name == "" {
+		name = "The default input"
+	}
+
+	switch ev.Type {
+	case eventVolumeCorrected:
+		title := fmt.Sprintf("Mic restored to %d%%", ev.Target)
+		if ev.App != "" {
+			return title, fmt.Sprintf("%s was changed to %d%%, probably by %s.", name, ev.Volume, ev.App)
+		}
+		return title, fmt.Sprintf("%s was changed to %d%%.", name, ev.Volume)
+	case eventConflictDetected:
+		if ev.App != "" {
+			return "Volume conflict", fmt.Sprintf("%s keeps changing %s.", ev.App, name)
+		}
+		return "Volume conflict", fmt.Sprintf("Another application keeps changing %s.", name)
+	case eventEnforcementFailed:
+		return "Enforcement failing on " + name, fmt.Sprintf("Cannot set %d%%: %s.", ev.Target, ev.Error)
+	case eventDeviceAdded:
+		return "New device connected", name
+	case eventDeviceRemoved:
+		return "Device disconnected", name
+	case eventEnforcementResumed:
+		if ev.DeviceID != "" {
+			return "Enforcement resumed", fmt.Sprintf("The pause of %s has ended.", name)
+		}
+		return "Enforcement resumed", "The pause has ended."
+	case eventSilenceDetected:
+		return "Microphone is silent", fmt.Sprintf("%s has picked up nothing for %d seconds. Check that it is connected.", name, ev.Count)
+	case eventClippingDetected:
+		return "Microphone is clipping", fmt.Sprintf("%s clipped %d times in a few seconds. Lower its gain.", name, ev.Count)
+	}
+	return "", ""
+}
+
+// setNotifications turns desktop notifications on or off, or a single event
+// type if t is not empty, and saves the choice
+func setNotifications(t eventType, enabled bool) error {
+	var settings notificationsConfig
+	err := updateConfig(func(cfg *appConfig) {
+		if cfg.Notifications == nil {
+			cfg.Notifications = &notificationsConfig{}
+		}
+		if t == "" {
+			cfg.Notifications.Enabled = enabled
+		} else {
+			if cfg.Notifications.Events == nil {
+				cfg.Notifications.Events = make(map[string]bool)
+			}
+			cfg.Notifications.Events[string(t)] = enabled
+		}
+		settings = *cfg.Notifications
+	})
+	if err != nil {
+		return err
+	}
+
+	notifications.mu.Lock()
+	notifications.settings = settings
+	notifications.mu.Unlock()
+	return nil
+}
+
+// errPromptCancelled is returned when the user dismisses a text prompt
+var errPromptCancelled = errors.New("prompt cancelled")
+
+// promptText asks the user for a line of text in a dialog, using osascript on
+// macOS and zenity elsewhere
+func promptText(title, prompt, defaultValue string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		script := fmt.Sprintf("text returned of (display dialog %s with title %s default answer %s)",
+			strconv.Quote(prompt), strconv.Quote(title), strconv.Quote(defaultValue))
+		cmd = exec.Command("osascript", "-e", script)
+	} else {
+		cmd = exec.Command("zenity", "--entry", "--title="+title, "--text="+prompt, "--entry-text="+defaultValue)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		// Both tools exit with status 1 when the dialog is cancelled
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", errPromptCancelled
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
@@ -0,0 +1,90 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+/*
+#cgo CFLAGS: -x objective-c
+#cgo LDFLAGS: -framework Foundation -framework UserNotifications
+#include <stdlib.h>
+#import <Foundation/Foundation.h>
+#import <UserNotifications/UserNotifications.h>
+
+// The notification center is only available to apps with a bundle identifier,
+// not to a binary started from the terminal
+static int notificationCenterAvailable(void) {
+    return [[NSBundle mainBundle] bundleIdentifier] != nil;
+}
+
+// Ask for permission to show notifications; macOS only prompts the first time
+static void requestNotificationAuthorization(void) {
+    UNUserNotificationCenter *center = [UNUserNotificationCenter currentNotificationCenter];
+    [center requestAuthorizationWithOptions:UNAuthorizationOptionAlert
+                          completionHandler:^(BOOL granted, NSError *error) {}];
+}
+
+// Show a notification with the notification center
+static void postNotification(const char *identifier, const char *title, const char *body) {
+    @autoreleasepool {
+        UNMutableNotificationContent *content = [[UNMutableNotificationContent alloc] init];
+        content.title = [NSString stringWithUTF8String:title];
+        content.body = [NSString stringWithUTF8String:body];
+
+        UNNotificationRequest *request =
+            [UNNotificationRequest requestWithIdentifier:[NSString stringWithUTF8String:identifier]
+                                                 content:content
+                                                 trigger:nil];
+        [[UNUserNotificationCenter currentNotificationCenter] addNotificationRequest:request
+                                                               withCompletionHandler:nil];
+        [content release];
+    }
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// notificationAuthorization requests permission to notify once per run
+var notificationAuthorization sync.Once
+
+// desktopNotify shows a desktop notification through the user notification
+// center, or through osascript when running outside an app bundle
+func desktopNotify(title, message string) error {
+	if C.notificationCenterAvailable() == 0 {
+		return osascriptNotify(title, message)
+	}
+	notificationAuthorization.Do(func() {
+		C.requestNotificationAuthorization()
+	})
+
+	cID := C.CString(fmt.Sprintf("micmaxer-%d", time.Now().UnixNano()))
+	defer C.free(unsafe.Pointer(cID))
+	cTitle := C.CString(title)
+	defer C.free(unsafe.Pointer(cTitle))
+	cMessage := C.CString(message)
+	defer C.free(unsafe.Pointer(cMessage))
+
+	C.postNotification(cID, cTitle, cMessage)
+	return nil
+}
+
+// osascriptNotify shows a notification attributed to Script Editor
+func osascriptNotify(title, message string) error {
+	script := fmt.Sprintf("display notification %s with title %s",
+		strconv.Quote(message), strconv.Quote(title))
+	if out, err := exec.Command("osascript", "-e", script).CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// desktopNotify shows a desktop notification through the
+// org.freedesktop.Notifications service on the session bus
+func desktopNotify(title, message string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to session bus: %w", err)
+	}
+
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.Call("org.freedesktop.Notifications.Notify", 0,
+		"MicMaxer",                // app_name
+		uint32(0),                 // replaces_id
+		"audio-input-microphone",  // app_icon
+		title,                     // summary
+		message,                   // body
+		[]string{},                // actions
+		map[string]dbus.Variant{}, // hints
+		int32(-1),                 // expire_timeout: server default
+	)
+	return call.Err
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import "fmt"
+
+// desktopNotify is not implemented for systems other than macOS and Linux
+func desktopNotify(title, message string) error {
+	return fmt.Errorf("desktop notifications are only supported on macOS and Linux")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// testNotifier returns a notifier with the given settings that records the
+// titles of the notifications it shows
+func testNotifier(settings notificationsConfig) (*notifier, *[]string) {
+	var shown []string
+	n := &notifier{
+		show: func(title, message string) error {
+			shown = append(shown, title)
+			return nil
+		},
+		settings: settings,
+		last:     make(map[string]time.Time),
+	}
+	return n, &shown
+}
+
+// correction returns a volume correction of a device at a time
+func correction(deviceID string, at time.Time) appEvent {
+	return appEvent{Time: at, Type: eventVolumeCorrected, DeviceID: deviceID, DeviceName: deviceID, Volume: 40, Target: 90, Source: sourceListener}
+}
+
+func TestNotifierInterval(t *testing.T) {
+	n, shown := testNotifier(notificationsConfig{Enabled: true, IntervalSeconds: 60, MaxPerMinute: 100})
+
+	n.handle(correction("mic", week))
+	n.handle(correction("mic", week.Add(59*time.Second))) // too soon for the same device
+	n.handle(correction("usb", week.Add(30*time.Second))) // another device has its own interval
+	n.handle(correction("mic", week.Add(60*time.Second))) // the interval has passed
+	n.handle(correction("usb", week.Add(89*time.Second))) // too soon after the shown one
+
+	// Another kind of event on the same device is limited separately
+	n.handle(appEvent{Time: week.Add(61 * time.Second), Type: eventConflictDetected, DeviceID: "mic", Source: sourceListener})
+
+	want := []string{"Mic restored to 90%", "Mic restored to 90%", "Mic restored to 90%", "Volume conflict"}
+	if !slices.Equal(*shown, want) {
+		t.Errorf("shown %q, want %q", *shown, want)
+	}
+}
+
+func TestNotifierMaxPerMinute(t *testing.T) {
+	n, shown := testNotifier(notificationsConfig{Enabled: true, MaxPerMinute: 3})
+
+	// Five devices corrected within a minute show only three notifications
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		n.handle(correction(id, week.Add(time.Duration(i)*10*time.Second)))
+	}
+	if len(*shown) != 3 {
+		t.Fatalf("shown %d notifications within a minute, want 3", len(*shown))
+	}
+
+	// A minute after the first one there is room for another
+	n.handle(correction("f", week.Add(59*time.Second)))
+	n.handle(correction("g", week.Add(60*time.Second)))
+	if len(*shown) != 4 {
+		t.Errorf("shown %d notifications after a minute, want 4", len(*shown))
+	}
+}
+
+func TestNotificationsConfigShows(t *testing.T) {
+	defaults := notificationsConfig{}
+	overridden := notificationsConfig{Events: map[string]bool{
+		string(eventVolumeCorrected): false,
+		string(eventDeviceRemoved):   true,
+		string(eventDeviceChecked):   true, // not a notifiable event, but asked for
+	}}
+
+	tests := []struct {
+		typ              eventType
+		byDefault, after bool
+	}{
+		{eventVolumeCorrected, true, false},
+		{eventConflictDetected, true, true},
+		{eventDeviceRemoved, false, true},
+		{eventEnforcementResumed, false, false},
+		{eventDeviceChecked, false, true},
+	}
+	for _, tt := range tests {
+		if got := defaults.shows(tt.typ); got != tt.byDefault {
+			t.Errorf("shows(%s) by default = %v, want %v", tt.typ, got, tt.byDefault)
+		}
+		if got := overridden.shows(tt.typ); got != tt.after {
+			t.Errorf("shows(%s) with overrides = %v, want %v", tt.typ, got, tt.after)
+		}
+	}
+
+	if got := (notificationsConfig{}).withDefaults(); got.IntervalSeconds != defaultNotifyIntervalSeconds || got.MaxPerMinute != defaultNotifyMaxPerMinute {
+		t.Errorf("withDefaults() = %+v, want the default limits", got)
+	}
+}
+
+func TestNotifierSkipsEvents(t *testing.T) {
+	n, shown := testNotifier(notificationsConfig{Enabled: true})
+
+	// Changes made through MicMaxer itself are not notified
+	user := correction("mic", week)
+	user.Source = sourceUser
+	n.handle(user)
+
+	// Nor are events without a notification or turned off by default
+	n.handle(appEvent{Time: week, Type: eventDeviceChecked, DeviceID: "mic", Source: sourceEnforcer})
+	n.handle(appEvent{Time: week, Type: eventDeviceRemoved, DeviceID: "mic", Source: sourceScan})
+	if len(*shown) != 0 {
+		t.Fatalf("shown %q, want nothing", *shown)
+	}
+
+	// Nothing is shown while notifications are disabled, and suppressed
+	// events do not count towards the limits
+	n.settings.Enabled = false
+	n.handle(correction("mic", week))
+	n.settings.Enabled = true
+	n.handle(correction("mic", week.Add(time.Second)))
+	if len(*shown) != 1 {
+		t.Errorf("shown %q, want one notification once enabled", *shown)
+	}
+}
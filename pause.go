@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Pause lengths offered in the menu
+var pauseDurations = []struct {
+	label    string
+	duration time.Duration
+}{
+	{"For 5 Minutes", 5 * time.Minute},
+	{"For 1 Hour", time.Hour},
+	{"Until Resumed", 0},
+}
+
+// pauseTimers ends timed pauses; timers are keyed by device ID, with "" for
+// the pause of all devices
+type pauseTimers struct {
+	clock   timerClock
+	catchUp func() // runs after a resume; nil to run the enforcer
+
+	mu     sync.Mutex
+	timers map[string]stopper
+}
+
+// Global pause timers instance
+var pauses = &pauseTimers{clock: systemClock{}, timers: make(map[string]stopper)}
+
+// schedule replaces the timer of a pause, resuming at until unless it is zero
+func (p *pauseTimers) schedule(deviceID string, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if timer, ok := p.timers[deviceID]; ok {
+		timer.Stop()
+		delete(p.timers, deviceID)
+	}
+	if until.IsZero() {
+		return
+	}
+	p.timers[deviceID] = p.clock.AfterFunc(until.Sub(p.clock.Now()), func() {
+		expirePause(deviceID, until)
+	})
+}
+
+// pausedLocked reports whether enforcement is paused for a device, either on
+// its own or for all devices, or released by its schedule; the caller must
+// hold s.mu
+func (s *audioState) pausedLocked(deviceID string) bool {
+	if s.paused || s.releasedLocked(deviceID) {
+		return true
+	}
+	_, ok := s.devicePauses[deviceID]
+	return ok
+}
+
+// pauseState reports whether a device, or all devices if deviceID is empty,
+// is paused on its own account and when the pause ends, nil if until resumed
+func pauseState(deviceID string) (bool, *time.Time) {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	until, paused := state.devicePauses[deviceID]
+	if deviceID == "" {
+		until, paused = state.pausedUntil, state.paused
+	}
+	if !paused || until.IsZero() {
+		return paused, nil
+	}
+	return true, &until
+}
+
+// pauseEnforcement suspends listener corrections, periodic enforcement and
+// AGC for a device, or for all devices if deviceID is empty. The pause ends
+// after d, or when resumed if d is 0.
+func pauseEnforcement(deviceID string, d time.Duration) {
+	var until time.Time
+	if d > 0 {
+		until = pauses.clock.Now().Add(d).Truncate(time.Second)
+	}
+
+	state.mu.Lock()
+	var changed bool
+	if deviceID == "" {
+		changed = !state.paused || !state.pausedUntil.Equal(until)
+		state.paused = true
+		state.pausedUntil = until
+	} else {
+		previous, ok := state.devicePauses[deviceID]
+		changed = !ok || !previous.Equal(until)
+		state.devicePauses[deviceID] = until
+	}
+	name := state.deviceNameLocked(deviceID)
+	state.mu.Unlock()
+
+	pauses.schedule(deviceID, until)
+	if !changed {
+		return
+	}
+
+	ev := appEvent{Type: eventEnforcementPaused, Source: sourceUser}
+	attrs := []any{"source", sourceUser}
+	if deviceID != "" {
+		ev.DeviceID, ev.DeviceName = deviceID, name
+		attrs = append(attrs, "device", name, "device_id", deviceID)
+	}
+	if !until.IsZero() {
+		ev.Until = &until
+		attrs = append(attrs, "until", until.Format(time.RFC3339))
+	}
+	slog.Info("Volume enforcement paused", attrs...)
+	events.publish(ev)
+}
+
+// resumeEnforcement ends the pause of a device, or of all devices if
+// deviceID is empty, and catches up on anything that drifted meanwhile
+func resumeEnforcement(deviceID string) {
+	resume(deviceID, sourceUser)
+}
+
+// expirePause resumes enforcement when a timed pause ends, unless the pause
+// has been changed since its timer was set
+func expirePause(deviceID string, until time.Time) {
+	if paused, current := pauseState(deviceID); paused && current != nil && current.Equal(until) {
+		resume(deviceID, sourceTimer)
+	}
+}
+
+// resume ends a pause on behalf of source
+func resume(deviceID, source string) {
+	state.mu.Lock()
+	var changed bool
+	if deviceID == "" {
+		changed = state.paused
+		state.paused = false
+		state.pausedUntil = time.Time{}
+	} else {
+		_, changed = state.devicePauses[deviceID]
+		delete(state.devicePauses, deviceID)
+	}
+	name := state.deviceNameLocked(deviceID)
+	state.mu.Unlock()
+
+	pauses.schedule(deviceID, time.Time{})
+	if !changed {
+		return
+	}
+
+	ev := appEvent{Type: eventEnforcementResumed, Source: source}
+	attrs := []any{"source", source}
+	if deviceID != "" {
+		ev.DeviceID, ev.DeviceName = deviceID, name
+		attrs = append(attrs, "device", name, "device_id", deviceID)
+	}
+	slog.Info("Volume enforcement resumed", attrs...)
+	events.publish(ev)
+
+	// Catch up on anything that drifted while paused
+	catchUp := pauses.catchUp
+	if catchUp == nil {
+		catchUp = enforceVolumeSettings
+	}
+	go catchUp()
+}
+
+// parsePauseDuration parses the length of a pause, where an empty string
+// means until resumed
+func parsePauseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid pause duration %q: expected a positive duration such as 5m or 1h", s)
+	}
+	return d, nil
+}
+
+// pauseUntilLabel describes when a pause ends
+func pauseUntilLabel(until *time.Time) string {
+	if until == nil || until.IsZero() {
+		return "until resumed"
+	}
+	return "until " + until.Local().Format("15:04")
+}
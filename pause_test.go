@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useTestPauses replaces the global pause timers with ones driven by a fake
+// clock; the returned channel receives a value for every catch-up after a resume
+func useTestPauses(t *testing.T) (*fakeClock, <-chan struct{}) {
+	t.Helper()
+	clock := newFakeClock(week.Add(10*time.Hour + 500*time.Millisecond))
+	caughtUp := make(chan struct{}, 8)
+	saved := pauses
+	pauses = &pauseTimers{
+		clock:   clock,
+		catchUp: func() { caughtUp <- struct{}{} },
+		timers:  make(map[string]stopper),
+	}
+	t.Cleanup(func() { pauses = saved })
+	return clock, caughtUp
+}
+
+// nextEvent returns the event published last, failing if there is none
+func nextEvent(t *testing.T, ch <-chan appEvent) appEvent {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	default:
+		t.Fatal("no event published")
+		return appEvent{}
+	}
+}
+
+// waitForCatchUp waits for the enforcer to catch up after a resume
+func waitForCatchUp(t *testing.T, caughtUp <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-caughtUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("enforcement did not catch up after the resume")
+	}
+}
+
+func TestTimedPauseExpires(t *testing.T) {
+	st := useTestState(t)
+	clock, caughtUp := useTestPauses(t)
+	ch, unsubscribe := events.subscribe(16)
+	defer unsubscribe()
+
+	// Pauses end on the whole second
+	pauseEnforcement("", 5*time.Minute)
+	until := week.Add(10*time.Hour + 5*time.Minute)
+	if paused, end := pauseState(""); !paused || end == nil || !end.Equal(until) {
+		t.Fatalf("pauseState() = %v, %v, want paused until %v", paused, end, until)
+	}
+	if ev := nextEvent(t, ch); ev.Type != eventEnforcementPaused || ev.Until == nil || !ev.Until.Equal(until) || ev.Source != sourceUser {
+		t.Errorf("event on pause = %+v, want %s until %v by %s", ev, eventEnforcementPaused, until, sourceUser)
+	}
+	if !st.pausedLocked("mic") {
+		t.Error("device not paused by the pause of all devices")
+	}
+
+	clock.advance(5*time.Minute - time.Second)
+	if paused, _ := pauseState(""); !paused {
+		t.Fatal("pause ended early")
+	}
+	clock.advance(time.Second)
+	if paused, _ := pauseState(""); paused {
+		t.Fatal("pause did not end")
+	}
+	if ev := nextEvent(t, ch); ev.Type != eventEnforcementResumed || ev.Source != sourceTimer {
+		t.Errorf("event on expiry = %+v, want %s by %s", ev, eventEnforcementResumed, sourceTimer)
+	}
+	waitForCatchUp(t, caughtUp)
+}
+
+func TestResumeEnforcement(t *testing.T) {
+	st := useTestState(t)
+	clock, caughtUp := useTestPauses(t)
+	ch, unsubscribe := events.subscribe(16)
+	defer unsubscribe()
+
+	pauseEnforcement("mic", time.Hour)
+	if ev := nextEvent(t, ch); ev.Type != eventEnforcementPaused || ev.DeviceID != "mic" {
+		t.Fatalf("event on pause = %+v, want %s for the device", ev, eventEnforcementPaused)
+	}
+	if !st.pausedLocked("mic") || st.pausedLocked("other") {
+		t.Error("pausing a device did not pause only that device")
+	}
+
+	resumeEnforcement("mic")
+	if paused, _ := pauseState("mic"); paused {
+		t.Fatal("device still paused after resuming")
+	}
+	if ev := nextEvent(t, ch); ev.Type != eventEnforcementResumed || ev.DeviceID != "mic" || ev.Source != sourceUser {
+		t.Errorf("event on resume = %+v, want %s for the device by %s", ev, eventEnforcementResumed, sourceUser)
+	}
+	waitForCatchUp(t, caughtUp)
+
+	// Resuming again, or the stopped timer, changes nothing
+	resumeEnforcement("mic")
+	clock.advance(time.Hour)
+	if len(ch) != 0 {
+		t.Errorf("event after the device was resumed: %+v", <-ch)
+	}
+}
+
+func TestPauseReplacesTimer(t *testing.T) {
+	useTestState(t)
+	clock, _ := useTestPauses(t)
+	ch, unsubscribe := events.subscribe(16)
+	defer unsubscribe()
+
+	pauseEnforcement("", 5*time.Minute)
+	nextEvent(t, ch)
+
+	// Pausing again for as long changes nothing
+	pauseEnforcement("", 5*time.Minute)
+	if len(ch) != 0 {
+		t.Errorf("event on an unchanged pause: %+v", <-ch)
+	}
+
+	// Pausing until resumed outlasts the timer of the timed pause
+	pauseEnforcement("", 0)
+	if ev := nextEvent(t, ch); ev.Type != eventEnforcementPaused || ev.Until != nil {
+		t.Errorf("event on pausing until resumed = %+v, want %s without an end", ev, eventEnforcementPaused)
+	}
+	clock.advance(time.Hour)
+	if paused, until := pauseState(""); !paused || until != nil {
+		t.Errorf("pauseState() = %v, %v, want paused until resumed", paused, until)
+	}
+	if len(ch) != 0 {
+		t.Errorf("event after the replaced timer was due: %+v", <-ch)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Push-to-talk modes
+const (
+	pttModeOff  = ""     // enforced devices follow their targets and mute policies
+	pttModeTalk = "talk" // enforced devices are silenced except while held
+	pttModeMute = "mute" // enforced devices are silenced only while held
+)
+
+// How push-to-talk silences enforced devices
+const (
+	pttSilenceMute   = "mute"   // mute the device
+	pttSilenceVolume = "volume" // hold the device's volume at 0%
+)
+
+// Holders of push-to-talk; each holds independently and the devices are
+// released once none is left
+const (
+	pttHolderHotkey = "hotkey"
+	pttHolderAPI    = "api"
+)
+
+const (
+	// defaultPTTReleaseTailMS keeps devices live briefly after release so
+	// the end of a sentence is not cut off
+	defaultPTTReleaseTailMS = 300
+
+	// defaultPTTHoldLimit ends an API hold that is not renewed, so a client
+	// that goes away never leaves the microphones open
+	defaultPTTHoldLimit = 30 * time.Second
+)
+
+// errPushToTalkOff is returned when holding push-to-talk with no mode set
+var errPushToTalkOff = errors.New("push-to-talk is off; choose a mode in the menu or set push_to_talk.mode in the config file")
+
+// pttModeChoices lists the push-to-talk modes in menu order
+var pttModeChoices = []struct {
+	label string
+	mode  string
+}{
+	{"Off", pttModeOff},
+	{"Push to Talk", pttModeTalk},
+	{"Push to Mute", pttModeMute},
+}
+
+// pushToTalkConfig holds the push-to-talk settings; unset values select the
+// defaults
+type pushToTalkConfig struct {
+	Mode          string `json:"mode,omitempty"`            // "talk" or "mute"; empty turns push-to-talk off
+	Silence       string `json:"silence,omitempty"`         // "mute" (default) or "volume" to hold devices at 0%
+	ReleaseTailMS *int   `json:"release_tail_ms,omitempty"` // how long devices stay live after release, default 300; 0 turns the tail off
+}
+
+// withDefaults returns the settings with unset values filled in
+func (c pushToTalkConfig) withDefaults() pushToTalkConfig {
+	if c.Silence == "" {
+		c.Silence = pttSilenceMute
+	}
+	if c.ReleaseTailMS == nil {
+		tail := defaultPTTReleaseTailMS
+		c.ReleaseTailMS = &tail
+	}
+	return c
+}
+
+// validate reports settings that cannot be used
+func (c pushToTalkConfig) validate() error {
+	switch c.Mode {
+	case pttModeOff, pttModeTalk, pttModeMute:
+	default:
+		return fmt.Errorf("invalid push-to-talk mode %q: expected %s or %s", c.Mode, pttModeTalk, pttModeMute)
+	}
+	switch c.Silence {
+	case "", pttSilenceMute, pttSilenceVolume:
+	default:
+		return fmt.Errorf("invalid push-to-talk silence %q: expected %s or %s", c.Silence, pttSilenceMute, pttSilenceVolume)
+	}
+	if c.ReleaseTailMS != nil && *c.ReleaseTailMS < 0 {
+		return fmt.Errorf("invalid push-to-talk release tail %dms: must not be negative", *c.ReleaseTailMS)
+	}
+	return nil
+}
+
+// pushToTalkStatus reports the push-to-talk state for the control APIs
+type pushToTalkStatus struct {
+	Mode     string `json:"mode"`
+	Held     bool   `json:"held"`     // held, or within the release tail
+	Silenced bool   `json:"silenced"` // enforced devices are currently silenced
+}
+
+// pushToTalkController keeps enforced devices silenced or live depending on
+// the mode and whether push-to-talk is held. The enforcer, volume change
+// listener and mute policies ask it for the state to restore, so they keep
+// the temporary state rather than fight it.
+type pushToTalkController struct {
+	clock    timerClock
+	mu       sync.Mutex
+	settings pushToTalkConfig
+	holds    map[string]stopper // holder to the timer ending its hold, nil if held until released
+	held     bool               // a holder is active or the release tail is running
+	tail     stopper
+	release  int // counts releases so a stale release tail is ignored
+}
+
+// Global push-to-talk instance
+var ptt = &pushToTalkController{clock: systemClock{}, holds: make(map[string]stopper)}
+
+// start loads the push-to-talk settings and silences the enforced devices if
+// the mode asks for it
+func (c *pushToTalkController) start() {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+	}
+	var settings pushToTalkConfig
+	if cfg.PushToTalk != nil {
+		settings = *cfg.PushToTalk
+	}
+	if err := settings.validate(); err != nil {
+		slog.Warn("Ignoring push-to-talk settings", "error", err)
+		settings = pushToTalkConfig{}
+	}
+
+	c.mu.Lock()
+	c.settings = settings
+	c.mu.Unlock()
+
+	if settings.Mode != pttModeOff {
+		slog.Info("Push-to-talk enabled", "mode", settings.Mode, "silence", settings.withDefaults().Silence)
+		c.apply(sourceUser)
+	}
+}
+
+// stop drops all holds and turns push-to-talk off, restoring the volume and
+// mute state of devices it silenced
+func (c *pushToTalkController) stop() {
+	c.mu.Lock()
+	silenced := c.silencedLocked()
+	silence := c.settings.withDefaults().Silence
+	c.resetLocked()
+	c.settings = pushToTalkConfig{}
+	c.mu.Unlock()
+
+	if silenced {
+		c.restoreEnforced(silence)
+	}
+}
+
+// resetLocked drops all holds and a running release tail; the caller must
+// hold c.mu
+func (c *pushToTalkController) resetLocked() {
+	for _, timer := range c.holds {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	clear(c.holds)
+	if c.tail != nil {
+		c.tail.Stop()
+		c.tail = nil
+	}
+	c.release++
+	c.held = false
+}
+
+// enabled reports whether a push-to-talk mode is set
+func (c *pushToTalkController) enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.settings.Mode != pttModeOff
+}
+
+// silencedLocked reports whether enforced devices are silenced; the caller
+// must hold c.mu
+func (c *pushToTalkController) silencedLocked() bool {
+	switch c.settings.Mode {
+	case pttModeTalk:
+		return !c.held
+	case pttModeMute:
+		return c.held
+	}
+	return false
+}
+
+// status returns the push-to-talk state, or nil if it is off
+func (c *pushToTalkController) status() *pushToTalkStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.settings.Mode == pttModeOff {
+		return nil
+	}
+	return &pushToTalkStatus{Mode: c.settings.Mode, Held: c.held, Silenced: c.silencedLocked()}
+}
+
+// silenced reports whether enforced devices are silenced by push-to-talk
+func (c *pushToTalkController) silenced() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.silencedLocked()
+}
+
+// volume returns the volume to enforce on a device whose target is target:
+// 0% while push-to-talk silences by volume, otherwise the target
+func (c *pushToTalkController) volume(target float32) float32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.silencedLocked() && c.settings.withDefaults().Silence == pttSilenceVolume {
+		return 0
+	}
+	return target
+}
+
+// mutePolicy returns the mute policy push-to-talk imposes on enforced
+// devices, or "" when it silences by volume or is off
+func (c *pushToTalkController) mutePolicy() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.settings.Mode == pttModeOff || c.settings.withDefaults().Silence != pttSilenceMute {
+		return ""
+	}
+	if c.silencedLocked() {
+		return mutePolicyMuted
+	}
+	return mutePolicyUnmuted
+}
+
+// hold makes a holder hold push-to-talk, for at most limit unless limit is
+// 0. Holding again renews the hold.
+func (c *pushToTalkController) hold(holder string, limit time.Duration) error {
+	c.mu.Lock()
+	if c.settings.Mode == pttModeOff {
+		c.mu.Unlock()
+		return errPushToTalkOff
+	}
+
+	if timer := c.holds[holder]; timer != nil {
+		timer.Stop()
+	}
+	var timer stopper
+	if limit > 0 {
+		timer = c.clock.AfterFunc(limit, func() {
+			c.mu.Lock()
+			expired := c.holds[holder] == timer
+			c.mu.Unlock()
+			if expired {
+				slog.Info("Push-to-talk hold expired", "holder", holder)
+				c.unhold(holder)
+			}
+		})
+	}
+	c.holds[holder] = timer
+
+	// A new hold cancels a running release tail
+	if c.tail != nil {
+		c.tail.Stop()
+		c.tail = nil
+	}
+	c.release++
+	changed := !c.held
+	c.held = true
+	c.mu.Unlock()
+
+	if changed {
+		c.changed(true)
+	}
+	return nil
+}
+
+// unhold ends a holder's hold; once no holder is left the devices follow
+// after the release tail
+func (c *pushToTalkController) unhold(holder string) {
+	c.mu.Lock()
+	timer, ok := c.holds[holder]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+	delete(c.holds, holder)
+	if len(c.holds) > 0 || !c.held {
+		c.mu.Unlock()
+		return
+	}
+
+	c.release++
+	release := c.release
+	tail := time.Duration(*c.settings.withDefaults().ReleaseTailMS) * time.Millisecond
+	if tail == 0 {
+		c.held = false
+		c.mu.Unlock()
+		c.changed(false)
+		return
+	}
+	c.tail = c.clock.AfterFunc(tail, func() {
+		c.mu.Lock()
+		if c.release != release {
+			c.mu.Unlock()
+			return
+		}
+		c.tail = nil
+		c.held = false
+		c.mu.Unlock()
+		c.changed(false)
+	})
+	c.mu.Unlock()
+}
+
+// changed reports that push-to-talk was held or released and applies the
+// resulting state to the enforced devices
+func (c *pushToTalkController) changed(held bool) {
+	silenced := c.silenced()
+	evType := eventPushToTalkReleased
+	if held {
+		evType = eventPushToTalkHeld
+	}
+	slog.Debug("Push-to-talk changed", "held", held, "silenced", silenced, "source", sourceUser)
+	events.publish(appEvent{Type: evType, Muted: silenced, Source: sourceUser})
+	c.apply(sourceUser)
+}
+
+// apply silences or restores every enforced, unpaused device according to
+// the push-to-talk state. When silencing by mute, devices whose own mute
+// policy keeps them muted are left alone.
+func (c *pushToTalkController) apply(source string) {
+	c.mu.Lock()
+	silence := c.settings.withDefaults().Silence
+	c.mu.Unlock()
+	silenced := c.silenced()
+
+	state.mu.RLock()
+	names := make(map[string]string)
+	targets := make(map[string]float32)
+	for deviceID, checked := range state.deviceStates {
+		if silence == pttSilenceMute && state.mutePolicies[deviceID] == mutePolicyMuted {
+			continue
+		}
+		if checked && !state.pausedLocked(deviceID) {
+			names[deviceID] = state.deviceNameLocked(deviceID)
+			targets[deviceID] = state.targetLocked(deviceID)
+		}
+	}
+	state.mu.RUnlock()
+
+	for deviceID, name := range names {
+		if silence == pttSilenceMute {
+			if err := setSystemInputMute(deviceID, silenced); err != nil {
+				slog.Error("Failed to apply push-to-talk",
+					"device", name, "device_id", deviceID, "muted", silenced, "source", source, "error", err)
+			}
+			continue
+		}
+
+		// AGC picks up from whatever volume push-to-talk restores
+		volume := c.volume(targets[deviceID])
+		if !silenced && agc.active(deviceID) {
+			continue
+		}
+		if err := setSystemInputLevel(deviceID, volume); err != nil {
+			slog.Error("Failed to apply push-to-talk",
+				"device", name, "device_id", deviceID, "new_volume", volumePercent(volume), "source", source, "error", err)
+		}
+	}
+}
+
+// setPushToTalkMode changes the push-to-talk mode, saves it and applies it.
+// Devices silenced by the previous mode are restored first.
+func setPushToTalkMode(mode string) error {
+	if err := (pushToTalkConfig{Mode: mode}).validate(); err != nil {
+		return err
+	}
+	err := updateConfig(func(cfg *appConfig) {
+		if cfg.PushToTalk == nil {
+			cfg.PushToTalk = &pushToTalkConfig{}
+		}
+		cfg.PushToTalk.Mode = mode
+	})
+	if err != nil {
+		return err
+	}
+
+	ptt.mu.Lock()
+	previous := ptt.settings.Mode
+	if previous == mode {
+		// Keep the holds of the active mode, such as a key being held
+		ptt.mu.Unlock()
+		return nil
+	}
+	wasSilenced := ptt.silencedLocked()
+	ptt.resetLocked()
+	ptt.settings.Mode = mode
+	ptt.mu.Unlock()
+
+	slog.Info("Push-to-talk mode changed", "mode", mode, "previous", previous, "source", sourceUser)
+
+	if mode != pttModeOff {
+		ptt.apply(sourceUser)
+	} else if wasSilenced {
+		ptt.mu.Lock()
+		silence := ptt.settings.withDefaults().Silence
+		ptt.mu.Unlock()
+		ptt.restoreEnforced(silence)
+	}
+	return nil
+}
+
+// restoreEnforced undoes what push-to-talk did to every enforced, unpaused
+// device when it silenced them by silence: muted devices are unmuted unless
+// their mute policy keeps them muted, and devices at 0% get their target back
+func (c *pushToTalkController) restoreEnforced(silence string) {
+	state.mu.RLock()
+	names := make(map[string]string)
+	targets := make(map[string]float32)
+	policies := make(map[string]string)
+	for deviceID, checked := range state.deviceStates {
+		if checked && !state.pausedLocked(deviceID) {
+			names[deviceID] = state.deviceNameLocked(deviceID)
+			targets[deviceID] = state.targetLocked(deviceID)
+			policies[deviceID] = state.mutePolicies[deviceID]
+		}
+	}
+	state.mu.RUnlock()
+
+	for deviceID, name := range names {
+		if silence == pttSilenceMute {
+			muted := policies[deviceID] == mutePolicyMuted
+			if err := setSystemInputMute(deviceID, muted); err != nil {
+				slog.Error("Failed to restore mute state",
+					"device", name, "device_id", deviceID, "muted", muted, "source", sourceUser, "error", err)
+			}
+			continue
+		}
+		if err := setSystemInputLevel(deviceID, targets[deviceID]); err != nil {
+			slog.Error("Failed to restore volume",
+				"device", name, "device_id", deviceID, "new_volume", volumePercent(targets[deviceID]), "source", sourceUser, "error", err)
+		}
+	}
+}
+
+// parseHoldDuration parses how long an API hold lasts unless renewed, where
+// an empty string selects the default limit
+func parseHoldDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultPTTHoldLimit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid hold duration %q: expected a positive duration such as 10s", s)
+	}
+	return d, nil
+}
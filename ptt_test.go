@@ -0,0 +1,209 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// tailMS returns a release tail setting
+func tailMS(ms int) *int { return &ms }
+
+func TestSetPushToTalkModeKeepsHoldsWhenUnchanged(t *testing.T) {
+	c, clock := useTestPushToTalk(t, pushToTalkConfig{Mode: pttModeTalk})
+	if err := c.hold("key", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setPushToTalkMode(pttModeTalk); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.status(); st == nil || !st.Held || st.Silenced {
+		t.Fatalf("status after choosing the same mode = %+v, want held and live", st)
+	}
+
+	// Releasing the key still ends the hold
+	c.unhold("key")
+	clock.advance(time.Second)
+	if st := c.status(); st.Held || !st.Silenced {
+		t.Errorf("status after the release = %+v, want released and silenced", st)
+	}
+}
+
+func TestSetPushToTalkModeResetsHoldsOnChange(t *testing.T) {
+	c, _ := useTestPushToTalk(t, pushToTalkConfig{Mode: pttModeTalk})
+	if err := c.hold("key", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setPushToTalkMode(pttModeMute); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.status(); st == nil || st.Held || st.Silenced {
+		t.Fatalf("status after switching to push-to-mute = %+v, want released and live", st)
+	}
+}
+
+func TestPushToTalkReleaseTail(t *testing.T) {
+	useTestState(t)
+	c, clock := useTestPushToTalk(t, pushToTalkConfig{Mode: pttModeTalk})
+	ch, unsubscribe := events.subscribe(16)
+	defer unsubscribe()
+
+	if err := c.hold(pttHolderHotkey, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-ch; ev.Type != eventPushToTalkHeld || ev.Muted {
+		t.Fatalf("event on hold = %s muted %v, want %s live", ev.Type, ev.Muted, eventPushToTalkHeld)
+	}
+
+	// The devices stay live for the default tail after the release
+	c.unhold(pttHolderHotkey)
+	clock.advance(defaultPTTReleaseTailMS*time.Millisecond - time.Millisecond)
+	if st := c.status(); !st.Held || st.Silenced {
+		t.Fatalf("status within the release tail = %+v, want held and live", st)
+	}
+	clock.advance(time.Millisecond)
+	if st := c.status(); st.Held || !st.Silenced {
+		t.Fatalf("status after the release tail = %+v, want released and silenced", st)
+	}
+	if ev := <-ch; ev.Type != eventPushToTalkReleased || !ev.Muted {
+		t.Errorf("event after the tail = %s muted %v, want %s silenced", ev.Type, ev.Muted, eventPushToTalkReleased)
+	}
+
+	// Holding again within the tail cancels the release
+	if err := c.hold(pttHolderHotkey, 0); err != nil {
+		t.Fatal(err)
+	}
+	c.unhold(pttHolderHotkey)
+	clock.advance(100 * time.Millisecond)
+	if err := c.hold(pttHolderHotkey, 0); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Minute)
+	if st := c.status(); !st.Held {
+		t.Errorf("status after holding again within the tail = %+v, want held", st)
+	}
+}
+
+func TestPushToTalkWithoutReleaseTail(t *testing.T) {
+	useTestState(t)
+	c, _ := useTestPushToTalk(t, pushToTalkConfig{Mode: pttModeMute, ReleaseTailMS: tailMS(0)})
+
+	if err := c.hold(pttHolderHotkey, 0); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.status(); !st.Held || !st.Silenced {
+		t.Fatalf("status while held = %+v, want held and silenced", st)
+	}
+	c.unhold(pttHolderHotkey)
+	if st := c.status(); st.Held || st.Silenced {
+		t.Errorf("status right after the release = %+v, want released and live", st)
+	}
+}
+
+func TestPushToTalkHoldExpiry(t *testing.T) {
+	useTestState(t)
+	c, clock := useTestPushToTalk(t, pushToTalkConfig{Mode: pttModeTalk, ReleaseTailMS: tailMS(0)})
+
+	if err := c.hold(pttHolderAPI, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(9 * time.Second)
+
+	// Holding again renews the hold for another full limit
+	if err := c.hold(pttHolderAPI, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(9 * time.Second)
+	if st := c.status(); !st.Held {
+		t.Fatalf("status before the renewed hold expires = %+v, want held", st)
+	}
+	clock.advance(time.Second)
+	if st := c.status(); st.Held || !st.Silenced {
+		t.Fatalf("status after the hold expired = %+v, want released and silenced", st)
+	}
+
+	// A hotkey held until released outlasts an expiring API hold
+	if err := c.hold(pttHolderHotkey, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.hold(pttHolderAPI, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Minute)
+	if st := c.status(); !st.Held {
+		t.Errorf("status with the hotkey still held = %+v, want held", st)
+	}
+}
+
+func TestPushToTalkHoldWhenOff(t *testing.T) {
+	c, _ := useTestPushToTalk(t, pushToTalkConfig{})
+	if err := c.hold(pttHolderAPI, time.Second); err != errPushToTalkOff {
+		t.Errorf("hold with push-to-talk off = %v, want %v", err, errPushToTalkOff)
+	}
+	if c.status() != nil {
+		t.Errorf("status with push-to-talk off = %+v, want nil", c.status())
+	}
+}
+
+func TestPushToTalkEnforcedState(t *testing.T) {
+	st := useTestState(t)
+	st.deviceStates["mic"] = true
+
+	// Silencing by volume makes the enforcer and listener restore 0%
+	c, _ := useTestPushToTalk(t, pushToTalkConfig{Mode: pttModeTalk, Silence: pttSilenceVolume, ReleaseTailMS: tailMS(0)})
+	if got := c.volume(0.8); got != 0 {
+		t.Errorf("volume while silenced = %v, want 0", got)
+	}
+	if target, enforced := listenerTarget(); !enforced || target != 0 {
+		t.Errorf("listenerTarget while silenced = %v, %v, want 0, true", target, enforced)
+	}
+	if got := c.mutePolicy(); got != "" {
+		t.Errorf("mutePolicy when silencing by volume = %q, want none", got)
+	}
+	if err := c.hold(pttHolderHotkey, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.volume(0.8); got != 0.8 {
+		t.Errorf("volume while held = %v, want the target", got)
+	}
+	if target, _ := listenerTarget(); target != targetVolumeLevel {
+		t.Errorf("listenerTarget while held = %v, want %v", target, targetVolumeLevel)
+	}
+
+	// Silencing by mute imposes a mute policy, except on devices whose own
+	// policy keeps them muted
+	c.settings.Silence = pttSilenceMute
+	st.mutePolicies["kept"] = mutePolicyMuted
+	if got := c.mutePolicy(); got != mutePolicyUnmuted {
+		t.Fatalf("mutePolicy while held = %q, want %q", got, mutePolicyUnmuted)
+	}
+	if got := st.mutePolicyLocked("mic", c.mutePolicy()); got != mutePolicyUnmuted {
+		t.Errorf("mute policy of an enforced device while held = %q, want %q", got, mutePolicyUnmuted)
+	}
+	if got := st.mutePolicyLocked("kept", c.mutePolicy()); got != mutePolicyMuted {
+		t.Errorf("mute policy of a device kept muted while held = %q, want %q", got, mutePolicyMuted)
+	}
+	c.unhold(pttHolderHotkey)
+	if got := st.mutePolicyLocked("mic", c.mutePolicy()); got != mutePolicyMuted {
+		t.Errorf("mute policy of an enforced device after the release = %q, want %q", got, mutePolicyMuted)
+	}
+
+	// Paused enforcement does not restore anything
+	st.paused = true
+	if _, enforced := listenerTarget(); enforced {
+		t.Error("listenerTarget enforces while paused")
+	}
+}
+
+func TestPushToTalkConfigReleaseTail(t *testing.T) {
+	if got := *(pushToTalkConfig{}).withDefaults().ReleaseTailMS; got != defaultPTTReleaseTailMS {
+		t.Errorf("default release tail = %d, want %d", got, defaultPTTReleaseTailMS)
+	}
+	if got := *(pushToTalkConfig{ReleaseTailMS: tailMS(0)}).withDefaults().ReleaseTailMS; got != 0 {
+		t.Errorf("release tail set to 0 = %d, want 0", got)
+	}
+	if err := (pushToTalkConfig{ReleaseTailMS: tailMS(-1)}).validate(); err == nil {
+		t.Error("negative release tail accepted")
+	}
+}
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcAppError       = -32000
+)
+
+// controlDialTimeout bounds how long the CLI waits to reach a running instance
+const controlDialTimeout = 500 * time.Millisecond
+
+// rpcRequest is a JSON-RPC 2.0 request or notification
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response, or a server notification when
+// Method is set
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcError is a JSON-RPC 2.0 error object
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// Parameters accepted by the control methods
+type (
+	rpcDeviceParams struct {
+		Device string `json:"device"`
+	}
+	rpcLevelParams struct {
+		Device string  `json:"device"`
+		Level  float64 `json:"level"` // percent, 0-100
+	}
+	rpcMuteParams struct {
+		Device string `json:"device"`
+		Muted  bool   `json:"muted"`
+	}
+	rpcAGCParams struct {
+		Device string `json:"device"`
+		agcConfig
+	}
+	rpcPauseParams struct {
+		Device   string `json:"device,omitempty"`   // all devices if empty
+		Duration string `json:"duration,omitempty"` // e.g. "5m"; until resumed if empty
+	}
+	rpcTalkParams struct {
+		Duration string `json:"duration,omitempty"` // hold limit, e.g. "10s"; 30s if empty
+	}
+	rpcActivateParams struct {
+		Args []string `json:"args"`
+	}
+)
+
+// rpcHandler implements a single control method
+type rpcHandler func(params json.RawMessage) (any, error)
+
+// rpcMethods maps method names to their handlers; "events.subscribe" is
+// handled by the connection loop because it streams notifications
+var rpcMethods map[string]rpcHandler
+
+func init() {
+	rpcMethods = map[string]rpcHandler{
+		"status":             rpcStatus,
+		"devices.list":       rpcListDevices,
+		"devices.get":        rpcGetDevice,
+		"devices.setVolume":  rpcSetVolume,
+		"devices.setMute":    rpcSetMute,
+		"devices.check":      rpcCheckDevice,
+		"devices.uncheck":    rpcUncheckDevice,
+		"devices.setTarget":  rpcSetTarget,
+		"devices.setAGC":     rpcSetAGC,
+		"enforcement.pause":  rpcPauseEnforcement,
+		"enforcement.resume": rpcResumeEnforcement,
+		"talk.start":         rpcStartTalking,
+		"talk.stop":          rpcStopTalking,
+		"instance.activate":  rpcActivateInstance,
+		"history.query":      rpcQueryHistory,
+	}
+}
+
+// runtimePath returns the per-user location of a runtime file such as the
+// control socket, using XDG_RUNTIME_DIR when set and the user cache directory otherwise
+func runtimePath(xdgName, cacheName string) (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, xdgName), nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "MicMaxer2", cacheName), nil
+}
+
+// controlSocketPath returns the per-user location of the control socket
+func controlSocketPath() (string, error) {
+	return runtimePath("micmaxer2.sock", "control.sock")
+}
+
+// controlServer serves the JSON-RPC API on a Unix domain socket
+type controlServer struct {
+	listener net.Listener
+	path     string
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// Running control server, if any, guarded like restAPI
+var (
+	controlMu sync.Mutex
+	control   *controlServer
+)
+
+// startControlServer listens on the per-user control socket, replacing a
+// stale socket file left behind by a previous instance. It does nothing if
+// the socket is already being served.
+func startControlServer() error {
+	path, err := controlSocketPath()
+	if err != nil {
+		return err
+	}
+
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	if control != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	// A socket that nobody answers on is left over from a crash
+	if conn, err := net.DialTimeout("unix", path, controlDialTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("another instance is already listening on %s", path)
+	}
+	_ = os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	control = &controlServer{
+		listener: listener,
+		path:     path,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go control.serve()
+
+	slog.Info("Control API listening", "socket", path)
+	return nil
+}
+
+// stopControlServer closes the control socket and all client connections
+func stopControlServer() {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	if control == nil {
+		return
+	}
+
+	control.listener.Close()
+
+	control.mu.Lock()
+	for conn := range control.conns {
+		conn.Close()
+	}
+	control.mu.Unlock()
+
+	control.wg.Wait()
+	_ = os.Remove(control.path)
+	control = nil
+}
+
+// serve accepts client connections until the listener is closed
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("Control API failed to accept connection", "error", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// handleConn reads newline-delimited requests from a client and writes responses
+func (s *controlServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	enc := json.NewEncoder(conn)
+	write := func(resp rpcResponse) {
+		resp.JSONRPC = "2.0"
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_ = enc.Encode(resp)
+	}
+
+	var unsubscribe func()
+	defer func() {
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var req rpcRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			write(rpcResponse{Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			write(rpcResponse{ID: req.ID, Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}})
+			continue
+		}
+
+		if req.Method == "events.subscribe" {
+			if unsubscribe == nil {
+				var ch <-chan appEvent
+				ch, unsubscribe = events.subscribe(64)
+				go func() {
+					for ev := range ch {
+						params, _ := json.Marshal(ev)
+						write(rpcResponse{Method: "event", Params: params})
+					}
+				}()
+			}
+			if req.ID != nil {
+				write(rpcResponse{ID: req.ID, Result: json.RawMessage("true")})
+			}
+			continue
+		}
+
+		resp := dispatchRPC(req)
+		if req.ID != nil {
+			write(resp)
+		}
+	}
+}
+
+// dispatchRPC runs a request against the registered handlers
+func dispatchRPC(req rpcRequest) rpcResponse {
+	resp := rpcResponse{ID: req.ID}
+
+	handler, ok := rpcMethods[req.Method]
+	if !ok {
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+		return resp
+	}
+
+	result, err := handler(req.Params)
+	if err != nil {
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			resp.Error = rpcErr
+		} else {
+			resp.Error = &rpcError{Code: rpcAppError, Message: err.Error()}
+		}
+		return resp
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		resp.Error = &rpcError{Code: rpcAppError, Message: err.Error()}
+		return resp
+	}
+	resp.Result = data
+	return resp
+}
+
+// decodeParams unmarshals request parameters, reporting failures as invalid params
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return &rpcError{Code: rpcInvalidParams, Message: "missing params"}
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// resolveParamsDevice decodes a device selector and resolves it against the scanned devices
+func resolveParamsDevice(params json.RawMessage) (deviceRef, error) {
+	var p rpcDeviceParams
+	if err := decodeParams(params, &p); err != nil {
+		return deviceRef{}, err
+	}
+	return resolveDevice(knownDevices(), p.Device)
+}
+
+func rpcStatus(json.RawMessage) (any, error) {
+	return currentStatus(), nil
+}
+
+func rpcListDevices(json.RawMessage) (any, error) {
+	return currentStatus().Devices, nil
+}
+
+func rpcGetDevice(params json.RawMessage) (any, error) {
+	device, err := resolveParamsDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	return queryDeviceStatus(device), nil
+}
+
+func rpcSetVolume(params json.RawMessage) (any, error) {
+	var p rpcLevelParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Level < 0 || p.Level > 100 {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "level must be between 0 and 100"}
+	}
+	device, err := resolveDevice(knownDevices(), p.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setSystemInputLevel(device.ID, float32(p.Level/100)); err != nil {
+		return nil, err
+	}
+	return queryDeviceStatus(device), nil
+}
+
+func rpcSetMute(params json.RawMessage) (any, error) {
+	var p rpcMuteParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	device, err := resolveDevice(knownDevices(), p.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setSystemInputMute(device.ID, p.Muted); err != nil {
+		return nil, err
+	}
+	return queryDeviceStatus(device), nil
+}
+
+func rpcCheckDevice(params json.RawMessage) (any, error) {
+	device, err := resolveParamsDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	setDeviceChecked(device.ID, true)
+	return queryDeviceStatus(device), nil
+}
+
+func rpcUncheckDevice(params json.RawMessage) (any, error) {
+	device, err := resolveParamsDevice(params)
+	if err != nil {
+		return nil, err
+	}
+	setDeviceChecked(device.ID, false)
+	return queryDeviceStatus(device), nil
+}
+
+func rpcSetTarget(params json.RawMessage) (any, error) {
+	var p rpcLevelParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Level < 0 || p.Level > 100 {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "level must be between 0 and 100"}
+	}
+	device, err := resolveDevice(knownDevices(), p.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setDeviceTarget(device.ID, float32(p.Level/100)); err != nil {
+		return nil, err
+	}
+	return queryDeviceStatus(device), nil
+}
+
+func rpcQueryHistory(params json.RawMessage) (any, error) {
+	var q historyQuery
+	if len(params) > 0 {
+		if err := decodeParams(params, &q); err != nil {
+			return nil, err
+		}
+	}
+	return history.query(q), nil
+}
+
+func rpcSetAGC(params json.RawMessage) (any, error) {
+	var p rpcAGCParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	device, err := resolveDevice(knownDevices(), p.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setDeviceAGC(device.ID, p.agcConfig); err != nil {
+		return nil, err
+	}
+	return queryDeviceStatus(device), nil
+}
+
+func rpcPauseEnforcement(params json.RawMessage) (any, error) {
+	var p rpcPauseParams
+	if len(params) > 0 {
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	d, err := parsePauseDuration(p.Duration)
+	if err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	deviceID, err := pauseDeviceID(p.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	pauseEnforcement(deviceID, d)
+	return currentStatus(), nil
+}
+
+func rpcResumeEnforcement(params json.RawMessage) (any, error) {
+	var p rpcPauseParams
+	if len(params) > 0 {
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	deviceID, err := pauseDeviceID(p.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	resumeEnforcement(deviceID)
+	return currentStatus(), nil
+}
+
+func rpcStartTalking(params json.RawMessage) (any, error) {
+	var p rpcTalkParams
+	if len(params) > 0 {
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	d, err := parseHoldDuration(p.Duration)
+	if err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	if err := ptt.hold(pttHolderAPI, d); err != nil {
+		return nil, err
+	}
+	return currentStatus(), nil
+}
+
+func rpcStopTalking(params json.RawMessage) (any, error) {
+	ptt.unhold(pttHolderAPI)
+	return currentStatus(), nil
+}
+
+// pauseDeviceID resolves the device of a pause request, or "" for all devices
+func pauseDeviceID(selector string) (string, error) {
+	if selector == "" {
+		return "", nil
+	}
+	device, err := resolveDevice(knownDevices(), selector)
+	if err != nil {
+		return "", err
+	}
+	return device.ID, nil
+}
+
+func rpcActivateInstance(params json.RawMessage) (any, error) {
+	var p rpcActivateParams
+	if len(params) > 0 {
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	if err := activateInstance(p.Args); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// rpcClient talks to a running instance over the control socket
+type rpcClient struct {
+	conn    net.Conn
+	enc     *json.Encoder
+	scanner *bufio.Scanner
+	nextID  int
+}
+
+// dialControl connects to the running instance, if there is one
+func dialControl() (*rpcClient, error) {
+	path, err := controlSocketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", path, controlDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &rpcClient{conn: conn, enc: json.NewEncoder(conn), scanner: scanner}, nil
+}
+
+// Close closes the connection to the running instance
+func (c *rpcClient) Close() error {
+	return c.conn.Close()
+}
+
+// call invokes a method and decodes its result into result, which may be nil
+func (c *rpcClient) call(method string, params, result any) error {
+	c.nextID++
+	id := json.RawMessage(fmt.Sprint(c.nextID))
+
+	req := rpcRequest{JSONRPC: "2.0", ID: id, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+	if err := c.enc.Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	for {
+		resp, err := c.read()
+		if err != nil {
+			return err
+		}
+		// Skip event notifications interleaved with the response
+		if resp.Method != "" || string(resp.ID) != string(id) {
+			continue
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	}
+}
+
+// subscribe streams events to fn until the connection is closed
+func (c *rpcClient) subscribe(fn func(appEvent)) error {
+	if err := c.call("events.subscribe", nil, nil); err != nil {
+		return err
+	}
+
+	for {
+		resp, err := c.read()
+		if err != nil {
+			return err
+		}
+		if resp.Method != "event" {
+			continue
+		}
+		var ev appEvent
+		if err := json.Unmarshal(resp.Params, &ev); err != nil {
+			return fmt.Errorf("invalid event: %w", err)
+		}
+		fn(ev)
+	}
+}
+
+// read returns the next message from the server
+func (c *rpcClient) read() (rpcResponse, error) {
+	var resp rpcResponse
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return resp, err
+		}
+		return resp, errors.New("connection closed by MicMaxer")
+	}
+	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return resp, fmt.Errorf("invalid response: %w", err)
+	}
+	return resp, nil
+}
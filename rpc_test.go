@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestControlServer(t *testing.T) {
+	st := useTestState(t)
+	client := startTestControlServer(t)
+
+	t.Run("socket permissions", func(t *testing.T) {
+		info, err := os.Stat(filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "micmaxer2.sock"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Type() != os.ModeSocket || info.Mode().Perm() != 0o600 {
+			t.Errorf("socket mode = %v, want a socket with permissions 0600", info.Mode())
+		}
+	})
+
+	t.Run("second instance", func(t *testing.T) {
+		// Starting again in this process does nothing
+		if err := startControlServer(); err != nil {
+			t.Errorf("startControlServer() while running: %v", err)
+		}
+
+		// Another process finds the socket in use
+		controlMu.Lock()
+		saved := control
+		control = nil
+		controlMu.Unlock()
+		err := startControlServer()
+		controlMu.Lock()
+		if control != nil {
+			control.listener.Close()
+		}
+		control = saved
+		controlMu.Unlock()
+		if err == nil {
+			t.Error("a second server took over a socket that is in use")
+		}
+	})
+
+	t.Run("concurrent starts", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := startControlServer(); err != nil {
+					t.Errorf("startControlServer: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if err := client.call("status", nil, nil); err != nil {
+			t.Errorf("status after concurrent starts: %v", err)
+		}
+	})
+
+	t.Run("call", func(t *testing.T) {
+		st.mu.Lock()
+		st.paused = true
+		st.mu.Unlock()
+		defer func() {
+			st.mu.Lock()
+			st.paused = false
+			st.mu.Unlock()
+		}()
+
+		var status appStatus
+		if err := client.call("status", nil, &status); err != nil {
+			t.Fatal(err)
+		}
+		if !status.Paused || status.PausedUntil != nil || len(status.Devices) != 0 {
+			t.Errorf("status = %+v, want paused until resumed without devices", status)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			method string
+			params any
+			code   int
+		}{
+			{"devices.reboot", nil, rpcMethodNotFound},
+			{"devices.setVolume", nil, rpcInvalidParams},
+			{"devices.setVolume", map[string]any{"device": "mic", "level": "loud"}, rpcInvalidParams},
+			{"devices.setVolume", rpcLevelParams{Device: "mic", Level: 150}, rpcInvalidParams},
+			{"enforcement.pause", rpcPauseParams{Duration: "soon"}, rpcInvalidParams},
+			{"devices.get", rpcDeviceParams{Device: "mic"}, rpcAppError},
+		}
+		for _, tt := range tests {
+			err := client.call(tt.method, tt.params, nil)
+			var rpcErr *rpcError
+			if !errors.As(err, &rpcErr) || rpcErr.Code != tt.code {
+				t.Errorf("%s(%v) error = %v, want code %d", tt.method, tt.params, err, tt.code)
+			}
+		}
+
+		// The connection survives errors and malformed requests
+		if _, err := client.conn.Write([]byte("not json\n")); err != nil {
+			t.Fatal(err)
+		}
+		if resp, err := client.read(); err != nil || resp.Error == nil || resp.Error.Code != rpcParseError {
+			t.Errorf("response to malformed request = %+v, %v, want code %d", resp, err, rpcParseError)
+		}
+		if err := client.call("status", nil, nil); err != nil {
+			t.Errorf("status after errors: %v", err)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		if err := client.call("events.subscribe", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// An event published while a request is in flight arrives as a
+		// notification alongside the reply
+		events.publish(appEvent{Type: eventVolumeCorrected, DeviceID: "test-mic", Volume: 40, Target: 90, Source: sourceEnforcer})
+		if err := client.enc.Encode(rpcRequest{JSONRPC: "2.0", ID: json.RawMessage(`"next"`), Method: "status"}); err != nil {
+			t.Fatal(err)
+		}
+
+		var notified, replied bool
+		for !notified || !replied {
+			resp, err := client.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case resp.Method == "event":
+				var ev appEvent
+				if err := json.Unmarshal(resp.Params, &ev); err != nil {
+					t.Fatal(err)
+				}
+				if ev.Type != eventVolumeCorrected || ev.DeviceID != "test-mic" || ev.Volume != 40 {
+					t.Errorf("event = %+v, want the published correction", ev)
+				}
+				notified = true
+			case string(resp.ID) == `"next"`:
+				if resp.Error != nil {
+					t.Errorf("status error = %v", resp.Error)
+				}
+				replied = true
+			default:
+				t.Fatalf("unexpected message %+v", resp)
+			}
+		}
+
+		// Calls skip notifications that arrive before their reply
+		events.publish(appEvent{Type: eventInstanceActivated})
+		var status appStatus
+		if err := client.call("status", nil, &status); err != nil {
+			t.Errorf("status with a pending notification: %v", err)
+		}
+	})
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduleWeekdays maps the day names accepted in schedules to weekdays
+var scheduleWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// scheduleWindow is a time range on some days during which a device is
+// enforced, as written in the config file
+type scheduleWindow struct {
+	Days   string `json:"days,omitempty"`   // e.g. "mon-fri" or "sat,sun"; every day if empty
+	From   string `json:"from"`             // start time, e.g. "09:00"
+	To     string `json:"to"`               // end time, e.g. "18:00"; before From to run past midnight
+	Target *int   `json:"target,omitempty"` // percent while the window is open; the device's target if unset
+}
+
+// window is a parsed scheduleWindow
+type window struct {
+	days   [7]bool // indexed by time.Weekday
+	from   int     // minutes after midnight
+	to     int     // minutes after midnight, past 24h for windows ending the next day
+	target *float32
+}
+
+// schedule is the set of windows during which a device is enforced; outside
+// all of them the device is released. The first open window sets the target.
+type schedule []window
+
+// parseSchedule parses the windows of a schedule
+func parseSchedule(windows []scheduleWindow) (schedule, error) {
+	s := make(schedule, 0, len(windows))
+	for _, sw := range windows {
+		w, err := parseWindow(sw)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, w)
+	}
+	return s, nil
+}
+
+// parseWindow parses a single schedule window
+func parseWindow(sw scheduleWindow) (window, error) {
+	var w window
+	days, err := parseScheduleDays(sw.Days)
+	if err != nil {
+		return window{}, err
+	}
+	w.days = days
+
+	if w.from, err = parseClockTime(sw.From); err != nil {
+		return window{}, err
+	}
+	if w.to, err = parseClockTime(sw.To); err != nil {
+		return window{}, err
+	}
+	if w.to == w.from {
+		return window{}, fmt.Errorf("invalid schedule window %s-%s: it is empty", sw.From, sw.To)
+	}
+	if w.to < w.from {
+		w.to += 24 * 60
+	}
+
+	if sw.Target != nil {
+		if *sw.Target < 0 || *sw.Target > 100 {
+			return window{}, fmt.Errorf("invalid schedule target %d: must be between 0 and 100", *sw.Target)
+		}
+		target := float32(*sw.Target) / 100
+		w.target = &target
+	}
+	return w, nil
+}
+
+// parseScheduleDays parses a list of days and day ranges such as
+// "mon-fri,sun"; an empty list means every day
+func parseScheduleDays(s string) ([7]bool, error) {
+	var days [7]bool
+	if strings.TrimSpace(s) == "" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, part := range strings.Split(strings.ToLower(strings.ReplaceAll(s, " ", "")), ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, ok := scheduleWeekdays[first]
+		if !ok {
+			return days, fmt.Errorf("invalid schedule days %q: unknown day %q", s, first)
+		}
+		end := start
+		if isRange {
+			if end, ok = scheduleWeekdays[last]; !ok {
+				return days, fmt.Errorf("invalid schedule days %q: unknown day %q", s, last)
+			}
+		}
+		// Ranges may wrap around the weekend, as in "fri-mon"
+		for d := start; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == end {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClockTime parses a time of day such as "09:00" or "24:00" into minutes
+// after midnight
+func parseClockTime(s string) (int, error) {
+	hours, minutes, ok := strings.Cut(s, ":")
+	h, herr := strconv.Atoi(hours)
+	m, merr := strconv.Atoi(minutes)
+	if !ok || herr != nil || merr != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid schedule time %q: expected a time such as 09:00", s)
+	}
+	return h*60 + m, nil
+}
+
+// openAt returns the window open at t, checking windows that started the
+// day before for those running past midnight
+func (s schedule) openAt(t time.Time) (window, bool) {
+	minute := t.Hour()*60 + t.Minute()
+	yesterday := (t.Weekday() + 6) % 7
+	for _, w := range s {
+		if w.days[t.Weekday()] && minute >= w.from && minute < w.to {
+			return w, true
+		}
+		if w.days[yesterday] && minute+24*60 < w.to {
+			return w, true
+		}
+	}
+	return window{}, false
+}
+
+// nextChange returns the first time after t at which the device is released
+// or enforced or its target changes, or the zero time if that never happens
+func (s schedule) nextChange(t time.Time) time.Time {
+	var boundaries []time.Time
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for offset := -1; offset <= 7; offset++ {
+		day := midnight.AddDate(0, 0, offset)
+		for _, w := range s {
+			if !w.days[day.Weekday()] {
+				continue
+			}
+			for _, minute := range []int{w.from, w.to} {
+				if at := time.Date(day.Year(), day.Month(), day.Day(), 0, minute, 0, 0, t.Location()); at.After(t) {
+					boundaries = append(boundaries, at)
+				}
+			}
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i].Before(boundaries[j]) })
+
+	// Adjacent windows with the same target, such as one open around the
+	// clock, do not change anything where they meet
+	for _, at := range boundaries {
+		before, wasOpen := s.openAt(at.Add(-time.Minute))
+		after, isOpen := s.openAt(at)
+		if wasOpen != isOpen || wasOpen && !sameTarget(before.target, after.target) {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+// scheduleState is where a device stands in its schedule
+type scheduleState struct {
+	released bool      // outside all windows, so not enforced
+	target   *float32  // target of the open window, nil for the device's own
+	until    time.Time // when the window closes or the next opens; zero if never
+}
+
+// scheduler releases and enforces devices according to their schedules.
+// The periodic enforcer evaluates it on every pass and whenever a window
+// opens or closes.
+type scheduler struct {
+	clock    clock
+	mu       sync.Mutex
+	devices  map[string]schedule // schedules of single devices
+	fallback schedule            // schedule of enforced devices without their own
+	modTime  time.Time           // of the config file the schedules were read from
+}
+
+// Global scheduler instance
+var schedules = &scheduler{clock: systemClock{}}
+
+// load reads the schedules from the config file, skipping invalid ones
+func (s *scheduler) load() {
+	modTime := configModTime()
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+	}
+	devices, fallback := loadSchedules(cfg)
+
+	s.mu.Lock()
+	s.devices = devices
+	s.fallback = fallback
+	s.modTime = modTime
+	s.mu.Unlock()
+
+	if len(devices) > 0 || len(fallback) > 0 {
+		slog.Info("Loaded enforcement schedules", "devices", len(devices), "default", len(fallback) > 0)
+	}
+}
+
+// reload reads the schedules again if the config file changed since they
+// were last read, so edits take effect without a restart
+func (s *scheduler) reload() {
+	modTime := configModTime()
+	s.mu.Lock()
+	changed := !modTime.Equal(s.modTime)
+	s.mu.Unlock()
+	if changed {
+		slog.Debug("Config file changed, reloading schedules")
+		s.load()
+	}
+}
+
+// configModTime returns when the config file was last modified, or the zero
+// time if it does not exist
+func configModTime() time.Time {
+	path, err := configPath()
+	if err != nil {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// loadSchedules parses the device and default schedules of a config,
+// logging and skipping invalid ones
+func loadSchedules(cfg *appConfig) (map[string]schedule, schedule) {
+	devices := make(map[string]schedule)
+	for deviceID, dc := range cfg.Devices {
+		if len(dc.Schedule) == 0 {
+			continue
+		}
+		sched, err := parseSchedule(dc.Schedule)
+		if err != nil {
+			slog.Warn("Ignoring device schedule", "device_id", deviceID, "error", err)
+			continue
+		}
+		devices[deviceID] = sched
+	}
+
+	fallback, err := parseSchedule(cfg.Schedule)
+	if err != nil {
+		slog.Warn("Ignoring default schedule", "error", err)
+		fallback = nil
+	}
+	return devices, fallback
+}
+
+// scheduleFor returns the schedule of a device, if any
+func (s *scheduler) scheduleFor(deviceID string) schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sched, ok := s.devices[deviceID]; ok {
+		return sched
+	}
+	return s.fallback
+}
+
+// at returns where a device stands in its schedule now, and false if it has
+// no schedule
+func (s *scheduler) at(deviceID string) (scheduleState, bool) {
+	sched := s.scheduleFor(deviceID)
+	if len(sched) == 0 {
+		return scheduleState{}, false
+	}
+	return sched.stateAt(s.clock.Now()), true
+}
+
+// stateAt returns where a schedule stands at t
+func (sched schedule) stateAt(t time.Time) scheduleState {
+	w, open := sched.openAt(t)
+	return scheduleState{released: !open, target: w.target, until: sched.nextChange(t)}
+}
+
+// evaluate updates the schedule state of every enforced device, publishing
+// an event for each window opened or closed. It returns when the next
+// window opens or closes, or the zero time if none will, and whether a
+// device started being enforced or changed target.
+func (s *scheduler) evaluate() (time.Time, bool) {
+	now := s.clock.Now()
+
+	var evs []appEvent
+	enforce := false
+
+	state.mu.Lock()
+	for deviceID, checked := range state.deviceStates {
+		sched := s.scheduleFor(deviceID)
+		previous, had := state.scheduled[deviceID]
+		if !checked || len(sched) == 0 {
+			delete(state.scheduled, deviceID)
+			continue
+		}
+
+		current := sched.stateAt(now)
+		state.scheduled[deviceID] = current
+
+		ev := appEvent{DeviceID: deviceID, DeviceName: state.deviceNameLocked(deviceID), Source: sourceSchedule}
+		if !current.until.IsZero() {
+			until := current.until
+			ev.Until = &until
+		}
+		switch {
+		case current.released && (!had || !previous.released):
+			ev.Type = eventScheduleEnded
+		case !current.released && (!had || previous.released || !sameTarget(previous.target, current.target)):
+			ev.Type = eventScheduleStarted
+			ev.Target = volumePercent(state.targetLocked(deviceID))
+			enforce = true
+		default:
+			continue
+		}
+		evs = append(evs, ev)
+	}
+	state.mu.Unlock()
+
+	for _, ev := range evs {
+		attrs := []any{"device", ev.DeviceName, "device_id", ev.DeviceID, "source", sourceSchedule}
+		if ev.Until != nil {
+			attrs = append(attrs, "until", ev.Until.Format(time.RFC3339))
+		}
+		if ev.Type == eventScheduleStarted {
+			slog.Info("Scheduled enforcement started", append(attrs, "target", ev.Target)...)
+		} else {
+			slog.Info("Scheduled enforcement ended", attrs...)
+		}
+		events.publish(ev)
+	}
+	return s.nextChange(now), enforce
+}
+
+// nextChange returns the first time after t at which any schedule changes,
+// including those of devices not enforced yet, or the zero time if none will
+func (s *scheduler) nextChange(t time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.fallback.nextChange(t)
+	for _, sched := range s.devices {
+		if at := sched.nextChange(t); !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+	return next
+}
+
+// sameTarget reports whether two window targets are the same
+func sameTarget(a, b *float32) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// releasedLocked reports whether a device is outside its scheduled windows;
+// the caller must hold s.mu
+func (s *audioState) releasedLocked(deviceID string) bool {
+	return s.scheduled[deviceID].released
+}
+
+// scheduleStatus reports where a device stands in its schedule
+type scheduleStatus struct {
+	Active bool       `json:"active"`           // inside a window, so enforced
+	Target *int       `json:"target,omitempty"` // percent set by the open window, if any
+	Until  *time.Time `json:"until,omitempty"`  // when the window closes or the next opens
+}
+
+// scheduleStatusLocked returns the schedule status of a device, or nil if it
+// has no schedule; the caller must hold s.mu
+func (s *audioState) scheduleStatusLocked(deviceID string) *scheduleStatus {
+	current, ok := s.scheduled[deviceID]
+	if !ok {
+		return nil
+	}
+	status := &scheduleStatus{Active: !current.released}
+	if current.target != nil {
+		target := volumePercent(*current.target)
+		status.Target = &target
+	}
+	if !current.until.IsZero() {
+		until := current.until
+		status.Until = &until
+	}
+	return status
+}
+
+// describeSchedule summarises where a device stands in its schedule; a nil
+// target means the window keeps the device's own
+func describeSchedule(active bool, target *int, until *time.Time, now time.Time) string {
+	switch {
+	case !active:
+		return "released " + scheduleUntilLabel(until, now)
+	case target != nil:
+		return fmt.Sprintf("enforced at %d%% %s", *target, scheduleUntilLabel(until, now))
+	default:
+		return "enforced " + scheduleUntilLabel(until, now)
+	}
+}
+
+// scheduleUntilLabel describes when a schedule changes next, relative to now
+func scheduleUntilLabel(until *time.Time, now time.Time) string {
+	if until == nil {
+		return "at all times"
+	}
+	t := until.Local()
+	y1, m1, d1 := t.Date()
+	y2, m2, d2 := now.Local().Date()
+	if y1 == y2 && m1 == m2 && d1 == d2 {
+		return "until " + t.Format("15:04")
+	}
+	return "until " + t.Format("Mon 15:04")
+}
+
+// parseScheduleTime parses the time to evaluate schedules at: a time of day
+// today ("14:00"), a weekday and time in the current week ("mon 09:30") or
+// an RFC 3339 timestamp
+func parseScheduleTime(s string, now time.Time) (time.Time, error) {
+	if day, clock, ok := strings.Cut(strings.ToLower(strings.TrimSpace(s)), " "); ok {
+		if weekday, ok := scheduleWeekdays[day]; ok {
+			t, err := parseScheduleTime(clock, now)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return t.AddDate(0, 0, int(weekday-now.Weekday())), nil
+		}
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected a time such as 14:00, a day and time such as \"mon 09:30\" or an RFC 3339 timestamp", s)
+}
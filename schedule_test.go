@@ -0,0 +1,245 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns a time in week, such as at(time.Friday, 23, 30)
+func at(day time.Weekday, hour, minute int) time.Time {
+	return week.AddDate(0, 0, (int(day)+6)%7).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+func percent(p int) *int { return &p }
+
+func mustParseSchedule(t *testing.T, windows ...scheduleWindow) schedule {
+	t.Helper()
+	s, err := parseSchedule(windows)
+	if err != nil {
+		t.Fatalf("parseSchedule: %v", err)
+	}
+	return s
+}
+
+// testSchedule has weekday hours, a Friday night window running past
+// midnight and two overlapping weekend windows
+func testSchedule(t *testing.T) schedule {
+	return mustParseSchedule(t,
+		scheduleWindow{Days: "mon-fri", From: "09:00", To: "17:00"},
+		scheduleWindow{Days: "fri", From: "22:00", To: "02:00", Target: percent(50)},
+		scheduleWindow{Days: "sat,sun", From: "10:00", To: "12:00", Target: percent(30)},
+		scheduleWindow{Days: "sat", From: "11:00", To: "13:00"},
+	)
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	for _, sw := range []scheduleWindow{
+		{From: "09:00", To: "09:00"},
+		{From: "9", To: "17:00"},
+		{From: "09:00", To: "24:01"},
+		{From: "09:60", To: "17:00"},
+		{Days: "mon-fry", From: "09:00", To: "17:00"},
+		{From: "09:00", To: "17:00", Target: percent(101)},
+	} {
+		if _, err := parseWindow(sw); err == nil {
+			t.Errorf("parseWindow(%+v) succeeded, want an error", sw)
+		}
+	}
+}
+
+func TestParseScheduleDaysWraps(t *testing.T) {
+	days, err := parseScheduleDays("fri-mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [7]bool{time.Sunday: true, time.Monday: true, time.Friday: true, time.Saturday: true}
+	if days != want {
+		t.Errorf("parseScheduleDays(fri-mon) = %v, want %v", days, want)
+	}
+}
+
+func TestScheduleOpenAt(t *testing.T) {
+	s := testSchedule(t)
+	tests := []struct {
+		name   string
+		at     time.Time
+		open   bool
+		target *int
+	}{
+		{"before weekday hours", at(time.Monday, 8, 59), false, nil},
+		{"weekday hours start", at(time.Monday, 9, 0), true, nil},
+		{"weekday hours end", at(time.Monday, 17, 0), false, nil},
+		{"friday night", at(time.Friday, 23, 0), true, percent(50)},
+		{"past midnight", at(time.Saturday, 1, 59), true, percent(50)},
+		{"closed after midnight", at(time.Saturday, 2, 0), false, nil},
+		{"not on the day after other days", at(time.Tuesday, 1, 0), false, nil},
+		{"weekday window on the weekend", at(time.Saturday, 9, 30), false, nil},
+		{"first overlapping window wins", at(time.Saturday, 11, 30), true, percent(30)},
+		{"second overlapping window", at(time.Saturday, 12, 30), true, nil},
+		{"overlap only on saturdays", at(time.Sunday, 12, 30), false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, open := s.openAt(tt.at)
+			if open != tt.open {
+				t.Fatalf("openAt(%s) open = %v, want %v", tt.at.Format("Mon 15:04"), open, tt.open)
+			}
+			if got := targetPercent(w.target); !sameInt(got, tt.target) {
+				t.Errorf("openAt(%s) target = %v, want %v", tt.at.Format("Mon 15:04"), deref(got), deref(tt.target))
+			}
+		})
+	}
+}
+
+func TestScheduleNextChange(t *testing.T) {
+	s := testSchedule(t)
+	tests := []struct {
+		name string
+		at   time.Time
+		want time.Time
+	}{
+		{"weekday hours close", at(time.Monday, 12, 0), at(time.Monday, 17, 0)},
+		{"next weekday opens", at(time.Monday, 17, 0), at(time.Tuesday, 9, 0)},
+		{"friday night opens", at(time.Friday, 17, 0), at(time.Friday, 22, 0)},
+		{"across midnight", at(time.Friday, 23, 0), at(time.Saturday, 2, 0)},
+		{"weekend opens", at(time.Saturday, 2, 0), at(time.Saturday, 10, 0)},
+		{"target changes in overlap", at(time.Saturday, 10, 30), at(time.Saturday, 12, 0)},
+		{"overlap closes", at(time.Saturday, 12, 30), at(time.Saturday, 13, 0)},
+		{"across the week", at(time.Sunday, 12, 0), at(time.Monday, 9, 0).AddDate(0, 0, 7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.nextChange(tt.at); !got.Equal(tt.want) {
+				t.Errorf("nextChange(%s) = %s, want %s", tt.at.Format("Mon 15:04"), got.Format("Mon Jan 2 15:04"), tt.want.Format("Mon Jan 2 15:04"))
+			}
+		})
+	}
+}
+
+func TestScheduleNextChangeAdjacentWindows(t *testing.T) {
+	same := mustParseSchedule(t,
+		scheduleWindow{From: "00:00", To: "12:00"},
+		scheduleWindow{From: "12:00", To: "24:00"},
+	)
+	if got := same.nextChange(at(time.Monday, 11, 0)); !got.IsZero() {
+		t.Errorf("nextChange of a schedule open around the clock = %s, want never", got)
+	}
+
+	different := mustParseSchedule(t,
+		scheduleWindow{From: "00:00", To: "12:00", Target: percent(40)},
+		scheduleWindow{From: "12:00", To: "24:00"},
+	)
+	if got, want := different.nextChange(at(time.Monday, 11, 0)), at(time.Monday, 12, 0); !got.Equal(want) {
+		t.Errorf("nextChange where the target changes = %s, want %s", got, want)
+	}
+}
+
+func TestScheduleDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	s := mustParseSchedule(t,
+		scheduleWindow{From: "09:00", To: "17:00"},
+		scheduleWindow{Days: "sun", From: "01:00", To: "03:00", Target: percent(20)},
+	)
+
+	// Clocks go forward from 02:00 to 03:00 on 8 March 2026
+	if got, want := s.nextChange(time.Date(2026, 3, 7, 20, 0, 0, 0, loc)), time.Date(2026, 3, 8, 1, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("nextChange before the change = %s, want %s", got, want)
+	}
+	inside := time.Date(2026, 3, 8, 1, 30, 0, 0, loc)
+	if _, open := s.openAt(inside); !open {
+		t.Errorf("openAt(%s) is closed, want open", inside)
+	}
+	if got, want := s.nextChange(inside), time.Date(2026, 3, 8, 3, 0, 0, 0, loc); !got.Equal(want) || got.Sub(inside) != 30*time.Minute {
+		t.Errorf("nextChange across the change = %s, want %s half an hour later", got, want)
+	}
+	if got, want := s.nextChange(time.Date(2026, 3, 8, 3, 0, 0, 0, loc)), time.Date(2026, 3, 8, 9, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("nextChange after the change = %s, want %s", got, want)
+	}
+
+	// Clocks go back from 02:00 to 01:00 on 1 November 2026
+	if got, want := s.nextChange(time.Date(2026, 10, 31, 17, 0, 0, 0, loc)), time.Date(2026, 11, 1, 1, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("nextChange before the change = %s, want %s", got, want)
+	}
+	if got, want := s.nextChange(time.Date(2026, 11, 1, 3, 0, 0, 0, loc)), time.Date(2026, 11, 1, 9, 0, 0, 0, loc); !got.Equal(want) || got.Sub(time.Date(2026, 11, 1, 3, 0, 0, 0, loc)) != 6*time.Hour {
+		t.Errorf("nextChange after the change = %s, want %s", got, want)
+	}
+}
+
+func TestSchedulerClock(t *testing.T) {
+	s := &scheduler{
+		clock:    fixedClock(at(time.Friday, 23, 0)),
+		devices:  map[string]schedule{"mic": testSchedule(t)},
+		fallback: mustParseSchedule(t, scheduleWindow{Days: "mon-fri", From: "08:00", To: "20:00"}),
+	}
+
+	current, ok := s.at("mic")
+	if !ok {
+		t.Fatal("at(mic) has no schedule")
+	}
+	if current.released || targetPercent(current.target) == nil || *targetPercent(current.target) != 50 {
+		t.Errorf("at(mic) = %+v, want enforced at 50%%", current)
+	}
+	if want := at(time.Saturday, 2, 0); !current.until.Equal(want) {
+		t.Errorf("at(mic) until = %s, want %s", current.until, want)
+	}
+
+	current, ok = s.at("other")
+	if !ok || !current.released {
+		t.Errorf("at(other) = %+v, %v, want released by the default schedule", current, ok)
+	}
+	// The default schedule opens on Monday, but mic's opens first
+	if want := at(time.Sunday, 10, 0); !s.nextChange(at(time.Saturday, 13, 0)).Equal(want) {
+		t.Errorf("nextChange = %s, want %s", s.nextChange(at(time.Saturday, 13, 0)), want)
+	}
+	if want := at(time.Saturday, 2, 0); !s.nextChange(at(time.Friday, 23, 0)).Equal(want) {
+		t.Errorf("nextChange = %s, want %s", s.nextChange(at(time.Friday, 23, 0)), want)
+	}
+
+	if _, ok := (&scheduler{clock: systemClock{}}).at("mic"); ok {
+		t.Error("at(mic) without schedules reports a schedule")
+	}
+}
+
+func TestParseScheduleTime(t *testing.T) {
+	now := at(time.Wednesday, 15, 30)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"14:00", at(time.Wednesday, 14, 0)},
+		{"mon 09:30", at(time.Monday, 9, 30)},
+		{"Sat 22:15", at(time.Saturday, 22, 15)},
+		{"2026-01-09T23:00:00Z", at(time.Friday, 23, 0)},
+	}
+	for _, tt := range tests {
+		got, err := parseScheduleTime(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseScheduleTime(%q) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseScheduleTime("noon", now); err == nil {
+		t.Error("parseScheduleTime(noon) succeeded, want an error")
+	}
+}
+
+func targetPercent(target *float32) *int {
+	if target == nil {
+		return nil
+	}
+	p := volumePercent(*target)
+	return &p
+}
+
+func sameInt(a, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+func deref(p *int) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// systrayRenderer draws the menu in the system tray
+type systrayRenderer struct {
+	items []*systray.MenuItem // top-level items, hidden when the menu is cleared
+}
+
+// systrayEntry is a menu item drawn in the system tray
+type systrayEntry struct {
+	item *systray.MenuItem
+}
+
+func (r *systrayRenderer) add(parent menuEntry, m *menuItem, clicked func()) menuEntry {
+	var item *systray.MenuItem
+	p, _ := parent.(*systrayEntry)
+	switch {
+	case p == nil && m.Checkbox:
+		item = systray.AddMenuItemCheckbox(m.Title, m.Tooltip, m.Checked)
+	case p == nil:
+		item = systray.AddMenuItem(m.Title, m.Tooltip)
+	case m.Checkbox:
+		item = p.item.AddSubMenuItemCheckbox(m.Title, m.Tooltip, m.Checked)
+	default:
+		item = p.item.AddSubMenuItem(m.Title, m.Tooltip)
+	}
+	if m.Disabled {
+		item.Disable()
+	}
+	if m.Hidden {
+		item.Hide()
+	}
+	if p == nil {
+		r.items = append(r.items, item)
+	}
+
+	go func() {
+		for range item.ClickedCh {
+			clicked()
+		}
+	}()
+	return &systrayEntry{item}
+}
+
+func (r *systrayRenderer) addSeparator() {
+	systray.AddSeparator()
+}
+
+// clear hides the drawn items, since the tray cannot remove them; separators
+// cannot be hidden and stay behind
+func (r *systrayRenderer) clear() {
+	for _, item := range r.items {
+		item.Hide()
+	}
+	r.items = nil
+}
+
+func (e *systrayEntry) setTitle(title string) {
+	e.item.SetTitle(title)
+}
+
+func (e *systrayEntry) setTooltip(tooltip string) {
+	e.item.SetTooltip(tooltip)
+}
+
+func (e *systrayEntry) setChecked(checked bool) {
+	if checked {
+		e.item.Check()
+	} else {
+		e.item.Uncheck()
+	}
+}
+
+func (e *systrayEntry) setDisabled(disabled bool) {
+	if disabled {
+		e.item.Disable()
+	} else {
+		e.item.Enable()
+	}
+}
+
+func (e *systrayEntry) setHidden(hidden bool) {
+	if hidden {
+		e.item.Hide()
+	} else {
+		e.item.Show()
+	}
+}
+
+// appMenuActions carries out menu commands on the running application
+type appMenuActions struct{}
+
+func (appMenuActions) setEnforced(deviceID string, enforced bool) {
+	setDeviceChecked(deviceID, enforced)
+}
+
+func (appMenuActions) setTarget(deviceID string, percent int) error {
+	return setDeviceTarget(deviceID, float32(percent)/100)
+}
+
+func (appMenuActions) setMutePolicy(deviceID, policy string) error {
+	return setDeviceMutePolicy(deviceID, policy)
+}
+
+func (appMenuActions) pause(deviceID string, d time.Duration) {
+	pauseEnforcement(deviceID, d)
+}
+
+func (appMenuActions) resume(deviceID string) {
+	resumeEnforcement(deviceID)
+}
+
+func (appMenuActions) makeDefault(deviceID string) error {
+	return makeDefaultInputDevice(deviceID)
+}
+
+func (appMenuActions) prompt(title, prompt, defaultValue string) (string, error) {
+	return promptText(title, prompt, defaultValue)
+}
+
+func (appMenuActions) rename(deviceID, alias string) error {
+	return renameDevice(deviceID, alias)
+}
+
+func (appMenuActions) setInputLevel(enabled bool) error {
+	return setTrayVUMeter(enabled)
+}
+
+func (appMenuActions) setNotifications(t eventType, enabled bool) error {
+	return setNotifications(t, enabled)
+}
+
+func (appMenuActions) setPushToTalkMode(mode string) error {
+	return setPushToTalkMode(mode)
+}
+
+func (appMenuActions) showLogs() error {
+	return showLogFile()
+}
+
+func (appMenuActions) quit() {
+	systray.Quit()
+}
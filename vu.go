@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// Settings for the tray VU indicator
+const (
+	vuWindow      = 50 * time.Millisecond
+	vuFloorDBFS   = -60.0
+	vuDecayDBPerS = 30.0 // how fast the indicator falls after a peak
+)
+
+// vuGlyphs are the tray title characters for rising input levels
+var vuGlyphs = []rune("▁▂▃▄▅▆▇█")
+
+// trayConfig holds the settings of the menu bar icon
+type trayConfig struct {
+	VUMeter bool `json:"vu_meter,omitempty"` // show the default device's input level next to the icon
+}
+
+// vuIndicator shows the live input level of the default device as the tray title
+type vuIndicator struct {
+	mu         sync.Mutex
+	cancel     context.CancelFunc
+	openSource func() (sampleSource, error) // nil to capture from the default device
+	setTitle   func(title string)           // nil to set the tray title
+}
+
+// Global tray VU indicator instance
+var vu = &vuIndicator{}
+
+// vuGlyph returns the indicator character for a level in dBFS
+func vuGlyph(dbfs float64) rune {
+	if !(dbfs > vuFloorDBFS) {
+		// At or below the floor, including silence at -Inf
+		return vuGlyphs[0]
+	}
+	fraction := (dbfs - vuFloorDBFS) / -vuFloorDBFS
+	i := int(fraction * float64(len(vuGlyphs)))
+	return vuGlyphs[max(0, min(len(vuGlyphs)-1, i))]
+}
+
+// running reports whether the indicator is shown
+func (v *vuIndicator) running() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.cancel != nil
+}
+
+// start begins capturing from the default input device and updating the
+// tray title with its peak level
+func (v *vuIndicator) start() error {
+	v.mu.Lock()
+	openSource, setTitle := v.openSource, v.setTitle
+	v.mu.Unlock()
+	if openSource == nil {
+		openSource = func() (sampleSource, error) { return newCaptureSource("") }
+	}
+	if setTitle == nil {
+		setTitle = systray.SetTitle
+	}
+
+	source, err := openSource()
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cancel != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancel = cancel
+
+	go func() {
+		level := vuFloorDBFS
+		var shown rune
+		decay := vuDecayDBPerS * vuWindow.Seconds()
+		err := runMeter(ctx, source, vuWindow, func(r levelReading) {
+			// Jump up to peaks and fall back slowly so the indicator is readable
+			level = math.Max(r.PeakDBFS, level-decay)
+			if glyph := vuGlyph(level); glyph != shown {
+				shown = glyph
+				setTitle(string(glyph))
+			}
+		})
+		if err != nil {
+			slog.Error("Input level indicator stopped", "error", err)
+		}
+
+		// The title is left to a meter started in the meantime
+		v.mu.Lock()
+		if ctx.Err() == nil {
+			v.cancel = nil
+		}
+		if v.cancel == nil {
+			setTitle("")
+		}
+		v.mu.Unlock()
+	}()
+
+	slog.Debug("Started tray input level indicator")
+	return nil
+}
+
+// stop ends the capture and clears the tray title
+func (v *vuIndicator) stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cancel != nil {
+		v.cancel()
+		v.cancel = nil
+	}
+}
+
+// restart moves a running indicator over to a new default input device, as
+// the capture stays on the device that was the default when it started
+func (v *vuIndicator) restart() {
+	if !v.running() {
+		return
+	}
+	v.stop()
+	if err := v.start(); err != nil {
+		slog.Error("Failed to show input level of the new default device", "error", err)
+		return
+	}
+	slog.Debug("Moved tray input level indicator to the new default device")
+}
+
+// setTrayVUMeter shows or hides the indicator and saves the preference
+func setTrayVUMeter(enabled bool) error {
+	if enabled {
+		if err := vu.start(); err != nil {
+			return err
+		}
+	} else {
+		vu.stop()
+	}
+
+	return updateConfig(func(cfg *appConfig) {
+		if cfg.Tray == nil {
+			cfg.Tray = &trayConfig{}
+		}
+		cfg.Tray.VUMeter = enabled
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+func TestVUGlyph(t *testing.T) {
+	tests := []struct {
+		dbfs float64
+		want rune
+	}{
+		{math.Inf(-1), '▁'},
+		{math.NaN(), '▁'},
+		{-100, '▁'},
+		{vuFloorDBFS, '▁'},
+		{-52.6, '▁'},
+		{-52.5, '▂'}, // each glyph covers 7.5 dB above the floor
+		{-30, '▅'},
+		{-7.6, '▇'},
+		{-7.5, '█'},
+		{0, '█'},
+		{6, '█'},
+	}
+	for _, tt := range tests {
+		if got := vuGlyph(tt.dbfs); got != tt.want {
+			t.Errorf("vuGlyph(%v) = %c, want %c", tt.dbfs, got, tt.want)
+		}
+	}
+}
+
+// heldSource is a live sample source delivering nothing until cancelled
+type heldSource struct{ stopped chan struct{} }
+
+func (heldSource) format() (int, int) { return meterSampleRate, 1 }
+func (heldSource) live() bool         { return true }
+func (s heldSource) run(ctx context.Context, fn func([]float32)) error {
+	<-ctx.Done()
+	close(s.stopped)
+	return nil
+}
+
+func TestVUIndicatorFollowsDefaultDevice(t *testing.T) {
+	st := useTestState(t)
+	var a, b malgo.DeviceInfo
+	a.ID[0], b.ID[0] = 0x0a, 0x0b
+	a.IsDefault = 1
+	st.audioInputDevices = []malgo.DeviceInfo{a, b}
+
+	var mu sync.Mutex
+	var sources []heldSource
+	savedVU := vu
+	vu = &vuIndicator{
+		openSource: func() (sampleSource, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			source := heldSource{stopped: make(chan struct{})}
+			sources = append(sources, source)
+			return source, nil
+		},
+		setTitle: func(string) {},
+	}
+	t.Cleanup(func() {
+		vu.stop()
+		vu = savedVU
+	})
+	opened := func() []heldSource {
+		mu.Lock()
+		defer mu.Unlock()
+		return sources
+	}
+
+	// A rescan that leaves the default alone keeps the meter
+	if err := vu.start(); err != nil {
+		t.Fatal(err)
+	}
+	updateAudioInputDevices([]malgo.DeviceInfo{a, b})
+	if n := len(opened()); n != 1 {
+		t.Fatalf("%d captures opened without a change of default, want 1", n)
+	}
+
+	// Another default stops the meter and starts one on the new default
+	a.IsDefault, b.IsDefault = 0, 1
+	updateAudioInputDevices([]malgo.DeviceInfo{a, b})
+	got := opened()
+	if len(got) != 2 {
+		t.Fatalf("%d captures opened after the default changed, want 2", len(got))
+	}
+	select {
+	case <-got[0].stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the meter of the old default device was not stopped")
+	}
+	if !vu.running() {
+		t.Error("the indicator is not running on the new default device")
+	}
+
+	// A stopped indicator is not restarted
+	vu.stop()
+	a.IsDefault, b.IsDefault = 1, 0
+	updateAudioInputDevices([]malgo.DeviceInfo{a, b})
+	if n := len(opened()); n != 2 || vu.running() {
+		t.Errorf("after a change while stopped, %d captures opened and running %v; want 2 and false", n, vu.running())
+	}
+}